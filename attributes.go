@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+// updateS3ObjectAttributes updates the attributes with a copy of the object onto itself,
+// S3 can't change the attributes of a stored object. The content isn't transferred through the process,
+// the encryption and the storage class of the object are kept.
+func updateS3ObjectAttributes(
+	ctx context.Context,
+	bucket *blob.Bucket,
+	bucketName string,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	srcAttrs, err := bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := newBlobAttributes(srcAttrs)
+
+	if patch == nil {
+		return attrs, nil
+	}
+
+	patch.apply(attrs)
+
+	if err := replaceBucketObject(ctx, bucket, bucketName, srcAttrs, key, key, attrs); err != nil {
+		return nil, err
+	}
+
+	updatedAttrs, err := bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlobAttributes(updatedAttrs), nil
+}
+
+// updateGCSObjectAttributes updates the attributes with a single PATCH request.
+func updateGCSObjectAttributes(
+	ctx context.Context,
+	client *storage.Client,
+	bucketName string,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	object := client.Bucket(bucketName).Object(key)
+
+	var (
+		attrs *storage.ObjectAttrs
+		err   error
+	)
+
+	if patch == nil {
+		attrs, err = object.Attrs(ctx)
+	} else {
+		attrs, err = object.Update(ctx, newGCSObjectAttrsToUpdate(patch))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newGCSAttributes(attrs), nil
+}
+
+// newGCSObjectAttrsToUpdate converts the patch, GCS merges the metadata keys by itself.
+func newGCSObjectAttrsToUpdate(patch *AttributesPatch) storage.ObjectAttrsToUpdate {
+	update := storage.ObjectAttrsToUpdate{}
+
+	if patch.CacheControl != nil {
+		update.CacheControl = *patch.CacheControl
+	}
+
+	if patch.ContentDisposition != nil {
+		update.ContentDisposition = *patch.ContentDisposition
+	}
+
+	if patch.ContentEncoding != nil {
+		update.ContentEncoding = *patch.ContentEncoding
+	}
+
+	if patch.ContentLanguage != nil {
+		update.ContentLanguage = *patch.ContentLanguage
+	}
+
+	if patch.ContentType != nil {
+		update.ContentType = *patch.ContentType
+	}
+
+	// an empty map would delete all the metadata
+	if len(patch.Metadata) > 0 {
+		update.Metadata = patch.Metadata
+	}
+
+	return update
+}
+
+// newBlobAttributes converts the attributes of the providers based on gocloud.dev/blob,
+// the entity tag and the generations are taken from the provider specific attributes.
+func newBlobAttributes(attrs *blob.Attributes) *Attributes {
+	result := &Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		ModTime:            attrs.ModTime,
+		Size:               attrs.Size,
+		MD5:                attrs.MD5,
+	}
+
+	var s3Attrs s3.HeadObjectOutput
+	if attrs.As(&s3Attrs) {
+		result.ETag = aws.StringValue(s3Attrs.ETag)
+	}
+
+	var gcsAttrs storage.ObjectAttrs
+	if attrs.As(&gcsAttrs) {
+		result.ETag = gcsAttrs.Etag
+		result.Generation = gcsAttrs.Generation
+		result.Metageneration = gcsAttrs.Metageneration
+	}
+
+	return result
+}
+
+func newGCSAttributes(attrs *storage.ObjectAttrs) *Attributes {
+	return &Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		ModTime:            attrs.Updated,
+		Size:               attrs.Size,
+		MD5:                attrs.MD5,
+		ETag:               attrs.Etag,
+		Generation:         attrs.Generation,
+		Metageneration:     attrs.Metageneration,
+	}
+}
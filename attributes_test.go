@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+// copyServer is a fake S3 holding a single encrypted STANDARD_IA object, it records the copy requests.
+type copyServer struct {
+	size int64
+
+	mu      sync.Mutex
+	copies  []http.Header
+	uploads []http.Header
+	ranges  []string
+	done    bool
+	deleted bool
+}
+
+func (s *copyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodHead && r.URL.Path == "/bucket/user/1.json":
+		w.Header().Set("Content-Length", strconv.FormatInt(s.size, 10))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-amz-meta-owner", "1")
+		w.Header().Set("x-amz-server-side-encryption", "aws:kms")
+		w.Header().Set("x-amz-server-side-encryption-aws-kms-key-id", "key-1")
+		w.Header().Set("x-amz-storage-class", "STANDARD_IA")
+	case r.Method == http.MethodPut && query.Get("partNumber") != "":
+		s.ranges = append(s.ranges, r.Header.Get("x-amz-copy-source-range"))
+		s.copies = append(s.copies, r.Header)
+
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<CopyPartResult><ETag>"part%s"</ETag></CopyPartResult>`, query.Get("partNumber"))
+	case r.Method == http.MethodPut:
+		s.copies = append(s.copies, r.Header)
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPost && uploads:
+		s.uploads = append(s.uploads, r.Header)
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>user/1.json</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload":
+		s.done = true
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>user/1.json</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodDelete && r.URL.Path == "/bucket/user/1.json":
+		s.deleted = true
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newCopyStorage(t *testing.T, server *copyServer) commonblobgo.CloudStorage {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), false, "aws", "bucket",
+		commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        httpServer.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     "key",
+			AWSS3SecretAccessKey: "secret",
+		})
+	require.NoError(t, err)
+
+	return storage
+}
+
+// requireKeptEncryption checks the request keeps the encryption and the storage class of the object.
+func requireKeptEncryption(t *testing.T, header http.Header) {
+	require.Equal(t, "aws:kms", header.Get("x-amz-server-side-encryption"))
+	require.Equal(t, "key-1", header.Get("x-amz-server-side-encryption-aws-kms-key-id"))
+	require.Equal(t, "STANDARD_IA", header.Get("x-amz-storage-class"))
+	require.Equal(t, "no-cache", header.Get("Cache-Control"))
+	require.Equal(t, "1", header.Get("x-amz-meta-owner"))
+}
+
+// requireKeptAttributes checks the request of a copy keeping the attributes sets them again.
+func requireKeptAttributes(t *testing.T, header http.Header) {
+	require.Equal(t, "aws:kms", header.Get("x-amz-server-side-encryption"))
+	require.Equal(t, "key-1", header.Get("x-amz-server-side-encryption-aws-kms-key-id"))
+	require.Equal(t, "STANDARD_IA", header.Get("x-amz-storage-class"))
+	require.Equal(t, "application/json", header.Get("Content-Type"))
+	require.Equal(t, "1", header.Get("x-amz-meta-owner"))
+}
+
+func TestCopyS3KeepsAttributes(t *testing.T) {
+	server := &copyServer{size: 1024}
+	storage := newCopyStorage(t, server)
+
+	require.NoError(t, storage.Copy(context.Background(), "user/1.json", "user/2.json", nil))
+
+	require.Len(t, server.copies, 1)
+	require.Empty(t, server.uploads)
+	require.Equal(t, "bucket/user/1.json", server.copies[0].Get("x-amz-copy-source"))
+	requireKeptAttributes(t, server.copies[0])
+}
+
+func TestMoveS3LargeObject(t *testing.T) {
+	const partSize = 512 * 1024 * 1024
+
+	server := &copyServer{size: 12*partSize + 1}
+	storage := newCopyStorage(t, server)
+
+	require.NoError(t, storage.Move(context.Background(), "user/1.json", "user/2.json"))
+
+	// objects over 5 GiB are copied in parts of a multipart upload
+	require.Len(t, server.uploads, 1)
+	requireKeptAttributes(t, server.uploads[0])
+	require.Len(t, server.ranges, 13)
+	require.True(t, server.done)
+	require.True(t, server.deleted)
+}
+
+func TestUpdateAttributesS3KeepsEncryption(t *testing.T) {
+	server := &copyServer{size: 1024}
+	storage := newCopyStorage(t, server)
+	cacheControl := "no-cache"
+
+	_, err := storage.UpdateAttributes(context.Background(), "user/1.json", &commonblobgo.AttributesPatch{
+		CacheControl: &cacheControl,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, server.copies, 1)
+	require.Empty(t, server.uploads)
+	require.Equal(t, "bucket/user/1.json", server.copies[0].Get("x-amz-copy-source"))
+	require.Equal(t, "REPLACE", server.copies[0].Get("x-amz-metadata-directive"))
+	requireKeptEncryption(t, server.copies[0])
+}
+
+func TestUpdateAttributesS3LargeObject(t *testing.T) {
+	const partSize = 512 * 1024 * 1024
+
+	server := &copyServer{size: 12*partSize + 1}
+	storage := newCopyStorage(t, server)
+	cacheControl := "no-cache"
+
+	_, err := storage.UpdateAttributes(context.Background(), "user/1.json", &commonblobgo.AttributesPatch{
+		CacheControl: &cacheControl,
+	})
+	require.NoError(t, err)
+
+	// objects over 5 GiB are copied in parts of a multipart upload
+	require.Len(t, server.uploads, 1)
+	requireKeptEncryption(t, server.uploads[0])
+
+	require.Len(t, server.ranges, 13)
+	require.Equal(t, fmt.Sprintf("bytes=0-%d", partSize-1), server.ranges[0])
+	require.Equal(t, fmt.Sprintf("bytes=%d-%d", 12*partSize, 12*partSize), server.ranges[12])
+	require.Equal(t, `"etag"`, server.copies[0].Get("x-amz-copy-source-if-match"))
+	require.True(t, server.done)
+}
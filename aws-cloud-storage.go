@@ -18,11 +18,17 @@ package commonblobgo
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/s3blob"
 )
@@ -37,8 +43,10 @@ func newAWSCloudStorage(
 	ctx context.Context,
 	s3Endpoint string,
 	s3Region string,
+	creds *credentials.Credentials,
 	bucketName string,
 	accelerateEndpoint *bool,
+	options *storageOptions,
 ) (*AWSCloudStorage, error) {
 	// create vanilla AWS client
 	var awsConfig aws.Config
@@ -58,7 +66,9 @@ func newAWSCloudStorage(
 		}
 	}
 
-	awsSession, err := session.NewSession(&awsConfig)
+	awsConfig.Credentials = creds
+
+	awsSession, err := newAWSSession(&awsConfig, options)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +78,7 @@ func newAWSCloudStorage(
 		return nil, err
 	}
 
-	logrus.Infof("AWSCloudStorage created")
+	options.logger.Info("AWSCloudStorage created", "bucket", bucketName)
 
 	return &AWSCloudStorage{
 		bucketName: bucketName,
@@ -79,6 +89,45 @@ func newAWSCloudStorage(
 	}, nil
 }
 
+// newAWSSession configures the SDK with the HTTP client, the retry policy and the user agent of the options.
+// The user agent is added by the SDK, a wrapped transport would break AWS_CA_BUNDLE support.
+func newAWSSession(awsConfig *aws.Config, options *storageOptions) (*session.Session, error) {
+	awsConfig.HTTPClient = options.httpClient
+
+	if options.retry != nil {
+		// retried by retryingCloudStorage
+		awsConfig.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+	}
+
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	awsSession.Handlers.Build.PushBack(addS3ConditionalHeaders)
+
+	if options.userAgent != "" {
+		awsSession.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(options.userAgent))
+	}
+
+	return awsSession, nil
+}
+
+// newAWSCredentials returns static credentials of the given keys, the keys are kept per storage,
+// so storages of different accounts can live in the same process. Without keys the SDK falls back
+// to the default credentials chain (environment, shared credentials file, IAM role).
+func newAWSCredentials(accessKeyID, secretAccessKey string) (*credentials.Credentials, error) {
+	if accessKeyID == "" && secretAccessKey == "" {
+		return nil, nil
+	}
+
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("both AWS access key ID and secret access key are required")
+	}
+
+	return credentials.NewStaticCredentials(accessKeyID, secretAccessKey, ""), nil
+}
+
 func (ts *AWSCloudStorage) List(
 	ctx context.Context,
 	prefix string,
@@ -87,7 +136,7 @@ func (ts *AWSCloudStorage) List(
 		Prefix: prefix,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -107,11 +156,12 @@ func (ts *AWSCloudStorage) ListWithOptions(
 	listOptions *ListOptions,
 ) *ListIterator {
 	iter := ts.bucket.List(&blob.ListOptions{
-		Prefix:    listOptions.Prefix,
-		Delimiter: listOptions.Delimiter,
+		Prefix:     listOptions.Prefix,
+		Delimiter:  listOptions.Delimiter,
+		BeforeList: s3StartAfter(listOptions.StartAfter),
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return filterListIterator(listOptions, newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -124,21 +174,37 @@ func (ts *AWSCloudStorage) ListWithOptions(
 			MD5:     attrs.MD5,
 			IsDir:   attrs.IsDir,
 		}, nil
-	})
+	}))
+}
+
+func (ts *AWSCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	client, err := ts.s3Client()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listS3Page(ctx, client, ts.bucketName, opts, pageToken, pageSize)
 }
 
 func (ts *AWSCloudStorage) Get(
 	ctx context.Context,
 	key string,
 ) ([]byte, error) {
-	return ts.bucket.ReadAll(ctx, key)
+	body, err := ts.bucket.ReadAll(ctx, key)
+
+	return body, wrapError(err)
 }
 
 func (ts *AWSCloudStorage) GetReader(
 	ctx context.Context,
 	key string,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewReader(ctx, key, nil)
+	return wrapReader(ts.bucket.NewReader(ctx, key, nil))
 }
 
 func (ts *AWSCloudStorage) GetRangeReader(
@@ -147,14 +213,39 @@ func (ts *AWSCloudStorage) GetRangeReader(
 	offset,
 	length int64,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	return wrapReader(ts.bucket.NewRangeReader(ctx, key, offset, length, nil))
 }
 
 func (ts *AWSCloudStorage) GetWriter(
 	ctx context.Context,
 	key string,
 ) (io.WriteCloser, error) {
-	return ts.bucket.NewWriter(ctx, key, nil)
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *AWSCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := opts.preconditions().validateWrite(s3Preconditions); err != nil {
+		return nil, err
+	}
+
+	if err := opts.upload().validate(s3manager.MinUploadPartSize); err != nil {
+		return nil, err
+	}
+
+	ctx = withS3ConditionalHeaders(ctx, opts.preconditions())
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		var client *s3.S3
+		if opts.upload() != nil && ts.bucket.As(&client) {
+			return wrapWriter(newS3UploadWriter(ctx, client, ts.bucketName, key, opts), nil)
+		}
+
+		return wrapWriter(ts.bucket.NewWriter(ctx, key, opts.blobWriterOptions()))
+	})
 }
 
 func (ts *AWSCloudStorage) CreateBucket(
@@ -166,6 +257,10 @@ func (ts *AWSCloudStorage) CreateBucket(
 	return nil
 }
 
+func (ts *AWSCloudStorage) describe() (provider, bucket string) {
+	return "aws", ts.bucketName
+}
+
 func (ts *AWSCloudStorage) Close() {
 	ts.bucketCloseFunc()
 }
@@ -182,7 +277,9 @@ func (ts *AWSCloudStorage) GetSignedURL(
 		EnforceAbsentContentType: opts.EnforceAbsentContentType,
 	}
 
-	return ts.bucket.SignedURL(context.Background(), key, options)
+	signedURL, err := ts.bucket.SignedURL(context.Background(), key, options)
+
+	return signedURL, wrapError(err)
 }
 
 func (ts *AWSCloudStorage) Write(
@@ -191,19 +288,82 @@ func (ts *AWSCloudStorage) Write(
 	body []byte,
 	contentType *string,
 ) error {
-	options := &blob.WriterOptions{}
-	if contentType != nil {
-		options.ContentType = *contentType
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *AWSCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := opts.preconditions().validateWrite(s3Preconditions); err != nil {
+		return err
 	}
 
-	return ts.bucket.WriteAll(ctx, key, body, options)
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	ctx = withS3ConditionalHeaders(ctx, opts.preconditions())
+
+	return opts.preconditions().wrapError(key, wrapError(ts.bucket.WriteAll(ctx, key, body, opts.blobWriterOptions())))
 }
 
 func (ts *AWSCloudStorage) Delete(
 	ctx context.Context,
 	key string,
 ) error {
-	return ts.bucket.Delete(ctx, key)
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *AWSCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(s3Preconditions); err != nil {
+		return err
+	}
+
+	err := ts.bucket.Delete(withS3ConditionalHeaders(ctx, preconditions), key)
+
+	return preconditions.wrapError(key, wrapError(err))
+}
+
+// DeleteMany deletes up to 1000 keys with a single DeleteObjects call.
+func (ts *AWSCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	var client *s3.S3
+	if !ts.bucket.As(&client) {
+		return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+	}
+
+	return deleteS3Objects(ctx, client, ts.bucketName, keys)
+}
+
+func (ts *AWSCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *AWSCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
 }
 
 func (ts *AWSCloudStorage) Attributes(
@@ -212,25 +372,93 @@ func (ts *AWSCloudStorage) Attributes(
 ) (*Attributes, error) {
 	attrs, err := ts.bucket.Attributes(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
-	return &Attributes{
-		CacheControl:       attrs.CacheControl,
-		ContentDisposition: attrs.ContentDisposition,
-		ContentEncoding:    attrs.ContentEncoding,
-		ContentLanguage:    attrs.ContentLanguage,
-		ContentType:        attrs.ContentType,
-		Metadata:           attrs.Metadata,
-		ModTime:            attrs.ModTime,
-		Size:               attrs.Size,
-		MD5:                attrs.MD5,
-	}, nil
+	return newBlobAttributes(attrs), nil
 }
 
 func (ts *AWSCloudStorage) Exists(
 	ctx context.Context,
 	key string,
 ) (bool, error) {
-	return ts.bucket.Exists(ctx, key)
+	exists, err := ts.bucket.Exists(ctx, key)
+
+	return exists, wrapError(err)
+}
+
+func (ts *AWSCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	return wrapError(copyBucketObject(ctx, ts.bucket, ts.bucketName, srcKey, dstKey, opts))
+}
+
+func (ts *AWSCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+func (ts *AWSCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	attrs, err := updateS3ObjectAttributes(ctx, ts.bucket, ts.bucketName, key, patch)
+
+	return attrs, wrapError(err)
+}
+
+// StartUpload starts a resumable multipart upload, the parts are at least 5 MiB.
+func (ts *AWSCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	client, err := ts.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	return startS3Upload(ctx, ts, client, ts.bucketName, key, opts)
+}
+
+func (ts *AWSCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	client, err := ts.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	return resumeS3Upload(ctx, ts, client, ts.bucketName, token)
+}
+
+// AbortStaleUploads aborts the multipart uploads started before the TTL, GetWriter ones included.
+func (ts *AWSCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	client, err := ts.s3Client()
+	if err != nil {
+		return 0, err
+	}
+
+	return abortStaleS3Uploads(ctx, client, ts.bucketName, ttl)
+}
+
+// s3Client returns the client of the bucket for the calls gocloud.dev/blob doesn't cover.
+func (ts *AWSCloudStorage) s3Client() (*s3.S3, error) {
+	var client *s3.S3
+	if !ts.bucket.As(&client) {
+		return nil, fmt.Errorf("unable to access the S3 client of bucket '%s'", ts.bucketName)
+	}
+
+	return client, nil
 }
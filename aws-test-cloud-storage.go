@@ -20,16 +20,18 @@ import (
 	"context"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/s3blob"
 )
 
 type AWSTestCloudStorage struct {
+	logger          Logger
 	client          *s3.S3
 	bucket          *blob.Bucket
 	bucketName      string
@@ -40,7 +42,9 @@ func newAWSTestCloudStorage(
 	ctx context.Context,
 	s3Endpoint string,
 	s3Region string,
+	creds *credentials.Credentials,
 	bucketName string,
+	options *storageOptions,
 ) (*AWSTestCloudStorage, error) {
 	// create vanilla AWS client
 	var awsConfig aws.Config
@@ -58,7 +62,9 @@ func newAWSTestCloudStorage(
 		}
 	}
 
-	awsSession, err := session.NewSession(&awsConfig)
+	awsConfig.Credentials = creds
+
+	awsSession, err := newAWSSession(&awsConfig, options)
 	if err != nil {
 		return nil, err
 	}
@@ -70,9 +76,10 @@ func newAWSTestCloudStorage(
 		return nil, err
 	}
 
-	logrus.Infof("AWSTestCloudStorage created")
+	options.logger.Info("AWSTestCloudStorage created", "bucket", bucketName)
 
 	return &AWSTestCloudStorage{
+		logger:     options.logger,
 		client:     client,
 		bucketName: bucketName,
 		bucket:     bucket,
@@ -90,7 +97,7 @@ func (ts *AWSTestCloudStorage) List(
 		Prefix: prefix,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -110,11 +117,12 @@ func (ts *AWSTestCloudStorage) ListWithOptions(
 	listOptions *ListOptions,
 ) *ListIterator {
 	iter := ts.bucket.List(&blob.ListOptions{
-		Prefix:    listOptions.Prefix,
-		Delimiter: listOptions.Delimiter,
+		Prefix:     listOptions.Prefix,
+		Delimiter:  listOptions.Delimiter,
+		BeforeList: s3StartAfter(listOptions.StartAfter),
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return filterListIterator(listOptions, newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -127,21 +135,32 @@ func (ts *AWSTestCloudStorage) ListWithOptions(
 			MD5:     attrs.MD5,
 			IsDir:   attrs.IsDir,
 		}, nil
-	})
+	}))
+}
+
+func (ts *AWSTestCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	return listS3Page(ctx, ts.client, ts.bucketName, opts, pageToken, pageSize)
 }
 
 func (ts *AWSTestCloudStorage) Get(
 	ctx context.Context,
 	key string,
 ) ([]byte, error) {
-	return ts.bucket.ReadAll(ctx, key)
+	body, err := ts.bucket.ReadAll(ctx, key)
+
+	return body, wrapError(err)
 }
 
 func (ts *AWSTestCloudStorage) GetReader(
 	ctx context.Context,
 	key string,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewReader(ctx, key, nil)
+	return wrapReader(ts.bucket.NewReader(ctx, key, nil))
 }
 
 func (ts *AWSTestCloudStorage) GetRangeReader(
@@ -150,14 +169,38 @@ func (ts *AWSTestCloudStorage) GetRangeReader(
 	offset,
 	length int64,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	return wrapReader(ts.bucket.NewRangeReader(ctx, key, offset, length, nil))
 }
 
 func (ts *AWSTestCloudStorage) GetWriter(
 	ctx context.Context,
 	key string,
 ) (io.WriteCloser, error) {
-	return ts.bucket.NewWriter(ctx, key, nil)
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *AWSTestCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := opts.preconditions().validateWrite(s3Preconditions); err != nil {
+		return nil, err
+	}
+
+	if err := opts.upload().validate(s3manager.MinUploadPartSize); err != nil {
+		return nil, err
+	}
+
+	ctx = withS3ConditionalHeaders(ctx, opts.preconditions())
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		if opts.upload() != nil {
+			return wrapWriter(newS3UploadWriter(ctx, ts.client, ts.bucketName, key, opts), nil)
+		}
+
+		return wrapWriter(ts.bucket.NewWriter(ctx, key, opts.blobWriterOptions()))
+	})
 }
 
 func (ts *AWSTestCloudStorage) CreateBucket(
@@ -165,7 +208,7 @@ func (ts *AWSTestCloudStorage) CreateBucket(
 	bucketPrefix string,
 	expirationTimeDays int64,
 ) error {
-	logrus.Printf("CreateBucket. Name: %s, Prefix: %s, Exp Time: %v", ts.bucketName, bucketPrefix, expirationTimeDays)
+	ts.logger.Info("CreateBucket", "bucket", ts.bucketName, "prefix", bucketPrefix, "expirationTimeDays", expirationTimeDays)
 
 	if _, err := ts.client.CreateBucket(&s3.CreateBucketInput{
 		Bucket: aws.String(ts.bucketName)}); err != nil {
@@ -173,9 +216,9 @@ func (ts *AWSTestCloudStorage) CreateBucket(
 			return nil
 		}
 
-		logrus.Errorf("unable to create bucket '%s': %v", ts.bucketName, err)
+		ts.logger.Error("unable to create bucket", "bucket", ts.bucketName, "error", err)
 
-		return err
+		return wrapError(err)
 	}
 
 	bucketConf := s3.BucketLifecycleConfiguration{
@@ -202,7 +245,7 @@ func (ts *AWSTestCloudStorage) CreateBucket(
 			LifecycleConfiguration: &bucketConf,
 		})
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
 
 	_, err = ts.client.ListObjects(&s3.ListObjectsInput{
@@ -210,15 +253,19 @@ func (ts *AWSTestCloudStorage) CreateBucket(
 		MaxKeys: aws.Int64(1), // nolint:gomnd
 	})
 	if err != nil {
-		logrus.Errorf("unable access bucket '%s': %v", ts.bucketName, err)
-		return err
+		ts.logger.Error("unable to access bucket", "bucket", ts.bucketName, "error", err)
+		return wrapError(err)
 	}
 
-	logrus.Printf("Bucket %v created.\n", ts.bucketName)
+	ts.logger.Info("bucket created", "bucket", ts.bucketName)
 
 	return nil
 }
 
+func (ts *AWSTestCloudStorage) describe() (provider, bucket string) {
+	return "aws", ts.bucketName
+}
+
 func (ts *AWSTestCloudStorage) Close() {
 	ts.bucketCloseFunc()
 }
@@ -234,7 +281,10 @@ func (ts *AWSTestCloudStorage) GetSignedURL(
 		ContentType:              opts.ContentType,
 		EnforceAbsentContentType: opts.EnforceAbsentContentType,
 	}
-	return ts.bucket.SignedURL(context.Background(), key, options)
+
+	signedURL, err := ts.bucket.SignedURL(context.Background(), key, options)
+
+	return signedURL, wrapError(err)
 }
 
 func (ts *AWSTestCloudStorage) Write(
@@ -243,19 +293,77 @@ func (ts *AWSTestCloudStorage) Write(
 	body []byte,
 	contentType *string,
 ) error {
-	options := &blob.WriterOptions{}
-	if contentType != nil {
-		options.ContentType = *contentType
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *AWSTestCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := opts.preconditions().validateWrite(s3Preconditions); err != nil {
+		return err
 	}
 
-	return ts.bucket.WriteAll(ctx, key, body, options)
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	ctx = withS3ConditionalHeaders(ctx, opts.preconditions())
+
+	return opts.preconditions().wrapError(key, wrapError(ts.bucket.WriteAll(ctx, key, body, opts.blobWriterOptions())))
 }
 
 func (ts *AWSTestCloudStorage) Delete(
 	ctx context.Context,
 	key string,
 ) error {
-	return ts.bucket.Delete(ctx, key)
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *AWSTestCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(s3Preconditions); err != nil {
+		return err
+	}
+
+	err := ts.bucket.Delete(withS3ConditionalHeaders(ctx, preconditions), key)
+
+	return preconditions.wrapError(key, wrapError(err))
+}
+
+// DeleteMany deletes up to 1000 keys with a single DeleteObjects call.
+func (ts *AWSTestCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteS3Objects(ctx, ts.client, ts.bucketName, keys)
+}
+
+func (ts *AWSTestCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *AWSTestCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
 }
 
 func (ts *AWSTestCloudStorage) Attributes(
@@ -264,25 +372,68 @@ func (ts *AWSTestCloudStorage) Attributes(
 ) (*Attributes, error) {
 	attrs, err := ts.bucket.Attributes(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
-	return &Attributes{
-		CacheControl:       attrs.CacheControl,
-		ContentDisposition: attrs.ContentDisposition,
-		ContentEncoding:    attrs.ContentEncoding,
-		ContentLanguage:    attrs.ContentLanguage,
-		ContentType:        attrs.ContentType,
-		Metadata:           attrs.Metadata,
-		ModTime:            attrs.ModTime,
-		Size:               attrs.Size,
-		MD5:                attrs.MD5,
-	}, nil
+	return newBlobAttributes(attrs), nil
 }
 
 func (ts *AWSTestCloudStorage) Exists(
 	ctx context.Context,
 	key string,
 ) (bool, error) {
-	return ts.bucket.Exists(ctx, key)
+	exists, err := ts.bucket.Exists(ctx, key)
+
+	return exists, wrapError(err)
+}
+
+func (ts *AWSTestCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	return wrapError(copyBucketObject(ctx, ts.bucket, ts.bucketName, srcKey, dstKey, opts))
+}
+
+func (ts *AWSTestCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+func (ts *AWSTestCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	attrs, err := updateS3ObjectAttributes(ctx, ts.bucket, ts.bucketName, key, patch)
+
+	return attrs, wrapError(err)
+}
+
+// StartUpload starts a resumable multipart upload, the parts are at least 5 MiB.
+func (ts *AWSTestCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	return startS3Upload(ctx, ts, ts.client, ts.bucketName, key, opts)
+}
+
+func (ts *AWSTestCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeS3Upload(ctx, ts, ts.client, ts.bucketName, token)
+}
+
+// AbortStaleUploads aborts the multipart uploads started before the TTL, GetWriter ones included.
+func (ts *AWSTestCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	return abortStaleS3Uploads(ctx, ts.client, ts.bucketName, ttl)
 }
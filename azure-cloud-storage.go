@@ -0,0 +1,1019 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/uuid"
+)
+
+const (
+	azureMaxDownloadRetryRequests = 3
+	azureUploadBufferSize         = 8 * 1024 * 1024
+	azureUploadMaxBuffers         = 5
+	azureCopyPollInterval         = 500 * time.Millisecond
+	// azureStagingPrefix holds the staging blobs of the resumable uploads
+	azureStagingPrefix = reservedUploadsPrefix
+)
+
+type AzureCloudStorage struct {
+	containerURL azblob.ContainerURL
+	credential   *azblob.SharedKeyCredential
+	bucketName   string
+}
+
+func newAzureCloudStorage(
+	ctx context.Context,
+	endpoint string,
+	accountName string,
+	accountKey string,
+	bucketName string,
+	options *storageOptions,
+) (*AzureCloudStorage, error) {
+	if accountName == "" {
+		return nil, fmt.Errorf("unable to create Azure client without storage account name")
+	}
+
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+	}
+
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure storage endpoint '%s': %v", endpoint, err)
+	}
+
+	// without an account key the client is anonymous and only able to access public containers,
+	// signed URLs require the shared key
+	var (
+		credential    azblob.Credential = azblob.NewAnonymousCredential()
+		sharedKeyCred *azblob.SharedKeyCredential
+	)
+
+	if accountKey != "" {
+		sharedKeyCred, err = azblob.NewSharedKeyCredential(accountName, accountKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to initialize Azure shared key credential: %v", err)
+		}
+
+		credential = sharedKeyCred
+	}
+
+	pipelineOptions := azblob.PipelineOptions{}
+
+	if options.retry != nil {
+		// retried by retryingCloudStorage
+		pipelineOptions.Retry = azblob.RetryOptions{MaxTries: 1}
+	}
+
+	if httpClient := options.newHTTPClient(); httpClient != nil {
+		pipelineOptions.HTTPSender = newAzureHTTPSender(httpClient)
+	}
+
+	containerURL := azblob.NewServiceURL(*endpointURL, azblob.NewPipeline(credential, pipelineOptions)).NewContainerURL(bucketName)
+
+	options.logger.Info("AzureCloudStorage created", "bucket", bucketName)
+
+	return &AzureCloudStorage{
+		containerURL: containerURL,
+		credential:   sharedKeyCred,
+		bucketName:   bucketName,
+	}, nil
+}
+
+// newAzureHTTPSender sends the pipeline requests with the client.
+func newAzureHTTPSender(client *http.Client) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			response, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+
+			return pipeline.NewHTTPResponse(response), err
+		}
+	})
+}
+
+// blobURL returns the URL of the blob, the keys under the reserved prefix are rejected.
+func (ts *AzureCloudStorage) blobURL(key string) (azblob.BlockBlobURL, error) {
+	if err := checkReservedKey("Azure", key); err != nil {
+		return azblob.BlockBlobURL{}, err
+	}
+
+	return ts.containerURL.NewBlockBlobURL(key), nil
+}
+
+func (ts *AzureCloudStorage) List(
+	ctx context.Context,
+	prefix string,
+) *ListIterator {
+	return ts.ListWithOptions(ctx, &ListOptions{
+		Prefix: prefix,
+	})
+}
+
+func (ts *AzureCloudStorage) ListWithOptions(
+	ctx context.Context,
+	listOptions *ListOptions,
+) *ListIterator {
+	var (
+		marker azblob.Marker
+		page   []*ListObject
+	)
+
+	return filterListIterator(listOptions, newListIterator(func(ctx context.Context) (*ListObject, error) {
+		for len(page) == 0 {
+			if !marker.NotDone() {
+				return nil, io.EOF
+			}
+
+			var err error
+
+			page, marker, err = ts.listSegment(ctx, marker, listOptions, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		item := page[0]
+		page = page[1:]
+
+		return item, nil
+	}))
+}
+
+func (ts *AzureCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	var marker azblob.Marker
+	if pageToken != "" {
+		marker.Val = &pageToken
+	}
+
+	opts = listOptionsOrDefault(opts)
+
+	page, marker, err := ts.listSegment(ctx, marker, opts, int32(listPageSize(pageSize)))
+	if err != nil {
+		return nil, "", wrapError(err)
+	}
+
+	page, err = filterListObjects(opts, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !marker.NotDone() {
+		return page, "", nil
+	}
+
+	return page, *marker.Val, nil
+}
+
+// listSegment fetches a single page of the listing, up to maxResults items or the service default if 0.
+// Azure returns blobs and "directories" separately, so they are merged back into the lexicographical order
+// the other providers use. Azure can't start the listing after a key, the items before StartAfter are dropped.
+// The items under the reserved prefix, e.g. the staging blobs of a failed upload, are dropped as well.
+func (ts *AzureCloudStorage) listSegment(
+	ctx context.Context,
+	marker azblob.Marker,
+	listOptions *ListOptions,
+	maxResults int32,
+) ([]*ListObject, azblob.Marker, error) {
+	segmentOptions := azblob.ListBlobsSegmentOptions{
+		Prefix:     listOptions.Prefix,
+		MaxResults: maxResults,
+	}
+
+	var (
+		items    []azblob.BlobItem
+		prefixes []azblob.BlobPrefix
+	)
+
+	if listOptions.Delimiter == "" {
+		resp, err := ts.containerURL.ListBlobsFlatSegment(ctx, marker, segmentOptions)
+		if err != nil {
+			return nil, marker, err
+		}
+
+		items = resp.Segment.BlobItems
+		marker = resp.NextMarker
+	} else {
+		resp, err := ts.containerURL.ListBlobsHierarchySegment(ctx, marker, listOptions.Delimiter, segmentOptions)
+		if err != nil {
+			return nil, marker, err
+		}
+
+		items = resp.Segment.BlobItems
+		prefixes = resp.Segment.BlobPrefixes
+		marker = resp.NextMarker
+	}
+
+	page := make([]*ListObject, 0, len(items)+len(prefixes))
+
+	for _, item := range items {
+		if !isListedAfter(item.Name, false, listOptions.StartAfter) || isReservedKey(item.Name) {
+			continue
+		}
+
+		var size int64
+		if item.Properties.ContentLength != nil {
+			size = *item.Properties.ContentLength
+		}
+
+		page = append(page, &ListObject{
+			Key:     item.Name,
+			ModTime: item.Properties.LastModified,
+			Size:    size,
+			MD5:     item.Properties.ContentMD5,
+		})
+	}
+
+	for _, prefix := range prefixes {
+		if !isListedAfter(prefix.Name, true, listOptions.StartAfter) || isReservedKey(prefix.Name) {
+			continue
+		}
+
+		page = append(page, &ListObject{
+			Key:   prefix.Name,
+			IsDir: true,
+		})
+	}
+
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Key < page[j].Key
+	})
+
+	return page, marker, nil
+}
+
+func (ts *AzureCloudStorage) Get(
+	ctx context.Context,
+	key string,
+) ([]byte, error) {
+	reader, err := ts.GetReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	body, err := ioutil.ReadAll(reader)
+
+	return body, wrapError(err)
+}
+
+func (ts *AzureCloudStorage) GetReader(
+	ctx context.Context,
+	key string,
+) (io.ReadCloser, error) {
+	return ts.GetRangeReader(ctx, key, 0, -1)
+}
+
+func (ts *AzureCloudStorage) GetRangeReader(
+	ctx context.Context,
+	key string,
+	offset,
+	length int64,
+) (io.ReadCloser, error) {
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if length == 0 {
+		// azblob treats a zero count as "until the end", so check the blob exists and return nothing
+		if _, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}); err != nil {
+			return nil, wrapError(err)
+		}
+
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	count := length
+	if count < 0 {
+		count = azblob.CountToEnd
+	}
+
+	resp, err := blobURL.Download(ctx, offset, count, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return wrapReader(resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: azureMaxDownloadRetryRequests}), nil)
+}
+
+func (ts *AzureCloudStorage) GetWriter(
+	ctx context.Context,
+	key string,
+) (io.WriteCloser, error) {
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *AzureCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := opts.preconditions().validateWrite(azurePreconditions); err != nil {
+		return nil, err
+	}
+
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		return ts.newWriter(ctx, blobURL, opts)
+	})
+}
+
+func (ts *AzureCloudStorage) newWriter(
+	ctx context.Context,
+	blobURL azblob.BlockBlobURL,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	pipeReader, pipeWriter := io.Pipe()
+
+	writer := &azureWriter{
+		pipeWriter: pipeWriter,
+		done:       make(chan struct{}),
+	}
+
+	headers, metadata := azureBlobHeaders(opts)
+	upload := opts.upload()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblock the upload when the caller gives up without Close
+			pipeReader.CloseWithError(ctx.Err())
+		case <-writer.done:
+		}
+	}()
+
+	go func() {
+		defer close(writer.done)
+
+		// detect the content type like gocloud.dev/blob does
+		head := make([]byte, 512)
+		n, err := io.ReadFull(pipeReader, head)
+
+		if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+			if headers.ContentType == "" {
+				headers.ContentType = http.DetectContentType(head[:n])
+			}
+
+			_, err = azblob.UploadStreamToBlockBlob(ctx, io.MultiReader(bytes.NewReader(head[:n]), pipeReader), blobURL,
+				azblob.UploadStreamToBlockBlobOptions{
+					BufferSize:       int(upload.partSize(azureUploadBufferSize)),
+					MaxBuffers:       upload.concurrency(azureUploadBufferSize, azureUploadMaxBuffers),
+					BlobHTTPHeaders:  headers,
+					Metadata:         metadata,
+					AccessConditions: opts.preconditions().azureAccessConditions(),
+				})
+		}
+
+		writer.err = err
+		// unblock the caller when the upload failed before the whole body was consumed
+		pipeReader.CloseWithError(err)
+	}()
+
+	return wrapWriter(writer, nil)
+}
+
+// azureBlobHeaders converts the options, the MD5 is stored as the blob MD5.
+func azureBlobHeaders(opts *WriteOptions) (azblob.BlobHTTPHeaders, azblob.Metadata) {
+	if opts == nil {
+		return azblob.BlobHTTPHeaders{}, nil
+	}
+
+	return azblob.BlobHTTPHeaders{
+		ContentType:        opts.ContentType,
+		ContentMD5:         opts.ContentMD5,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentDisposition: opts.ContentDisposition,
+		CacheControl:       opts.CacheControl,
+	}, azblob.Metadata(opts.Metadata)
+}
+
+func (ts *AzureCloudStorage) CreateBucket(
+	ctx context.Context,
+	bucketPrefix string,
+	expirationTimeDays int64,
+) error {
+	// not supported for prod
+	return nil
+}
+
+func (ts *AzureCloudStorage) describe() (provider, bucket string) {
+	return "azure", ts.bucketName
+}
+
+func (ts *AzureCloudStorage) Close() {
+	// the pipeline doesn't hold any resources to release
+}
+
+func (ts *AzureCloudStorage) GetSignedURL(
+	ctx context.Context,
+	key string,
+	opts *SignedURLOption,
+) (string, error) {
+	if ts.credential == nil {
+		return "", fmt.Errorf("unable to create Azure signed URL without storage account key")
+	}
+
+	permissions := azblob.BlobSASPermissions{}
+
+	switch opts.Method {
+	case "", http.MethodGet:
+		permissions.Read = true
+	case http.MethodPut:
+		permissions.Create = true
+		permissions.Write = true
+	case http.MethodDelete:
+		permissions.Delete = true
+	default:
+		return "", newError(CodeInvalidArgument, fmt.Errorf("unsupported signed URL method: %s", opts.Method))
+	}
+
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	blobURLParts := azblob.NewBlobURLParts(blobURL.URL())
+
+	protocol := azblob.SASProtocolHTTPS
+	if blobURLParts.Scheme == "http" {
+		// emulators are served over plain http
+		protocol = azblob.SASProtocolHTTPSandHTTP
+	}
+
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      protocol,
+		ExpiryTime:    time.Now().Add(opts.Expiry).UTC(),
+		ContainerName: ts.bucketName,
+		BlobName:      blobURLParts.BlobName,
+		Permissions:   permissions.String(),
+	}.NewSASQueryParameters(ts.credential)
+	if err != nil {
+		return "", wrapError(err)
+	}
+
+	blobURLParts.SAS = sas
+	signedURL := blobURLParts.URL()
+
+	return signedURL.String(), nil
+}
+
+func (ts *AzureCloudStorage) Write(
+	ctx context.Context,
+	key string,
+	body []byte,
+	contentType *string,
+) error {
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *AzureCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return err
+	}
+
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := opts.preconditions().validateWrite(azurePreconditions); err != nil {
+		return err
+	}
+
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	headers, metadata := azureBlobHeaders(opts)
+	if headers.ContentType == "" {
+		headers.ContentType = http.DetectContentType(body)
+	}
+
+	_, err = azblob.UploadBufferToBlockBlob(ctx, body, blobURL, azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders:  headers,
+		Metadata:         metadata,
+		AccessConditions: opts.preconditions().azureAccessConditions(),
+	})
+
+	return opts.preconditions().wrapError(key, wrapError(err))
+}
+
+func (ts *AzureCloudStorage) Delete(
+	ctx context.Context,
+	key string,
+) error {
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *AzureCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(azurePreconditions); err != nil {
+		return err
+	}
+
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, preconditions.azureAccessConditions())
+
+	return preconditions.wrapError(key, wrapError(err))
+}
+
+func (ts *AzureCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+}
+
+func (ts *AzureCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *AzureCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
+}
+
+func (ts *AzureCloudStorage) Attributes(
+	ctx context.Context,
+	key string,
+) (*Attributes, error) {
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return newAzureAttributes(props), nil
+}
+
+func newAzureAttributes(props *azblob.BlobGetPropertiesResponse) *Attributes {
+	azureMetadata := props.NewMetadata()
+	metadata := make(map[string]string, len(azureMetadata))
+
+	for k, v := range azureMetadata {
+		metadata[strings.ToLower(k)] = v
+	}
+
+	return &Attributes{
+		CacheControl:       props.CacheControl(),
+		ContentDisposition: props.ContentDisposition(),
+		ContentEncoding:    props.ContentEncoding(),
+		ContentLanguage:    props.ContentLanguage(),
+		ContentType:        props.ContentType(),
+		Metadata:           metadata,
+		ModTime:            props.LastModified(),
+		Size:               props.ContentLength(),
+		MD5:                props.ContentMD5(),
+		ETag:               string(props.ETag()),
+	}
+}
+
+func (ts *AzureCloudStorage) Exists(
+	ctx context.Context,
+	key string,
+) (bool, error) {
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		// HEAD responses don't carry a body with the service code, Code falls back to the status code
+		if Code(err) == CodeNotFound {
+			return false, nil
+		}
+
+		return false, wrapError(err)
+	}
+
+	return true, nil
+}
+
+// Copy copies the blob server-side, it waits until a pending copy completes.
+func (ts *AzureCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	srcURL, err := ts.blobURL(srcKey)
+	if err != nil {
+		return err
+	}
+
+	dstURL, err := ts.blobURL(dstKey)
+	if err != nil {
+		return err
+	}
+
+	// the metadata of the source blob is copied unless other metadata are given
+	var metadata azblob.Metadata
+	if opts != nil && opts.Metadata != nil {
+		metadata = azblob.Metadata(opts.Metadata)
+	}
+
+	if err := copyAzureBlob(ctx, srcURL, dstURL, metadata, azblob.BlobAccessConditions{}); err != nil {
+		return wrapError(err)
+	}
+
+	if opts == nil || opts.ContentType == "" {
+		return nil
+	}
+
+	// the HTTP headers are replaced all together
+	props, err := dstURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return wrapError(err)
+	}
+
+	headers := props.NewHTTPHeaders()
+	headers.ContentType = opts.ContentType
+
+	_, err = dstURL.SetHTTPHeaders(ctx, headers, azblob.BlobAccessConditions{})
+
+	return wrapError(err)
+}
+
+// copyAzureBlob copies the blob server-side and waits until a pending copy completes.
+func copyAzureBlob(
+	ctx context.Context,
+	srcURL, dstURL azblob.BlockBlobURL,
+	metadata azblob.Metadata,
+	dstConditions azblob.BlobAccessConditions,
+) error {
+	resp, err := dstURL.StartCopyFromURL(ctx, srcURL.URL(), metadata, azblob.ModifiedAccessConditions{}, dstConditions)
+	if err != nil {
+		return err
+	}
+
+	status := resp.CopyStatus()
+
+	for status == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(azureCopyPollInterval):
+		}
+
+		props, err := dstURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			return err
+		}
+
+		status = props.CopyStatus()
+	}
+
+	if status != azblob.CopyStatusSuccess {
+		return fmt.Errorf("copy of '%s' to '%s' finished with status %s", srcURL.String(), dstURL.String(), status)
+	}
+
+	return nil
+}
+
+func (ts *AzureCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+// UpdateAttributes sets the HTTP headers and the metadata of the blob, the service replaces each of them
+// all together, so the kept values are sent again.
+func (ts *AzureCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	if patch == nil {
+		return ts.Attributes(ctx, key)
+	}
+
+	blobURL, err := ts.blobURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	attrs := newAzureAttributes(props)
+	patch.apply(attrs)
+
+	if patch.CacheControl != nil || patch.ContentDisposition != nil || patch.ContentEncoding != nil ||
+		patch.ContentLanguage != nil || patch.ContentType != nil {
+		headers := props.NewHTTPHeaders()
+		headers.CacheControl = attrs.CacheControl
+		headers.ContentDisposition = attrs.ContentDisposition
+		headers.ContentEncoding = attrs.ContentEncoding
+		headers.ContentLanguage = attrs.ContentLanguage
+		headers.ContentType = attrs.ContentType
+
+		if _, err := blobURL.SetHTTPHeaders(ctx, headers, azblob.BlobAccessConditions{}); err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	if len(patch.Metadata) > 0 {
+		if _, err := blobURL.SetMetadata(ctx, azblob.Metadata(attrs.Metadata), azblob.BlobAccessConditions{}); err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	return ts.Attributes(ctx, key)
+}
+
+// azureWriter streams the written data into a block blob upload running in the background.
+// The blob is committed on Close.
+type azureWriter struct {
+	pipeWriter *io.PipeWriter
+	done       chan struct{}
+	err        error
+}
+
+func (w *azureWriter) Write(p []byte) (int, error) {
+	return w.pipeWriter.Write(p)
+}
+
+func (w *azureWriter) Close() error {
+	if err := w.pipeWriter.Close(); err != nil {
+		return err
+	}
+
+	<-w.done
+
+	return w.err
+}
+
+// StartUpload starts a resumable upload, the parts are staged as the blocks of the blob and committed by Close.
+func (ts *AzureCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := opts.preconditions().validateWrite(azurePreconditions); err != nil {
+		return nil, err
+	}
+
+	if _, err := ts.blobURL(key); err != nil {
+		return nil, err
+	}
+
+	return startResumableUpload(ctx, ts, key, opts, azureUploadBufferSize, 0, &azureUploadDriver{
+		containerURL: ts.containerURL,
+	})
+}
+
+func (ts *AzureCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeResumableUpload(ctx, ts, token, &azureUploadDriver{
+		containerURL: ts.containerURL,
+	})
+}
+
+// AbortStaleUploads aborts the resumable uploads started before the TTL, their staging blobs are
+// listed under the reserved prefix.
+func (ts *AzureCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	cutoff := time.Now().Add(-ttl)
+	driver := &azureUploadDriver{containerURL: ts.containerURL}
+	aborted := 0
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := ts.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
+			Prefix:  azureStagingPrefix,
+			Details: azblob.BlobListingDetails{UncommittedBlobs: true},
+		})
+		if err != nil {
+			return aborted, wrapError(err)
+		}
+
+		marker = resp.NextMarker
+
+		for _, item := range resp.Segment.BlobItems {
+			id := strings.TrimPrefix(item.Name, azureStagingPrefix)
+
+			started, ok := azureUploadStart(id)
+			if !ok || !started.Before(cutoff) {
+				continue
+			}
+
+			if err := driver.abortUpload(ctx, &uploadSession{ID: id}); err != nil {
+				return aborted, wrapError(err)
+			}
+
+			aborted++
+		}
+	}
+
+	return aborted, nil
+}
+
+// azureUploadStart returns the start time of the upload encoded in its ID.
+func azureUploadStart(id string) (time.Time, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return time.Time{}, false
+	}
+
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(seconds, 0), true
+}
+
+// azureUploadDriver stages the parts of the resumable uploads as blocks of a staging blob under the reserved
+// prefix, so a concurrent write of the key can't discard them. The last part commits the staging blob
+// and copies it onto the key.
+type azureUploadDriver struct {
+	containerURL azblob.ContainerURL
+}
+
+// blockID returns the ID of the block of the part, the IDs of a blob have the same length.
+func (d *azureUploadDriver) blockID(session *uploadSession, number int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", session.ID, number)))
+}
+
+// stagingURL returns the blob holding the staged blocks of the upload.
+func (d *azureUploadDriver) stagingURL(session *uploadSession) azblob.BlockBlobURL {
+	return d.containerURL.NewBlockBlobURL(azureStagingPrefix + session.ID)
+}
+
+// startUpload sets an ID starting with the start time, AbortStaleUploads reads it back from the staging blob.
+func (d *azureUploadDriver) startUpload(ctx context.Context, session *uploadSession) error {
+	session.ID = fmt.Sprintf("%d-%s", time.Now().Unix(), uuid.New().String())
+	return nil
+}
+
+func (d *azureUploadDriver) resumeUpload(ctx context.Context, session *uploadSession) error {
+	if len(session.Parts) == 0 {
+		return nil
+	}
+
+	// the blocks are committed already when the final copy failed
+	blockList, err := d.stagingURL(session).GetBlockList(ctx, azblob.BlockListAll, azblob.LeaseAccessConditions{})
+	if err != nil {
+		return err
+	}
+
+	staged := make(map[string]bool, len(blockList.CommittedBlocks)+len(blockList.UncommittedBlocks))
+
+	for _, block := range blockList.CommittedBlocks {
+		staged[block.Name] = true
+	}
+
+	for _, block := range blockList.UncommittedBlocks {
+		staged[block.Name] = true
+	}
+
+	for _, part := range session.Parts {
+		if !staged[d.blockID(session, part.Number)] {
+			return newError(CodeNotFound, fmt.Errorf("the staged blocks of '%s' are discarded", session.Key))
+		}
+	}
+
+	return nil
+}
+
+func (d *azureUploadDriver) uploadPart(ctx context.Context, session *uploadSession, part []byte, last bool) error {
+	stagingURL := d.stagingURL(session)
+
+	// blocks can't be empty, an empty blob is committed without any
+	if len(part) > 0 {
+		number := len(session.Parts) + 1
+
+		_, err := stagingURL.StageBlock(ctx, d.blockID(session, number), bytes.NewReader(part),
+			azblob.LeaseAccessConditions{}, nil)
+		if err != nil {
+			return err
+		}
+
+		session.Parts = append(session.Parts, uploadPart{
+			Number: number,
+			Size:   int64(len(part)),
+		})
+		session.Offset += int64(len(part))
+	}
+
+	if !last {
+		return nil
+	}
+
+	blockIDs := make([]string, 0, len(session.Parts))
+	for _, p := range session.Parts {
+		blockIDs = append(blockIDs, d.blockID(session, p.Number))
+	}
+
+	headers, metadata := azureBlobHeaders(session.Options)
+
+	_, err := stagingURL.CommitBlockList(ctx, blockIDs, headers, metadata, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+
+	// the copy keeps the headers and the metadata of the staging blob, a failed copy is retried on resume
+	err = copyAzureBlob(ctx, stagingURL, d.containerURL.NewBlockBlobURL(session.Key), nil,
+		session.Options.preconditions().azureAccessConditions())
+	if err != nil {
+		return err
+	}
+
+	// a staging blob left behind is removed by AbortStaleUploads
+	_, _ = stagingURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+
+	return nil
+}
+
+// abortUpload discards the staged blocks. Uncommitted blocks can't be deleted, so they are committed
+// as an empty blob which is deleted.
+func (d *azureUploadDriver) abortUpload(ctx context.Context, session *uploadSession) error {
+	stagingURL := d.stagingURL(session)
+
+	_, err := stagingURL.CommitBlockList(ctx, nil, azblob.BlobHTTPHeaders{}, nil, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+
+	_, err = stagingURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	if Code(err) == CodeNotFound {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// azureListServer is a fake Azure container listing a blob, a staging blob and their "directories".
+func azureListServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method, "no request expected besides the listings")
+		require.Equal(t, "list", r.URL.Query().Get("comp"))
+
+		blob := func(name string) string {
+			return `<Blob><Name>` + name + `</Name><Properties>` +
+				`<Last-Modified>Mon, 02 Jan 2006 15:04:05 GMT</Last-Modified><Content-Length>2</Content-Length>` +
+				`</Properties></Blob>`
+		}
+
+		blobs := blob("a.json") + blob(azureStagingPrefix+"1600000000-upload")
+		if r.URL.Query().Get("delimiter") != "" {
+			blobs = blob("a.json") + `<BlobPrefix><Name>` + reservedPrefix + `</Name></BlobPrefix>` +
+				`<BlobPrefix><Name>users/</Name></BlobPrefix>`
+		}
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="bucket">`+
+			`<Blobs>`+blobs+`</Blobs><NextMarker /></EnumerationResults>`)
+	}))
+}
+
+func TestAzureCloudStorageReservedPrefix(t *testing.T) {
+	server := azureListServer(t)
+	defer server.Close()
+
+	storage, err := newAzureCloudStorage(context.Background(), server.URL+"/devstoreaccount1", "devstoreaccount1",
+		"Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		"bucket", newStorageOptions(nil))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// the staging blobs left behind by failed uploads aren't listed
+	items, err := storage.List(ctx, "").Collect(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "a.json", items[0].Key)
+
+	page, _, err := storage.ListPage(ctx, &ListOptions{Delimiter: "/"}, "", 10)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.Equal(t, "a.json", page[0].Key)
+	require.Equal(t, "users/", page[1].Key)
+
+	// the keys under the reserved prefix are rejected without a request
+	key := azureStagingPrefix + "1600000000-upload"
+
+	for name, err := range map[string]error{
+		"write":  storage.Write(ctx, key, []byte("{}"), nil),
+		"delete": storage.Delete(ctx, key),
+		"copy":   storage.Copy(ctx, "a.json", key, nil),
+	} {
+		require.True(t, errors.Is(err, ErrInvalidArgument), "%s, got: %v", name, err)
+	}
+
+	_, err = storage.Attributes(ctx, key)
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+
+	_, err = storage.GetWriter(ctx, key)
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+
+	_, err = storage.StartUpload(ctx, key, nil)
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+}
+
+func TestAzureCloudStorageWriter(t *testing.T) {
+	var contentTypes []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+
+		// a body smaller than a block is put as a whole
+		contentTypes = append(contentTypes, r.Header.Get("x-ms-blob-content-type"))
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	storage, err := newAzureCloudStorage(context.Background(), server.URL+"/devstoreaccount1", "devstoreaccount1",
+		"Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		"bucket", newStorageOptions(nil))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// the content type is detected from the body unless it's given
+	for _, opts := range []*WriteOptions{nil, {ContentType: "application/json"}} {
+		writer, err := storage.GetWriterWithOptions(ctx, "page.html", opts)
+		require.NoError(t, err)
+
+		_, err = writer.Write([]byte("<html><body></body></html>"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+	}
+
+	require.Equal(t, []string{"text/html; charset=utf-8", "application/json"}, contentTypes)
+
+	// the upload ends with the context when the writer is abandoned without Close
+	cancelCtx, cancel := context.WithCancel(ctx)
+
+	writer, err := storage.GetWriter(cancelCtx, "abandoned.json")
+	require.NoError(t, err)
+
+	cancel()
+
+	select {
+	case <-writer.(*errorMappingWriter).WriteCloser.(*azureWriter).done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the upload didn't end with the context")
+	}
+
+	require.Error(t, writer.Close())
+	require.Len(t, contentTypes, 2)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// AzureTestCloudStorage works against the Azurite emulator. Apart from CreateBucket
+// it behaves exactly like AzureCloudStorage.
+type AzureTestCloudStorage struct {
+	*AzureCloudStorage
+	logger Logger
+}
+
+func newAzureTestCloudStorage(
+	ctx context.Context,
+	endpoint string,
+	accountName string,
+	accountKey string,
+	bucketName string,
+	options *storageOptions,
+) (*AzureTestCloudStorage, error) {
+	// validation
+	if endpoint == "" {
+		// Azurite serves every account under a path-style endpoint, e.g. http://localhost:10000/devstoreaccount1
+		return nil, fmt.Errorf("can't create Azure bucket for tests, required Azure storage endpoint")
+	}
+
+	storage, err := newAzureCloudStorage(ctx, endpoint, accountName, accountKey, bucketName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	options.logger.Info("AzureTestCloudStorage created", "bucket", bucketName)
+
+	return &AzureTestCloudStorage{
+		AzureCloudStorage: storage,
+		logger:            options.logger,
+	}, nil
+}
+
+func (ts *AzureTestCloudStorage) CreateBucket(
+	ctx context.Context,
+	bucketPrefix string,
+	expirationTimeDays int64,
+) error {
+	ts.logger.Info("CreateBucket", "bucket", ts.bucketName, "prefix", bucketPrefix, "expirationTimeDays", expirationTimeDays)
+
+	if _, err := ts.containerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone); err != nil {
+		if storageErr, ok := err.(azblob.StorageError); ok && storageErr.ServiceCode() == azblob.ServiceCodeContainerAlreadyExists {
+			return nil
+		}
+
+		ts.logger.Error("unable to create bucket", "bucket", ts.bucketName, "error", err)
+
+		return wrapError(err)
+	}
+
+	// lifecycle management policies belong to the storage account management API,
+	// which the emulator doesn't provide
+	ts.logger.Info("bucket created", "bucket", ts.bucketName)
+
+	return nil
+}
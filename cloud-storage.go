@@ -20,7 +20,8 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"regexp"
+	"sync"
 	"time"
 
 	compMeta "cloud.google.com/go/compute/metadata"
@@ -53,39 +54,61 @@ func NewCloudStorage(
 }
 
 //nolint:funlen
-func NewCloudStorageWithOption(ctx context.Context, isTesting bool, bucketProvider, bucketName string, cloudStorageOpts CloudStorageOption) (CloudStorage, error) {
-	switch bucketProvider {
-	case "", "aws":
-		// 3-rd party library uses global variables
-		if cloudStorageOpts.AWSS3AccessKeyID != "" {
-			err := os.Setenv("AWS_ACCESS_KEY_ID", cloudStorageOpts.AWSS3AccessKeyID)
-			if err != nil {
-				return nil, err
-			}
-		}
+func NewCloudStorageWithOption(
+	ctx context.Context,
+	isTesting bool,
+	bucketProvider,
+	bucketName string,
+	cloudStorageOpts CloudStorageOption,
+	opts ...Option,
+) (CloudStorage, error) {
+	options := newStorageOptions(opts)
+
+	if bucketProvider == "" {
+		bucketProvider = "aws"
+	}
+
+	storage, err := newCloudStorage(ctx, isTesting, bucketProvider, bucketName, cloudStorageOpts, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.decorate(storage, bucketProvider, bucketName), nil
+}
 
-		// 3-rd party library uses global variables
-		if cloudStorageOpts.AWSS3SecretAccessKey != "" {
-			err := os.Setenv("AWS_SECRET_ACCESS_KEY", cloudStorageOpts.AWSS3SecretAccessKey)
-			if err != nil {
-				return nil, err
-			}
+//nolint:funlen
+func newCloudStorage(
+	ctx context.Context,
+	isTesting bool,
+	bucketProvider,
+	bucketName string,
+	cloudStorageOpts CloudStorageOption,
+	options *storageOptions,
+) (CloudStorage, error) {
+	switch bucketProvider {
+	case "aws":
+		creds, err := newAWSCredentials(cloudStorageOpts.AWSS3AccessKeyID, cloudStorageOpts.AWSS3SecretAccessKey)
+		if err != nil {
+			return nil, err
 		}
 
 		if isTesting {
-			return newAWSTestCloudStorage(ctx, cloudStorageOpts.AWSS3Endpoint, cloudStorageOpts.AWSS3Region, bucketName)
+			return newAWSTestCloudStorage(ctx, cloudStorageOpts.AWSS3Endpoint, cloudStorageOpts.AWSS3Region, creds, bucketName, options)
 		}
 
-		return newAWSCloudStorage(ctx, cloudStorageOpts.AWSS3Endpoint, cloudStorageOpts.AWSS3Region, bucketName, &cloudStorageOpts.AWSEnableS3Accelerate)
+		return newAWSCloudStorage(
+			ctx,
+			cloudStorageOpts.AWSS3Endpoint,
+			cloudStorageOpts.AWSS3Region,
+			creds,
+			bucketName,
+			&cloudStorageOpts.AWSEnableS3Accelerate,
+			options,
+		)
 
 	case "gcp":
 		if isTesting {
-			err := os.Setenv("STORAGE_EMULATOR_HOST", cloudStorageOpts.GCPStorageEmulatorHost)
-			if err != nil {
-				return nil, err
-			}
-
-			return newGCPTestCloudStorage(ctx, cloudStorageOpts.GCPCredentialsJSON, bucketName)
+			return newGCPTestCloudStorage(ctx, cloudStorageOpts.GCPStorageEmulatorHost, bucketName, options)
 		}
 
 		// check that service has been started inside the GCP Kubernetes
@@ -93,16 +116,50 @@ func NewCloudStorageWithOption(ctx context.Context, isTesting bool, bucketProvid
 
 		switch {
 		case cloudStorageOpts.GCPCredentialsJSON != "":
-			return newExplicitGCPCloudStorage(ctx, cloudStorageOpts.GCPCredentialsJSON, bucketName)
+			return newExplicitGCPCloudStorage(ctx, cloudStorageOpts.GCPCredentialsJSON, bucketName, options)
 
 		case isOnGCP && cloudStorageOpts.GCPCredentialsJSON == "":
-			return newImplicitGCPCloudStorage(ctx, bucketName)
+			return newImplicitGCPCloudStorage(ctx, bucketName, options)
 
 		default:
 			// don't support implicit external configuration
 			return nil, fmt.Errorf("unable to create implicit GCP client without credentials")
 		}
 
+	case "azure":
+		if isTesting {
+			return newAzureTestCloudStorage(
+				ctx,
+				cloudStorageOpts.AzureStorageEndpoint,
+				cloudStorageOpts.AzureStorageAccountName,
+				cloudStorageOpts.AzureStorageAccountKey,
+				bucketName,
+				options,
+			)
+		}
+
+		return newAzureCloudStorage(
+			ctx,
+			cloudStorageOpts.AzureStorageEndpoint,
+			cloudStorageOpts.AzureStorageAccountName,
+			cloudStorageOpts.AzureStorageAccountKey,
+			bucketName,
+			options,
+		)
+
+	case "file":
+		return newFileCloudStorage(
+			ctx,
+			cloudStorageOpts.FileStorageRootDir,
+			bucketName,
+			cloudStorageOpts.FileStorageSigningKey,
+			cloudStorageOpts.FileStorageBaseURL,
+			options,
+		)
+
+	case "memory":
+		return NewMemoryCloudStorage(bucketName), nil
+
 	default:
 		return nil, fmt.Errorf("unsupported Bucket Provider: %s", bucketProvider)
 	}
@@ -111,32 +168,77 @@ func NewCloudStorageWithOption(ctx context.Context, isTesting bool, bucketProvid
 type CloudStorage interface {
 	List(ctx context.Context, prefix string) *ListIterator
 	ListWithOptions(ctx context.Context, options *ListOptions) *ListIterator
+	ListPage(ctx context.Context, opts *ListOptions, pageToken string, pageSize int) ([]*ListObject, string, error)
 	Get(ctx context.Context, key string) ([]byte, error)
 	Delete(ctx context.Context, key string) error
+	DeleteWithOptions(ctx context.Context, key string, opts *DeleteOptions) error
+	DeleteMany(ctx context.Context, keys []string) ([]DeleteResult, error)
+	DeletePrefix(ctx context.Context, prefix string, opts *DeletePrefixOptions) (*DeletePrefixResult, error)
 	CreateBucket(ctx context.Context, bucketPrefix string, expirationTimeDays int64) error
 	Close()
 	GetSignedURL(ctx context.Context, key string, opts *SignedURLOption) (string, error)
 	Write(ctx context.Context, key string, body []byte, contentType *string) error
+	WriteWithOptions(ctx context.Context, key string, body []byte, opts *WriteOptions) error
 	Attributes(ctx context.Context, key string) (*Attributes, error)
 	GetReader(ctx context.Context, key string) (io.ReadCloser, error)
 	GetRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Download(ctx context.Context, key string, w io.WriterAt, opts *DownloadOptions) (int64, error)
 	GetWriter(ctx context.Context, key string) (io.WriteCloser, error)
+	GetWriterWithOptions(ctx context.Context, key string, opts *WriteOptions) (io.WriteCloser, error)
 	Exists(ctx context.Context, key string) (bool, error)
+	Copy(ctx context.Context, srcKey, dstKey string, opts *CopyOptions) error
+	Move(ctx context.Context, srcKey, dstKey string) error
+	UpdateAttributes(ctx context.Context, key string, patch *AttributesPatch) (*Attributes, error)
+	StartUpload(ctx context.Context, key string, opts *WriteOptions) (*ResumableUpload, error)
+	ResumeUpload(ctx context.Context, token string) (*ResumableUpload, error)
+	AbortStaleUploads(ctx context.Context, ttl time.Duration) (int, error)
 }
 
-func newListIterator(f func() (*ListObject, error)) *ListIterator {
+// newListIterator returns an iterator over the items f returns, f is called with the context of Next.
+func newListIterator(f func(ctx context.Context) (*ListObject, error)) *ListIterator {
 	return &ListIterator{
 		f: f,
 	}
 }
 
+// wrapListIterator returns an iterator over the items f returns, closing it closes the wrapped iterator.
+func wrapListIterator(iterator *ListIterator, f func(ctx context.Context) (*ListObject, error)) *ListIterator {
+	return &ListIterator{
+		f:     f,
+		close: iterator.Close,
+	}
+}
+
 // ListIterator iterates over List results.
 type ListIterator struct {
-	f func() (*ListObject, error)
+	f func(ctx context.Context) (*ListObject, error)
+	// close releases the listing, nil when there is nothing to release
+	close     func()
+	closeOnce sync.Once
 }
 
+// Close releases an iterator abandoned before the end of the listing, e.g. it ends the span, the metrics
+// and the log entry of an observed listing. It may be called more than once and after the end of the listing.
+// Next keeps working after Close, but the listing isn't observed anymore.
+func (i *ListIterator) Close() {
+	i.closeOnce.Do(func() {
+		if i.close != nil {
+			i.close()
+		}
+	})
+}
+
+// Next returns the next object, io.EOF is returned when there are no more objects.
+// The page fetches are bound to ctx, the GCS test storage binds them to the context of the listing.
+// A done context fails Next with its error.
 func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
-	return i.f()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	item, err := i.f(ctx)
+
+	return item, wrapError(err)
 }
 
 // ListOptions sets options for listing blobs.
@@ -158,6 +260,34 @@ type ListOptions struct {
 	// ListObject fields. These results represent "directories". Multiple results
 	// in a "directory" are returned as a single result.
 	Delimiter string
+	// StartAfter lists only the keys after this one in lexicographical order, e.g. the last key
+	// a previous listing has processed. A "directory" holding StartAfter is listed with the keys after it.
+	// S3 and GCS start the listing there, Azure skips the keys before it while listing.
+	StartAfter string
+
+	// The filters below are applied by the client for every provider, the filtered out objects are listed
+	// all the same. The "directories" are only filtered by ExcludePrefixes.
+
+	// ModifiedAfter lists only the objects modified after this time, if set
+	ModifiedAfter time.Time
+	// ModifiedBefore lists only the objects modified before this time, if set
+	ModifiedBefore time.Time
+	// MinSize lists only the objects of at least this size in bytes
+	MinSize int64
+	// MaxSize lists only the objects of at most this size in bytes, no cap if 0
+	MaxSize int64
+	// Suffix lists only the keys ending with it, e.g. ".json"
+	Suffix string
+	// Glob lists only the keys matching the pattern of path.Match, matched against the whole key,
+	// e.g. "users/*/profile.json"; "*" doesn't match "/"
+	Glob string
+	// Regexp lists only the keys it matches
+	Regexp *regexp.Regexp
+	// ExcludePrefixes skips the keys and "directories" starting with any of the prefixes
+	ExcludePrefixes []string
+	// MaxResults ends the listing of ListWithOptions after this number of listed items, no cap if 0.
+	// It doesn't apply to ListPage
+	MaxResults int
 }
 
 // ListObject represents a single blob returned from List.
@@ -209,6 +339,15 @@ type Attributes struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// ETag is the entity tag of the blob as reported by the provider, empty if not available,
+	// see Preconditions.ETagMatch.
+	ETag string
+	// Generation identifies the content of the blob, it changes with every write.
+	// Only GCS and the memory storage set it, see Preconditions.GenerationMatch.
+	Generation int64
+	// Metageneration counts the attribute updates of the blob generation.
+	// Only GCS and the memory storage set it, see Preconditions.MetagenerationMatch.
+	Metageneration int64
 }
 
 type SignedURLOption struct {
@@ -218,13 +357,99 @@ type SignedURLOption struct {
 	EnforceAbsentContentType bool
 }
 
+// CopyOptions controls Copy and CopyBetween. The attributes of the source object are kept by default.
+type CopyOptions struct {
+	// Metadata replaces the metadata of the source object when not nil
+	Metadata map[string]string
+	// ContentType replaces the content type of the source object when not empty
+	ContentType string
+}
+
+// keepsAttributes reports whether the copy gets all the attributes of the source object.
+func (o *CopyOptions) keepsAttributes() bool {
+	return o == nil || (o.Metadata == nil && o.ContentType == "")
+}
+
+// apply replaces the attributes of the source object as requested.
+func (o *CopyOptions) apply(attrs *Attributes) {
+	if o == nil {
+		return
+	}
+
+	if o.Metadata != nil {
+		attrs.Metadata = o.Metadata
+	}
+
+	if o.ContentType != "" {
+		attrs.ContentType = o.ContentType
+	}
+}
+
+// AttributesPatch changes the attributes of an object with UpdateAttributes, nil fields are kept.
+// An empty value removes the attribute, the content type falls back to the provider default then.
+type AttributesPatch struct {
+	CacheControl       *string
+	ContentDisposition *string
+	ContentEncoding    *string
+	ContentLanguage    *string
+	ContentType        *string
+	// Metadata is merged into the metadata of the object, the keys missing from it are kept
+	Metadata map[string]string
+}
+
+// apply changes the attributes as requested, the metadata of attrs isn't modified in place.
+func (p *AttributesPatch) apply(attrs *Attributes) {
+	if p == nil {
+		return
+	}
+
+	applyString(&attrs.CacheControl, p.CacheControl)
+	applyString(&attrs.ContentDisposition, p.ContentDisposition)
+	applyString(&attrs.ContentEncoding, p.ContentEncoding)
+	applyString(&attrs.ContentLanguage, p.ContentLanguage)
+	applyString(&attrs.ContentType, p.ContentType)
+
+	if len(p.Metadata) > 0 {
+		metadata := make(map[string]string, len(attrs.Metadata)+len(p.Metadata))
+
+		for k, v := range attrs.Metadata {
+			metadata[k] = v
+		}
+
+		for k, v := range p.Metadata {
+			metadata[k] = v
+		}
+
+		attrs.Metadata = metadata
+	}
+}
+
+func applyString(value *string, patch *string) {
+	if patch != nil {
+		*value = *patch
+	}
+}
+
 type CloudStorageOption struct {
-	AWSS3Endpoint         string
-	AWSS3Region           string
-	AWSS3AccessKeyID      string
-	AWSS3SecretAccessKey  string
-	AWSEnableS3Accelerate bool
-
-	GCPCredentialsJSON     string
-	GCPStorageEmulatorHost string
+	AWSS3Endpoint         string `yaml:"awsS3Endpoint" json:"awsS3Endpoint"`
+	AWSS3Region           string `yaml:"awsS3Region" json:"awsS3Region"`
+	AWSS3AccessKeyID      string `yaml:"awsS3AccessKeyID" json:"awsS3AccessKeyID"`
+	AWSS3SecretAccessKey  string `yaml:"awsS3SecretAccessKey" json:"awsS3SecretAccessKey"`
+	AWSEnableS3Accelerate bool   `yaml:"awsEnableS3Accelerate" json:"awsEnableS3Accelerate"`
+
+	GCPCredentialsJSON     string `yaml:"gcpCredentialsJSON" json:"gcpCredentialsJSON"`
+	GCPStorageEmulatorHost string `yaml:"gcpStorageEmulatorHost" json:"gcpStorageEmulatorHost"`
+
+	AzureStorageAccountName string `yaml:"azureStorageAccountName" json:"azureStorageAccountName"`
+	AzureStorageAccountKey  string `yaml:"azureStorageAccountKey" json:"azureStorageAccountKey"`
+	// AzureStorageEndpoint overrides the default https://<account>.blob.core.windows.net endpoint,
+	// e.g. for sovereign clouds or the Azurite emulator
+	AzureStorageEndpoint string `yaml:"azureStorageEndpoint" json:"azureStorageEndpoint"`
+
+	// FileStorageRootDir is the directory holding one sub-directory per bucket
+	FileStorageRootDir string `yaml:"fileStorageRootDir" json:"fileStorageRootDir"`
+	// FileStorageSigningKey is the HMAC key of the signed URLs, GetSignedURL is disabled without it
+	FileStorageSigningKey string `yaml:"fileStorageSigningKey" json:"fileStorageSigningKey"`
+	// FileStorageBaseURL is the URL NewFileSignedURLHandler is served under, e.g. https://example.com/blobs
+	FileStorageBaseURL string `yaml:"fileStorageBaseURL" json:"fileStorageBaseURL"`
 }
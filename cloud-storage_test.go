@@ -14,45 +14,89 @@
  *
  */
 
-package commonblobgo
+package commonblobgo_test
 
 import (
 	"context"
-	"fmt"
-	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
-	"time"
 
-	"github.com/google/uuid"
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/AccelByte/common-blob-go/storagetest"
 	"github.com/sirupsen/logrus"
-	"github.com/stretchr/testify/suite"
+	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	logrus.SetOutput(os.Stdout)
+	logrus.SetLevel(logrus.DebugLevel)
+	logrus.SetReportCaller(true)
+
+	os.Exit(m.Run())
+}
+
+// runSuite runs the conformance suite against the provider created by NewCloudStorageWithOption.
+func runSuite(t *testing.T, isTesting bool, bucketProvider string, opts commonblobgo.CloudStorageOption) {
+	storagetest.Run(t, func() commonblobgo.CloudStorage {
+		storage, err := commonblobgo.NewCloudStorageWithOption(
+			context.Background(),
+			isTesting,
+			bucketProvider,
+			"gdpr-req-data",
+			opts,
+		)
+		require.NoError(t, err)
+
+		return storage
+	})
+}
+
 func TestAWSAPISuite(t *testing.T) {
-	suite.Run(t, &Suite{
-		isTesting:      true,
-		bucketName:     "gdpr-req-data",
-		bucketProvider: "aws",
-
-		awsS3Endpoint:        "http://localhost:4572",
-		awsS3Region:          "us-west-2",
-		awsS3AccessKeyID:     "AWS_ACCESS_KEY_ID",
-		awsS3SecretAccessKey: "AWS_SECRET_ACCESS_KEY",
+	runSuite(t, true, "aws", commonblobgo.CloudStorageOption{
+		AWSS3Endpoint:        "http://localhost:4572",
+		AWSS3Region:          "us-west-2",
+		AWSS3AccessKeyID:     "AWS_ACCESS_KEY_ID",
+		AWSS3SecretAccessKey: "AWS_SECRET_ACCESS_KEY",
 	})
 }
 
 func TestGCPAPISuite(t *testing.T) {
-	suite.Run(t, &Suite{
-		isTesting:              true,
-		bucketName:             "gdpr-req-data",
-		bucketProvider:         "gcp",
-		gcpCredentialsJSON:     `{"type": "service_account", "project_id": "my-project-id"}`,
-		gcpStorageEmulatorHost: "0.0.0.0:4443",
+	runSuite(t, true, "gcp", commonblobgo.CloudStorageOption{
+		GCPCredentialsJSON:     `{"type": "service_account", "project_id": "my-project-id"}`,
+		GCPStorageEmulatorHost: "0.0.0.0:4443",
 	})
 }
 
+func TestAzureAPISuite(t *testing.T) {
+	runSuite(t, true, "azure", commonblobgo.CloudStorageOption{
+		// well-known Azurite development account
+		AzureStorageEndpoint:    "http://localhost:10000/devstoreaccount1",
+		AzureStorageAccountName: "devstoreaccount1",
+		AzureStorageAccountKey:  "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+	})
+}
+
+func TestFileAPISuite(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "common-blob-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	runSuite(t, true, "file", commonblobgo.CloudStorageOption{
+		FileStorageRootDir:    rootDir,
+		FileStorageSigningKey: "signing-key",
+		FileStorageBaseURL:    "http://localhost:8080/blobs",
+	})
+}
+
+func TestMemoryAPISuite(t *testing.T) {
+	runSuite(t, true, "memory", commonblobgo.CloudStorageOption{})
+}
+
 func TestAWSDemoAPISuite(t *testing.T) {
 	// warning, this suite uses real S3 credentials
 	awsS3Endpoint := os.Getenv("AWS_S3_ENDPOINT")
@@ -75,15 +119,11 @@ func TestAWSDemoAPISuite(t *testing.T) {
 		return
 	}
 
-	suite.Run(t, &Suite{
-		isTesting:      false,
-		bucketName:     "gdpr-req-data",
-		bucketProvider: "aws",
-
-		awsS3Endpoint:        awsS3Endpoint,
-		awsS3Region:          awsS3Region,
-		awsS3AccessKeyID:     awsS3AccessKeyID,
-		awsS3SecretAccessKey: awsS3SecretAccessKey,
+	runSuite(t, false, "aws", commonblobgo.CloudStorageOption{
+		AWSS3Endpoint:        awsS3Endpoint,
+		AWSS3Region:          awsS3Region,
+		AWSS3AccessKeyID:     awsS3AccessKeyID,
+		AWSS3SecretAccessKey: awsS3SecretAccessKey,
 	})
 }
 
@@ -96,275 +136,85 @@ func TestGCPDemoAPISuite(t *testing.T) {
 		return
 	}
 
-	suite.Run(t, &Suite{
-		isTesting:          false,
-		bucketName:         "gdpr-req-data",
-		bucketProvider:     "gcp",
-		gcpCredentialsJSON: gcpCredentialsJSON,
+	runSuite(t, false, "gcp", commonblobgo.CloudStorageOption{
+		GCPCredentialsJSON: gcpCredentialsJSON,
 	})
 }
 
-type Suite struct {
-	suite.Suite
+func TestAzureDemoAPISuite(t *testing.T) {
+	// warning, this suite uses real Azure credentials
+	azureStorageAccountName := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	azureStorageAccountKey := os.Getenv("AZURE_STORAGE_KEY")
 
-	storage CloudStorage
-
-	ctx            context.Context
-	isTesting      bool
-	bucketProvider string
-	bucketName     string
-	bucketPrefix   string
-
-	awsS3Endpoint        string
-	awsS3Region          string
-	awsS3AccessKeyID     string
-	awsS3SecretAccessKey string
-
-	gcpCredentialsJSON     string
-	gcpStorageEmulatorHost string // only for tests
-}
-
-func (s *Suite) SetupSuite() {
-	logrus.SetOutput(os.Stdout)
-	logrus.SetLevel(logrus.DebugLevel)
-	logrus.SetReportCaller(true)
-
-	s.ctx = context.Background()
-	s.bucketPrefix = fmt.Sprintf("test_%s", uuid.New().String())
-
-	storage, err := NewCloudStorage(
-		s.ctx,
-		s.isTesting,
-		s.bucketProvider,
-		s.bucketName,
-		s.awsS3Endpoint,
-		s.awsS3Region,
-		s.awsS3AccessKeyID,
-		s.awsS3SecretAccessKey,
-		s.gcpCredentialsJSON,
-		s.gcpStorageEmulatorHost,
-	)
-	s.Require().NoError(err)
-	s.Require().NotNil(storage)
-
-	s.storage = storage
-
-	err = s.storage.CreateBucket(s.ctx, s.bucketPrefix, 1)
-	s.Require().NoError(err)
-}
-
-func (s *Suite) generateFileName() string {
-	return fmt.Sprintf("%s/%s.json", s.bucketPrefix, uuid.New().String())
-}
-
-func (s *Suite) TestCreateBucket() {
-	prefix := uuid.New().String()
-
-	err := s.storage.CreateBucket(s.ctx, prefix, 1)
-	s.Require().NoError(err)
-}
-
-func (s *Suite) TestWriteAndGet() {
-	fileName := s.generateFileName()
-	body := []byte(`{"key": "value"}`)
-
-	err := s.storage.Write(s.ctx, fileName, body, nil)
-	s.Require().NoError(err)
-
-	storedBody, err := s.storage.Get(s.ctx, fileName)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(storedBody)
-
-	s.Require().JSONEq(string(body), string(storedBody))
-}
-
-func (s *Suite) TestWriteAndGetUsingReaderAndWriter() {
-	fileName := s.generateFileName()
-	body := []byte(`{"key": "value", "key2": "value2"}`)
-
-	writer, err := s.storage.GetWriter(s.ctx, fileName)
-	s.Require().NoError(err)
-
-	_, err = writer.Write(body[:10])
-	s.Require().NoError(err)
-
-	_, err = writer.Write(body[10:20])
-	s.Require().NoError(err)
-
-	_, err = writer.Write(body[20:])
-	s.Require().NoError(err)
-
-	err = writer.Close()
-	s.Require().NoError(err)
-
-	reader, err := s.storage.GetReader(s.ctx, fileName)
-	s.Require().NoError(err)
-
-	storedBody, err := ioutil.ReadAll(reader)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(storedBody)
-
-	err = reader.Close()
-	s.Require().NoError(err)
-
-	s.Require().JSONEq(string(body), string(storedBody))
-}
-
-func (s *Suite) TestWriteAndGetUsingRangeReader() {
-	fileName := s.generateFileName()
-	body := []byte(`0123456789`)
-
-	writer, err := s.storage.GetWriter(s.ctx, fileName)
-	s.Require().NoError(err)
-
-	_, err = writer.Write(body[:10])
-	s.Require().NoError(err)
-
-	err = writer.Close()
-	s.Require().NoError(err)
-
-	// Read chunk 1 : offset:0, length:5
-	rangeReader, err := s.storage.GetRangeReader(s.ctx, fileName, 0, 5)
-	s.Require().NoError(err)
-
-	chunk1Result, err := ioutil.ReadAll(rangeReader)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(chunk1Result)
-
-	err = rangeReader.Close()
-
-	s.Require().NoError(err)
-	s.Require().Equal(string(chunk1Result), "01234")
-
-	// Read chunk 2 : offset:5, length:5
-	rangeReader, err = s.storage.GetRangeReader(s.ctx, fileName, 5, 5)
-	s.Require().NoError(err)
-
-	chunk2Result, err := ioutil.ReadAll(rangeReader)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(chunk2Result)
-
-	err = rangeReader.Close()
-
-	s.Require().NoError(err)
-	s.Require().Equal(string(chunk2Result), "56789")
-}
-
-func (s *Suite) TestWriteAndList() {
-	fileName := s.generateFileName()
-	body := []byte(`{"key": "value"}`)
-
-	err := s.storage.Write(s.ctx, fileName, body, nil)
-	s.Require().NoError(err)
-
-	var fileFound bool
-
-	list := s.storage.List(s.ctx, s.bucketPrefix)
-
-	for {
-		item, err := list.Next(s.ctx)
-		if err == io.EOF {
-			break
-		}
-
-		s.Require().NoError(err)
-
-		if item.Key == fileName {
-			fileFound = true
-		}
+	if azureStorageAccountName == "" {
+		t.Skipf("Skipped. Required ENV variable AZURE_STORAGE_ACCOUNT")
+		return
 	}
 
-	s.Require().True(fileFound)
-}
+	if azureStorageAccountKey == "" {
+		t.Skipf("Skipped. Required ENV variable AZURE_STORAGE_KEY")
+		return
+	}
 
-func (s *Suite) TestWriteAndListWithOptions() {
-	// file without directory
-	fileNameWithoutDirectory := s.bucketPrefix + "/" + uuid.New().String()
-	body := []byte(`{"key": "value1"}`)
-	err := s.storage.Write(s.ctx, fileNameWithoutDirectory, body, nil)
-	s.Require().NoError(err)
-
-	// file with directory
-	fileNameWithDirectory := s.bucketPrefix + "/directory/" + uuid.New().String()
-	body = []byte(`{"key": "value2"}`)
-	err = s.storage.Write(s.ctx, fileNameWithDirectory, body, nil)
-	s.Require().NoError(err)
-
-	list := s.storage.ListWithOptions(s.ctx, &ListOptions{
-		Prefix:    s.bucketPrefix + "/",
-		Delimiter: "/",
+	runSuite(t, false, "azure", commonblobgo.CloudStorageOption{
+		AzureStorageAccountName: azureStorageAccountName,
+		AzureStorageAccountKey:  azureStorageAccountKey,
 	})
-	var fileFound int
-	for {
-		item, err := list.Next(s.ctx)
-		if err == io.EOF {
-			break
-		}
-
-		s.Require().NoError(err)
-
-		if item.Key == fileNameWithoutDirectory {
-			s.Require().False(item.IsDir)
-			fileFound++
-		}
-		if item.Key == s.bucketPrefix+"/directory/" {
-			s.Require().True(item.IsDir)
-			fileFound++
-		}
-	}
-	s.Require().Equal(2, fileFound)
 }
 
-func (s *Suite) TestAttributes() {
-	fileName := s.generateFileName()
-	body := []byte(`{"key": "value"}`)
+func TestCredentialsArePerInstance(t *testing.T) {
+	var authorizations []string
 
-	err := s.storage.Write(s.ctx, fileName, body, nil)
-	s.Require().NoError(err)
+	awsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer awsServer.Close()
 
-	attrs, err := s.storage.Attributes(s.ctx, fileName)
-	s.Require().NoError(err)
-	s.Require().Equal(int64(len(body)), attrs.Size)
-	s.Require().True(attrs.ModTime.Before(time.Now()))
-}
+	ctx := context.Background()
 
-func (s *Suite) TestDelete() {
-	fileName := s.generateFileName()
-	body := []byte(`{"key": "value"}`)
+	for _, accessKeyID := range []string{"TENANTKEY", "SHAREDKEY"} {
+		storage, err := commonblobgo.NewCloudStorageWithOption(ctx, false, "aws", "bucket", commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        awsServer.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     accessKeyID,
+			AWSS3SecretAccessKey: "secret",
+		})
+		require.NoError(t, err)
 
-	err := s.storage.Write(s.ctx, fileName, body, nil)
-	s.Require().NoError(err)
+		exists, err := storage.Exists(ctx, "key")
+		require.NoError(t, err)
+		require.False(t, exists)
+	}
 
-	storedBody, err := s.storage.Get(s.ctx, fileName)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(storedBody)
+	require.Len(t, authorizations, 2)
+	require.Contains(t, authorizations[0], "Credential=TENANTKEY/")
+	require.Contains(t, authorizations[1], "Credential=SHAREDKEY/")
 
-	err = s.storage.Delete(s.ctx, fileName)
-	s.Require().NoError(err)
+	hits := make(map[string]int)
 
-	_, err = s.storage.Get(s.ctx, fileName)
-	s.Require().Error(err)
-}
+	newEmulator := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	}
 
-func (s *Suite) TestGetSignedURL() {
-	fileName := s.generateFileName()
-	body := []byte(`{"key": "value"}`)
+	firstEmulator := newEmulator("first")
+	defer firstEmulator.Close()
 
-	err := s.storage.Write(s.ctx, fileName, body, nil)
-	s.Require().NoError(err)
+	secondEmulator := newEmulator("second")
+	defer secondEmulator.Close()
 
-	storedBody, err := s.storage.Get(s.ctx, fileName)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(storedBody)
+	for _, emulator := range []*httptest.Server{firstEmulator, secondEmulator} {
+		storage, err := commonblobgo.NewCloudStorageWithOption(ctx, true, "gcp", "bucket", commonblobgo.CloudStorageOption{
+			GCPStorageEmulatorHost: emulator.Listener.Addr().String(),
+		})
+		require.NoError(t, err)
 
-	options := &SignedURLOption{
-		Expiry:                   time.Hour,
-		Method:                   "GET",
-		ContentType:              "",
-		EnforceAbsentContentType: false,
+		_, err = storage.Attributes(ctx, "key")
+		require.Equal(t, commonblobgo.CodeNotFound, commonblobgo.Code(err))
 	}
 
-	url, err := s.storage.GetSignedURL(s.ctx, fileName, options)
-	s.Require().NoError(err)
-	s.Require().NotEmpty(url)
+	require.Equal(t, map[string]int{"first": 1, "second": 1}, hits)
 }
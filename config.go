@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config selects and configures a storage, it can be loaded from YAML, JSON or the environment.
+type Config struct {
+	// Provider is one of aws (default), gcp, azure, file or memory
+	Provider string `yaml:"provider" json:"provider"`
+	// Bucket is the bucket, the container for Azure or the directory for the file provider
+	Bucket string `yaml:"bucket" json:"bucket"`
+	// Testing switches to the implementations working against the docker-compose emulators
+	Testing bool `yaml:"testing" json:"testing"`
+
+	CloudStorageOption `yaml:",inline"`
+}
+
+// ConfigError lists every problem found by Config.Validate.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid storage config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the config of the selected provider and reports all the problems at once.
+// The returned error matches ErrInvalidArgument and can be unwrapped into *ConfigError.
+// nolint:gocyclo
+func (c *Config) Validate() error {
+	var problems []string
+
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Bucket == "" {
+		addProblem("bucket is required")
+	}
+
+	switch c.Provider {
+	case "", "aws":
+		if c.AWSS3Region == "" {
+			addProblem("AWS S3 region is required")
+		}
+
+		if (c.AWSS3AccessKeyID == "") != (c.AWSS3SecretAccessKey == "") {
+			addProblem("AWS access key ID and secret access key must be set together")
+		}
+
+		if c.AWSEnableS3Accelerate && c.AWSS3Endpoint != "" {
+			addProblem("AWS S3 accelerate isn't available with a custom S3 endpoint")
+		}
+
+		if c.Testing && c.AWSS3Endpoint == "" {
+			addProblem("AWS S3 endpoint is required for testing")
+		}
+
+		validateURL(c.AWSS3Endpoint, "AWS S3 endpoint", addProblem)
+
+	case "gcp":
+		if c.Testing && c.GCPStorageEmulatorHost == "" {
+			addProblem("GCP storage emulator host is required for testing")
+		}
+
+		if c.GCPCredentialsJSON != "" && !json.Valid([]byte(c.GCPCredentialsJSON)) {
+			addProblem("GCP credentials must be a JSON document")
+		}
+
+	case "azure":
+		if c.AzureStorageAccountName == "" {
+			addProblem("Azure storage account name is required")
+		}
+
+		if c.Testing && c.AzureStorageEndpoint == "" {
+			addProblem("Azure storage endpoint is required for testing")
+		}
+
+		validateURL(c.AzureStorageEndpoint, "Azure storage endpoint", addProblem)
+
+	case "file":
+		if c.FileStorageRootDir == "" {
+			addProblem("file storage root directory is required")
+		} else if info, err := os.Stat(c.FileStorageRootDir); err != nil || !info.IsDir() {
+			addProblem("file storage root directory '%s' doesn't exist", c.FileStorageRootDir)
+		}
+
+		if c.FileStorageSigningKey != "" && c.FileStorageBaseURL == "" {
+			addProblem("file storage base URL is required with a signing key")
+		}
+
+		validateURL(c.FileStorageBaseURL, "file storage base URL", addProblem)
+
+	case "memory":
+		// nothing to configure
+
+	default:
+		addProblem("unsupported provider: %s", c.Provider)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return newError(CodeInvalidArgument, &ConfigError{Problems: problems})
+}
+
+func validateURL(value, name string, addProblem func(format string, args ...interface{})) {
+	if value == "" {
+		return
+	}
+
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		addProblem("%s '%s' must be an absolute URL", name, value)
+	}
+}
+
+// LoadConfigFromEnv reads the config from the environment variables with the prefix, e.g. for prefix "BLOB_"
+// the variables are BLOB_PROVIDER, BLOB_BUCKET, BLOB_TESTING, BLOB_AWS_S3_REGION, etc.
+// Unset variables keep their zero values, call Validate to check the result.
+func LoadConfigFromEnv(prefix string) (*Config, error) {
+	config := &Config{}
+
+	for name, value := range map[string]*string{
+		"PROVIDER":                   &config.Provider,
+		"BUCKET":                     &config.Bucket,
+		"AWS_S3_ENDPOINT":            &config.AWSS3Endpoint,
+		"AWS_S3_REGION":              &config.AWSS3Region,
+		"AWS_S3_ACCESS_KEY_ID":       &config.AWSS3AccessKeyID,
+		"AWS_S3_SECRET_ACCESS_KEY":   &config.AWSS3SecretAccessKey,
+		"GCP_CREDENTIALS_JSON":       &config.GCPCredentialsJSON,
+		"GCP_STORAGE_EMULATOR_HOST":  &config.GCPStorageEmulatorHost,
+		"AZURE_STORAGE_ACCOUNT_NAME": &config.AzureStorageAccountName,
+		"AZURE_STORAGE_ACCOUNT_KEY":  &config.AzureStorageAccountKey,
+		"AZURE_STORAGE_ENDPOINT":     &config.AzureStorageEndpoint,
+		"FILE_STORAGE_ROOT_DIR":      &config.FileStorageRootDir,
+		"FILE_STORAGE_SIGNING_KEY":   &config.FileStorageSigningKey,
+		"FILE_STORAGE_BASE_URL":      &config.FileStorageBaseURL,
+	} {
+		*value = os.Getenv(prefix + name)
+	}
+
+	for name, value := range map[string]*bool{
+		"TESTING":                  &config.Testing,
+		"AWS_ENABLE_S3_ACCELERATE": &config.AWSEnableS3Accelerate,
+	} {
+		env := os.Getenv(prefix + name)
+		if env == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return nil, newError(CodeInvalidArgument, fmt.Errorf("invalid value of %s%s: %v", prefix, name, err))
+		}
+
+		*value = parsed
+	}
+
+	return config, nil
+}
+
+// NewCloudStorageFromConfig validates the config and creates the storage.
+func NewCloudStorageFromConfig(ctx context.Context, config *Config, opts ...Option) (CloudStorage, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewCloudStorageWithOption(ctx, config.Testing, config.Provider, config.Bucket, config.CloudStorageOption, opts...)
+}
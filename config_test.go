@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfigValidate(t *testing.T) {
+	config := &commonblobgo.Config{
+		Provider: "aws",
+		CloudStorageOption: commonblobgo.CloudStorageOption{
+			AWSS3AccessKeyID:      "key",
+			AWSS3Endpoint:         "localhost:4572",
+			AWSEnableS3Accelerate: true,
+		},
+	}
+
+	err := config.Validate()
+	require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument))
+
+	var configErr *commonblobgo.ConfigError
+	require.True(t, errors.As(err, &configErr))
+	require.Equal(t, []string{
+		"bucket is required",
+		"AWS S3 region is required",
+		"AWS access key ID and secret access key must be set together",
+		"AWS S3 accelerate isn't available with a custom S3 endpoint",
+		"AWS S3 endpoint 'localhost:4572' must be an absolute URL",
+	}, configErr.Problems)
+
+	_, err = commonblobgo.NewCloudStorageFromConfig(context.Background(), config)
+	require.True(t, errors.As(err, &configErr))
+
+	config = &commonblobgo.Config{Provider: "ftp", Bucket: "bucket"}
+	require.EqualError(t, config.Validate(), "invalid storage config: unsupported provider: ftp")
+
+	config = &commonblobgo.Config{Provider: "memory", Bucket: "bucket"}
+	require.NoError(t, config.Validate())
+
+	storage, err := commonblobgo.NewCloudStorageFromConfig(context.Background(), config)
+	require.NoError(t, err)
+	require.IsType(t, &commonblobgo.MemoryCloudStorage{}, storage)
+}
+
+func TestConfigDecoding(t *testing.T) {
+	expected := commonblobgo.Config{
+		Provider: "aws",
+		Bucket:   "bucket",
+		Testing:  true,
+		CloudStorageOption: commonblobgo.CloudStorageOption{
+			AWSS3Region:           "us-west-2",
+			AWSEnableS3Accelerate: true,
+		},
+	}
+
+	var fromYAML commonblobgo.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+provider: aws
+bucket: bucket
+testing: true
+awsS3Region: us-west-2
+awsEnableS3Accelerate: true
+`), &fromYAML))
+	require.Equal(t, expected, fromYAML)
+
+	var fromJSON commonblobgo.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"provider": "aws",
+		"bucket": "bucket",
+		"testing": true,
+		"awsS3Region": "us-west-2",
+		"awsEnableS3Accelerate": true
+	}`), &fromJSON))
+	require.Equal(t, expected, fromJSON)
+
+	for name, value := range map[string]string{
+		"TEST_BLOB_PROVIDER":                 "aws",
+		"TEST_BLOB_BUCKET":                   "bucket",
+		"TEST_BLOB_TESTING":                  "true",
+		"TEST_BLOB_AWS_S3_REGION":            "us-west-2",
+		"TEST_BLOB_AWS_ENABLE_S3_ACCELERATE": "1",
+	} {
+		require.NoError(t, os.Setenv(name, value))
+		defer os.Unsetenv(name)
+	}
+
+	fromEnv, err := commonblobgo.LoadConfigFromEnv("TEST_BLOB_")
+	require.NoError(t, err)
+	require.Equal(t, expected, *fromEnv)
+
+	require.NoError(t, os.Setenv("TEST_BLOB_TESTING", "maybe"))
+
+	_, err = commonblobgo.LoadConfigFromEnv("TEST_BLOB_")
+	require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument))
+}
@@ -0,0 +1,306 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+const (
+	// s3MaxCopySize is the largest object a single CopyObject call copies
+	s3MaxCopySize = 5 * 1024 * 1024 * 1024
+	// s3CopyPartSize is the size of the UploadPartCopy parts of larger objects
+	s3CopyPartSize = 512 * 1024 * 1024
+	// s3MaxParts is the largest number of parts of a multipart upload
+	s3MaxParts = 10000
+)
+
+// CopyBetween copies the object from one storage into another. The same storage, also behind different
+// logging, metrics, tracing or retry decorators, copies server-side with Copy. Otherwise the content is streamed
+// through the process with the attributes of the source object, changed by the options, even when the bucket
+// names match: the storages may be of different accounts, endpoints or directories.
+// The streamed copy is aborted when reading the source fails, so no partial object is left behind.
+func CopyBetween(
+	ctx context.Context,
+	src CloudStorage,
+	srcKey string,
+	dst CloudStorage,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	if src == dst || unwrap(src) == unwrap(dst) {
+		return src.Copy(ctx, srcKey, dstKey, opts)
+	}
+
+	attrs, err := src.Attributes(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	opts.apply(attrs)
+
+	reader, err := src.GetReader(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// cancelling the context makes Close abort the write
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := dst.GetWriterWithOptions(writeCtx, dstKey, writeOptionsOf(attrs))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		cancel()
+		writer.Close()
+
+		return err
+	}
+
+	return writer.Close()
+}
+
+// moveByCopy moves the object with a copy and a delete, none of the providers can rename objects.
+func moveByCopy(ctx context.Context, storage CloudStorage, srcKey, dstKey string) error {
+	if srcKey == dstKey {
+		_, err := storage.Attributes(ctx, srcKey)
+		return err
+	}
+
+	if err := storage.Copy(ctx, srcKey, dstKey, nil); err != nil {
+		return err
+	}
+
+	return storage.Delete(ctx, srcKey)
+}
+
+// copyBucketObject copies the object server-side in S3 and GCS buckets.
+// Replaced attributes make both services drop all the attributes of the source object,
+// so the kept ones are set again. S3 copies always set them: a plain CopyObject call drops
+// the encryption and the storage class of the source object and copies up to 5 GiB.
+func copyBucketObject(
+	ctx context.Context,
+	bucket *blob.Bucket,
+	bucketName string,
+	srcKey, dstKey string,
+	opts *CopyOptions,
+) error {
+	var client *s3.S3
+	if opts.keepsAttributes() && !bucket.As(&client) {
+		return bucket.Copy(ctx, dstKey, srcKey, nil)
+	}
+
+	srcAttrs, err := bucket.Attributes(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	attrs := &Attributes{
+		CacheControl:       srcAttrs.CacheControl,
+		ContentDisposition: srcAttrs.ContentDisposition,
+		ContentEncoding:    srcAttrs.ContentEncoding,
+		ContentLanguage:    srcAttrs.ContentLanguage,
+		ContentType:        srcAttrs.ContentType,
+		Metadata:           srcAttrs.Metadata,
+	}
+	opts.apply(attrs)
+
+	return replaceBucketObject(ctx, bucket, bucketName, srcAttrs, srcKey, dstKey, attrs)
+}
+
+// replaceBucketObject copies the object server-side with all the attributes replaced by attrs,
+// srcAttrs are the attributes of the source object.
+func replaceBucketObject(
+	ctx context.Context,
+	bucket *blob.Bucket,
+	bucketName string,
+	srcAttrs *blob.Attributes,
+	srcKey, dstKey string,
+	attrs *Attributes,
+) error {
+	var client *s3.S3
+	if bucket.As(&client) {
+		var head s3.HeadObjectOutput
+		if !srcAttrs.As(&head) {
+			return fmt.Errorf("unable to access the S3 attributes of '%s'", srcKey)
+		}
+
+		return replaceS3Object(ctx, client, bucketName, &head, srcKey, dstKey, attrs)
+	}
+
+	return bucket.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{
+		BeforeCopy: func(asFunc func(interface{}) bool) error {
+			var copier *storage.Copier
+			if asFunc(&copier) {
+				copier.Metadata = attrs.Metadata
+				copier.CacheControl = attrs.CacheControl
+				copier.ContentDisposition = attrs.ContentDisposition
+				copier.ContentEncoding = attrs.ContentEncoding
+				copier.ContentLanguage = attrs.ContentLanguage
+				copier.ContentType = attrs.ContentType
+			}
+
+			return nil
+		},
+	})
+}
+
+// replaceS3Object copies the object server-side with all the attributes replaced by attrs. A copy resets
+// the encryption and the storage class, so the ones of the source object are set again.
+// A single CopyObject call copies up to 5 GiB, larger objects are copied in parts.
+func replaceS3Object(
+	ctx context.Context,
+	client *s3.S3,
+	bucketName string,
+	head *s3.HeadObjectOutput,
+	srcKey, dstKey string,
+	attrs *Attributes,
+) error {
+	if aws.Int64Value(head.ContentLength) > s3MaxCopySize {
+		return copyS3ObjectInParts(ctx, client, bucketName, head, srcKey, dstKey, attrs)
+	}
+
+	_, err := client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:             aws.String(bucketName),
+		Key:                aws.String(dstKey),
+		CopySource:         aws.String(s3CopySource(bucketName, srcKey)),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		Metadata:           escapeS3Metadata(attrs.Metadata),
+		CacheControl:       optionalString(attrs.CacheControl),
+		ContentDisposition: optionalString(attrs.ContentDisposition),
+		ContentEncoding:    optionalString(attrs.ContentEncoding),
+		ContentLanguage:    optionalString(attrs.ContentLanguage),
+		ContentType:        optionalString(attrs.ContentType),
+		// S3 encrypts the copy with the default encryption of the bucket and stores it as STANDARD otherwise
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+		StorageClass:         head.StorageClass,
+	})
+
+	return err
+}
+
+// copyS3ObjectInParts copies the object with a multipart upload of UploadPartCopy parts.
+// The parts are copied only while the source object is unchanged, a failed copy is aborted.
+func copyS3ObjectInParts(
+	ctx context.Context,
+	client *s3.S3,
+	bucketName string,
+	head *s3.HeadObjectOutput,
+	srcKey, dstKey string,
+	attrs *Attributes,
+) error {
+	upload, err := client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(bucketName),
+		Key:                  aws.String(dstKey),
+		Metadata:             escapeS3Metadata(attrs.Metadata),
+		CacheControl:         optionalString(attrs.CacheControl),
+		ContentDisposition:   optionalString(attrs.ContentDisposition),
+		ContentEncoding:      optionalString(attrs.ContentEncoding),
+		ContentLanguage:      optionalString(attrs.ContentLanguage),
+		ContentType:          optionalString(attrs.ContentType),
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+		StorageClass:         head.StorageClass,
+	})
+	if err != nil {
+		return err
+	}
+
+	size := aws.Int64Value(head.ContentLength)
+
+	partSize := int64(s3CopyPartSize)
+	if size > partSize*s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+
+	parts := make([]*s3.CompletedPart, 0, (size+partSize-1)/partSize)
+
+	for offset := int64(0); offset < size; offset += partSize {
+		end := offset + partSize
+		if end > size {
+			end = size
+		}
+
+		number := aws.Int64(int64(len(parts) + 1))
+
+		resp, err := client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(dstKey),
+			UploadId:          upload.UploadId,
+			PartNumber:        number,
+			CopySource:        aws.String(s3CopySource(bucketName, srcKey)),
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, end-1)),
+			CopySourceIfMatch: head.ETag,
+		})
+		if err != nil {
+			abortS3UploadID(client, bucketName, dstKey, aws.StringValue(upload.UploadId))
+			return err
+		}
+
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       resp.CopyPartResult.ETag,
+			PartNumber: number,
+		})
+	}
+
+	_, err = client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(dstKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abortS3UploadID(client, bucketName, dstKey, aws.StringValue(upload.UploadId))
+	}
+
+	return err
+}
+
+// s3CopySource returns the URL-encoded source of a copy, S3 decodes a "+" as a space.
+func s3CopySource(bucketName, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+
+	return bucketName + "/" + strings.Join(segments, "/")
+}
+
+// optionalString returns nil for an empty string, so no empty header is sent.
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return aws.String(value)
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyBetween(t *testing.T) {
+	ctx := context.Background()
+	src := commonblobgo.NewMemoryCloudStorage("exports")
+	dst := commonblobgo.NewMemoryCloudStorage("archive")
+
+	require.NoError(t, src.WriteWithOptions(ctx, "user/1.json", []byte(`{"id":1}`), &commonblobgo.WriteOptions{
+		CacheControl: "no-cache",
+		ContentType:  "application/json",
+		Metadata:     map[string]string{"owner": "1"},
+	}))
+
+	// different buckets are streamed with the attributes of the source
+	require.NoError(t, commonblobgo.CopyBetween(ctx, src, "user/1.json", dst, "2020/user/1.json", &commonblobgo.CopyOptions{
+		Metadata: map[string]string{"archived": "true"},
+	}))
+
+	body, err := dst.Get(ctx, "2020/user/1.json")
+	require.NoError(t, err)
+	require.Equal(t, `{"id":1}`, string(body))
+
+	attrs, err := dst.Attributes(ctx, "2020/user/1.json")
+	require.NoError(t, err)
+	require.Equal(t, "no-cache", attrs.CacheControl)
+	require.Equal(t, "application/json", attrs.ContentType)
+	require.Equal(t, map[string]string{"archived": "true"}, attrs.Metadata)
+
+	// the same storage copies by itself
+	require.NoError(t, commonblobgo.CopyBetween(ctx, src, "user/1.json", src, "user/2.json", nil))
+
+	exists, err := src.Exists(ctx, "user/2.json")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// the same storage behind a decorator copies by itself too
+	traced := commonblobgo.WithTracing(src, nil)
+	require.NoError(t, commonblobgo.CopyBetween(ctx, traced, "user/1.json", src, "user/4.json", nil))
+
+	exists, err = src.Exists(ctx, "user/4.json")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// storages with the same bucket name are different buckets
+	other := commonblobgo.NewMemoryCloudStorage("exports")
+	require.NoError(t, commonblobgo.CopyBetween(ctx, src, "user/1.json", other, "user/5.json", nil))
+
+	body, err = other.Get(ctx, "user/5.json")
+	require.NoError(t, err)
+	require.Equal(t, `{"id":1}`, string(body))
+
+	exists, err = src.Exists(ctx, "user/5.json")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// nothing is written when the source is missing
+	err = commonblobgo.CopyBetween(ctx, src, "user/3.json", dst, "2020/user/3.json", nil)
+	require.True(t, errors.Is(err, commonblobgo.ErrNotFound))
+
+	exists, err = dst.Exists(ctx, "2020/user/3.json")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// deleteManyConcurrency is the number of parallel deletes of DeleteMany on the services without batch deletes
+	deleteManyConcurrency = 16
+	// deleteBatchSize is the number of keys of a single S3 DeleteObjects call and of a DeletePrefix batch
+	deleteBatchSize = 1000
+)
+
+// DeleteResult is the outcome of deleting a single key.
+type DeleteResult struct {
+	Key string
+	// Err is nil when the object was deleted or didn't exist
+	Err error
+}
+
+// DeletePrefixOptions controls DeletePrefix.
+type DeletePrefixOptions struct {
+	// Concurrency is the number of DeleteMany batches of up to 1000 keys deleted at the same time, 1 if not positive
+	Concurrency int
+	// DryRun lists the objects and reports them to Progress without deleting them
+	DryRun bool
+	// Progress is called after every batch, the calls are never concurrent
+	Progress func(progress DeletePrefixProgress)
+}
+
+func (o *DeletePrefixOptions) concurrency() int {
+	if o == nil || o.Concurrency < 1 {
+		return 1
+	}
+
+	return o.Concurrency
+}
+
+// DeletePrefixProgress reports a finished DeletePrefix batch.
+type DeletePrefixProgress struct {
+	// Batch holds the results of the batch, none of them fails in dry-run
+	Batch []DeleteResult
+	// Deleted is the number of objects deleted so far, or listed so far in dry-run
+	Deleted int
+	// Failed is the number of objects which failed to be deleted so far
+	Failed int
+}
+
+// DeletePrefixResult summarizes DeletePrefix.
+type DeletePrefixResult struct {
+	// Deleted is the number of deleted objects, or the number of objects which would be deleted in dry-run
+	Deleted int
+	// Failed lists the objects which failed to be deleted
+	Failed []DeleteResult
+}
+
+// deleteManyError returns the first failure of the results.
+func deleteManyError(results []DeleteResult) error {
+	for _, result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+
+	return nil
+}
+
+// deleteConcurrently deletes the keys with up to concurrency parallel calls of del, missing objects count as deleted.
+func deleteConcurrently(
+	ctx context.Context,
+	keys []string,
+	concurrency int,
+	del func(ctx context.Context, key string) error,
+) ([]DeleteResult, error) {
+	results := make([]DeleteResult, len(keys))
+	semaphore := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+
+	for i, key := range keys {
+		results[i].Key = key
+		semaphore <- struct{}{}
+
+		wg.Add(1)
+
+		go func(result *DeleteResult) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if err := del(ctx, result.Key); Code(err) != CodeNotFound {
+				result.Err = err
+			}
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	return results, deleteManyError(results)
+}
+
+// deleteS3Objects deletes the keys with a DeleteObjects call per 1000 keys. S3 doesn't report missing objects.
+func deleteS3Objects(ctx context.Context, client *s3.S3, bucketName string, keys []string) ([]DeleteResult, error) {
+	results := make([]DeleteResult, len(keys))
+
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		indexes := make(map[string]int, end-start)
+
+		for i := start; i < end; i++ {
+			results[i].Key = keys[i]
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(keys[i])})
+			indexes[keys[i]] = i
+		}
+
+		resp, err := client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			for i := start; i < end; i++ {
+				results[i].Err = wrapError(err)
+			}
+
+			continue
+		}
+
+		for _, deleteErr := range resp.Errors {
+			i, ok := indexes[aws.StringValue(deleteErr.Key)]
+			if !ok {
+				continue
+			}
+
+			results[i].Err = wrapError(fmt.Errorf("unable to delete '%s': %w", results[i].Key,
+				awserr.New(aws.StringValue(deleteErr.Code), aws.StringValue(deleteErr.Message), nil)))
+		}
+	}
+
+	return results, deleteManyError(results)
+}
+
+// deletePrefix deletes the objects under the prefix with DeleteMany batches, the listing goes on meanwhile.
+// The returned error is the listing failure or the first failed delete.
+// nolint:funlen
+func deletePrefix(
+	ctx context.Context,
+	storage CloudStorage,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	if prefix == "" {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("an empty prefix would delete the whole bucket"))
+	}
+
+	result := &DeletePrefixResult{}
+	batches := make(chan []string)
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		deleteErr error
+	)
+
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for batch := range batches {
+				var results []DeleteResult
+
+				if opts != nil && opts.DryRun {
+					results = make([]DeleteResult, len(batch))
+					for i, key := range batch {
+						results[i].Key = key
+					}
+				} else {
+					results, _ = storage.DeleteMany(ctx, batch)
+				}
+
+				mu.Lock()
+
+				for _, r := range results {
+					if r.Err == nil {
+						result.Deleted++
+						continue
+					}
+
+					result.Failed = append(result.Failed, r)
+
+					if deleteErr == nil {
+						deleteErr = r.Err
+					}
+				}
+
+				if opts != nil && opts.Progress != nil {
+					opts.Progress(DeletePrefixProgress{
+						Batch:   results,
+						Deleted: result.Deleted,
+						Failed:  len(result.Failed),
+					})
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+	listErr := listBatches(ctx, storage.List(ctx, prefix), batches)
+
+	close(batches)
+	wg.Wait()
+
+	if listErr != nil {
+		return result, listErr
+	}
+
+	return result, deleteErr
+}
+
+// listBatches sends the listed keys in batches, it stops when the context is done. The iterator is closed on return.
+func listBatches(ctx context.Context, iterator *ListIterator, batches chan<- []string) error {
+	defer iterator.Close()
+
+	batch := make([]string, 0, deleteBatchSize)
+
+	send := func() error {
+		select {
+		case batches <- batch:
+			batch = make([]string, 0, deleteBatchSize)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		item, err := iterator.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if item.IsDir {
+			continue
+		}
+
+		batch = append(batch, item.Key)
+
+		if len(batch) == deleteBatchSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return send()
+}
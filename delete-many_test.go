@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteManyS3Batches(t *testing.T) {
+	var batchSizes []int
+
+	awsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["delete"]; !ok || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		var request struct {
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+
+		require.NoError(t, xml.NewDecoder(r.Body).Decode(&request))
+
+		batchSizes = append(batchSizes, len(request.Objects))
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><DeleteResult>`)
+
+		for _, object := range request.Objects {
+			if object.Key == "user/denied.json" {
+				fmt.Fprintf(w, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, object.Key)
+			}
+		}
+
+		fmt.Fprint(w, `</DeleteResult>`)
+	}))
+	defer awsServer.Close()
+
+	ctx := context.Background()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(ctx, false, "aws", "bucket", commonblobgo.CloudStorageOption{
+		AWSS3Endpoint:        awsServer.URL,
+		AWSS3Region:          "us-west-2",
+		AWSS3AccessKeyID:     "key",
+		AWSS3SecretAccessKey: "secret",
+	})
+	require.NoError(t, err)
+
+	keys := make([]string, 0, 1500)
+	for i := 0; i < 1499; i++ {
+		keys = append(keys, fmt.Sprintf("user/%d.json", i))
+	}
+
+	keys = append(keys, "user/denied.json")
+
+	results, err := storage.DeleteMany(ctx, keys)
+	require.True(t, errors.Is(err, commonblobgo.ErrPermissionDenied), "got: %v", err)
+	require.Equal(t, []int{1000, 500}, batchSizes)
+	require.Len(t, results, len(keys))
+
+	for i, result := range results[:1499] {
+		require.Equal(t, keys[i], result.Key)
+		require.NoError(t, result.Err)
+	}
+
+	require.Equal(t, "user/denied.json", results[1499].Key)
+	require.True(t, errors.Is(results[1499].Err, commonblobgo.ErrPermissionDenied))
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // nolint:gosec
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// downloadPartSize is the default size of the downloaded ranges, s3manager.DefaultDownloadPartSize
+	downloadPartSize = 5 * 1024 * 1024
+	// downloadConcurrency is the default number of ranges downloaded at the same time, s3manager.DefaultDownloadConcurrency
+	downloadConcurrency = 5
+	// downloadMaxAttempts is the default number of attempts of a range
+	downloadMaxAttempts = 3
+)
+
+// downloadRetryPolicy spaces the attempts of a failed range.
+var downloadRetryPolicy = RetryPolicy{ // nolint:gochecknoglobals
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Jitter:         0.5,
+}
+
+// DownloadOptions tunes Download.
+// The download buffers up to 2 × PartSize × Concurrency bytes of content.
+type DownloadOptions struct {
+	// PartSize is the size of the downloaded ranges, 5 MiB if 0
+	PartSize int64
+	// Concurrency is the number of ranges downloaded at the same time, 5 if 0
+	Concurrency int
+	// MaxAttempts is the number of attempts of a range failed with a transient error, 3 if 0, 1 disables retries
+	MaxAttempts int
+	// Progress is called after every range written to the writer with the number of bytes downloaded so far,
+	// the calls are never concurrent
+	Progress func(downloaded int64)
+}
+
+func (o *DownloadOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+
+	if o.PartSize < 0 || o.Concurrency < 0 || o.MaxAttempts < 0 {
+		return newError(CodeInvalidArgument, fmt.Errorf("download options can't be negative"))
+	}
+
+	return nil
+}
+
+func (o *DownloadOptions) partSize() int64 {
+	if o == nil || o.PartSize == 0 {
+		return downloadPartSize
+	}
+
+	return o.PartSize
+}
+
+func (o *DownloadOptions) concurrency() int {
+	if o == nil || o.Concurrency == 0 {
+		return downloadConcurrency
+	}
+
+	return o.Concurrency
+}
+
+func (o *DownloadOptions) maxAttempts() int {
+	if o == nil || o.MaxAttempts == 0 {
+		return downloadMaxAttempts
+	}
+
+	return o.MaxAttempts
+}
+
+// downloadRange is a range of the object, it holds the content once downloaded.
+type downloadRange struct {
+	index  int
+	offset int64
+	body   []byte
+	err    error
+}
+
+// download fetches the object in ranges of GetRangeReader, up to the concurrency of the options at the same time,
+// and writes them to w at their offsets. The ranges are hashed in order, the MD5 is checked when the provider
+// reports it, e.g. not for S3 objects uploaded in parts. It returns the number of bytes written to w from the offset 0
+// without a gap, on error too: ranges written after a failed one aren't counted.
+// nolint:funlen
+func download(ctx context.Context, storage CloudStorage, key string, w io.WriterAt, opts *DownloadOptions) (int64, error) {
+	if err := opts.validate(); err != nil {
+		return 0, err
+	}
+
+	attrs, err := storage.Attributes(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partSize := opts.partSize()
+	count := int((attrs.Size + partSize - 1) / partSize)
+
+	// a range holds a buffer from its download until it's hashed, the hashing waits for the ranges in order,
+	// so the buffers are taken in order as well and the next range to hash always has one
+	buffers := make(chan struct{}, 2*opts.concurrency())
+	ranges := make(chan *downloadRange)
+	downloaded := make(chan *downloadRange)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for r := range ranges {
+				length := partSize
+				if r.offset+length > attrs.Size {
+					length = attrs.Size - r.offset
+				}
+
+				r.body, r.err = downloadRangeWithRetries(ctx, storage, key, r.offset, length, opts.maxAttempts())
+				if r.err == nil {
+					_, r.err = w.WriteAt(r.body, r.offset)
+				}
+
+				downloaded <- r
+			}
+		}()
+	}
+
+	go func() {
+		defer close(ranges)
+
+		for i := 0; i < count; i++ {
+			select {
+			case buffers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case ranges <- &downloadRange{index: i, offset: int64(i) * partSize}:
+			case <-ctx.Done():
+				<-buffers
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(downloaded)
+	}()
+
+	hash := md5.New() // nolint:gosec
+	pending := make(map[int]*downloadRange)
+	next := 0
+
+	var (
+		written     int64
+		downloadErr error
+	)
+
+	// the ranges written after a failure are still counted up to the first failed one
+	for r := range downloaded {
+		if r.err != nil {
+			if downloadErr == nil {
+				downloadErr = r.err
+				cancel()
+			}
+
+			continue
+		}
+
+		pending[r.index] = r
+
+		for pending[next] != nil {
+			r := pending[next]
+			delete(pending, next)
+
+			hash.Write(r.body) // nolint:errcheck,gosec
+			written += int64(len(r.body))
+			next++
+			<-buffers
+
+			if opts != nil && opts.Progress != nil {
+				opts.Progress(written)
+			}
+		}
+	}
+
+	if downloadErr != nil {
+		return written, downloadErr
+	}
+
+	if next < count {
+		return written, ctx.Err()
+	}
+
+	if len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, hash.Sum(nil)) {
+		return written, newError(CodeUnknown, fmt.Errorf("downloaded '%s' with MD5 %x, expected %x, the object may have changed",
+			key, hash.Sum(nil), attrs.MD5))
+	}
+
+	return written, nil
+}
+
+// downloadRangeWithRetries reads the range, a range failed with a transient error, including a stream broken
+// while it's read, is downloaded again.
+func downloadRangeWithRetries(
+	ctx context.Context,
+	storage CloudStorage,
+	key string,
+	offset,
+	length int64,
+	maxAttempts int,
+) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		body, err := readRange(ctx, storage, key, offset, length)
+		if err == nil || attempt >= maxAttempts || ctx.Err() != nil || !isTransientError(err) {
+			return body, err
+		}
+
+		timer := time.NewTimer(downloadRetryPolicy.backoff(attempt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// readRange reads the whole range, a shorter body fails with io.ErrUnexpectedEOF.
+func readRange(ctx context.Context, storage CloudStorage, key string, offset, length int64) ([]byte, error) {
+	reader, err := storage.GetRangeReader(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	body := make([]byte, length)
+
+	if _, err := io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, wrapError(err)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// brokenRangeCloudStorage breaks the first range read at every offset and reports a wrong MD5 if asked to.
+type brokenRangeCloudStorage struct {
+	*MemoryCloudStorage
+	mu       sync.Mutex
+	failed   map[int64]bool
+	wrongMD5 bool
+}
+
+func (ts *brokenRangeCloudStorage) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	attrs, err := ts.MemoryCloudStorage.Attributes(ctx, key)
+	if err == nil && ts.wrongMD5 {
+		attrs.MD5 = []byte("0123456789abcdef")
+	}
+
+	return attrs, err
+}
+
+func (ts *brokenRangeCloudStorage) GetRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := ts.MemoryCloudStorage.GetRangeReader(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.failed[offset] {
+		return reader, nil
+	}
+
+	ts.failed[offset] = true
+
+	// the connection is reset in the middle of the range
+	return ioutil.NopCloser(io.MultiReader(io.LimitReader(reader, length/2), &failingReader{err: syscall.ECONNRESET})), nil
+}
+
+// failedRangeCloudStorage fails the range at failedOffset and slows down the ones before it.
+type failedRangeCloudStorage struct {
+	*MemoryCloudStorage
+	failedOffset int64
+}
+
+func (ts *failedRangeCloudStorage) GetRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset == ts.failedOffset {
+		return nil, newError(CodePermissionDenied, errors.New("access denied"))
+	}
+
+	reader, err := ts.MemoryCloudStorage.GetRangeReader(ctx, key, offset, length)
+	if offset < ts.failedOffset {
+		// the range is written after the failure is reported
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return reader, err
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+type bufferWriterAt struct {
+	mu   sync.Mutex
+	body []byte
+}
+
+func (w *bufferWriterAt) WriteAt(p []byte, offset int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if end := int(offset) + len(p); end > len(w.body) {
+		w.body = append(w.body, make([]byte, end-len(w.body))...)
+	}
+
+	return copy(w.body[offset:], p), nil
+}
+
+func TestDownloadRetriesRanges(t *testing.T) {
+	storage := &brokenRangeCloudStorage{MemoryCloudStorage: NewMemoryCloudStorage("bucket"), failed: map[int64]bool{}}
+	ctx := context.Background()
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+
+	require.NoError(t, storage.Write(ctx, "key", body, nil))
+
+	w := &bufferWriterAt{}
+
+	written, err := download(ctx, storage, "key", w, &DownloadOptions{PartSize: 1024, Concurrency: 4})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(body)), written)
+	require.Equal(t, body, w.body)
+	require.Len(t, storage.failed, 10)
+
+	// without retries the broken range fails the download
+	storage.failed = map[int64]bool{}
+
+	_, err = download(ctx, storage, "key", &bufferWriterAt{}, &DownloadOptions{PartSize: 1024, MaxAttempts: 1})
+	require.True(t, errors.Is(err, syscall.ECONNRESET), "got: %v", err)
+}
+
+func TestDownloadCountsRangesBeforeFailure(t *testing.T) {
+	storage := &failedRangeCloudStorage{MemoryCloudStorage: NewMemoryCloudStorage("bucket"), failedOffset: 2048}
+	ctx := context.Background()
+	body := bytes.Repeat([]byte("0123456789"), 500)
+
+	require.NoError(t, storage.Write(ctx, "key", body, nil))
+
+	w := &bufferWriterAt{}
+
+	written, err := download(ctx, storage, "key", w, &DownloadOptions{PartSize: 1024, Concurrency: 4})
+	require.True(t, errors.Is(err, ErrPermissionDenied), "got: %v", err)
+	require.Equal(t, int64(2048), written)
+	require.Equal(t, body[:written], w.body[:written])
+}
+
+func TestDownloadChecksMD5(t *testing.T) {
+	storage := &brokenRangeCloudStorage{MemoryCloudStorage: NewMemoryCloudStorage("bucket"), failed: map[int64]bool{}}
+	ctx := context.Background()
+
+	require.NoError(t, storage.Write(ctx, "key", []byte("body"), nil))
+
+	storage.wrongMD5 = true
+
+	_, err := download(ctx, storage, "key", &bufferWriterAt{}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "MD5")
+
+	_, err = download(ctx, storage, "key", &bufferWriterAt{}, &DownloadOptions{PartSize: -1})
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+}
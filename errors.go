@@ -0,0 +1,354 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"syscall"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Provider independent errors. The errors returned by CloudStorage keep the original provider error,
+// so check them with errors.Is, e.g. errors.Is(err, ErrNotFound), or use Code.
+var (
+	ErrNotFound           = errors.New("commonblobgo: object not found")
+	ErrAlreadyExists      = errors.New("commonblobgo: already exists")
+	ErrPermissionDenied   = errors.New("commonblobgo: permission denied")
+	ErrPreconditionFailed = errors.New("commonblobgo: precondition failed")
+	ErrRateLimited        = errors.New("commonblobgo: rate limited")
+	ErrInvalidArgument    = errors.New("commonblobgo: invalid argument")
+	ErrUnavailable        = errors.New("commonblobgo: service unavailable")
+)
+
+// ErrorCode identifies the kind of an error independently of the provider.
+type ErrorCode int
+
+const (
+	// CodeOK means there is no error.
+	CodeOK ErrorCode = iota
+	// CodeUnknown is returned for errors which can't be classified.
+	CodeUnknown
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodePreconditionFailed
+	CodeRateLimited
+	CodeInvalidArgument
+	// CodeUnavailable covers server-side failures, e.g. 5xx responses.
+	CodeUnavailable
+)
+
+var errorCodeNames = map[ErrorCode]string{
+	CodeOK:                 "ok",
+	CodeUnknown:            "unknown",
+	CodeNotFound:           "not_found",
+	CodeAlreadyExists:      "already_exists",
+	CodePermissionDenied:   "permission_denied",
+	CodePreconditionFailed: "precondition_failed",
+	CodeRateLimited:        "rate_limited",
+	CodeInvalidArgument:    "invalid_argument",
+	CodeUnavailable:        "unavailable",
+}
+
+var errorCodeSentinels = map[ErrorCode]error{
+	CodeNotFound:           ErrNotFound,
+	CodeAlreadyExists:      ErrAlreadyExists,
+	CodePermissionDenied:   ErrPermissionDenied,
+	CodePreconditionFailed: ErrPreconditionFailed,
+	CodeRateLimited:        ErrRateLimited,
+	CodeInvalidArgument:    ErrInvalidArgument,
+	CodeUnavailable:        ErrUnavailable,
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+
+	return errorCodeNames[CodeUnknown]
+}
+
+// Code returns the ErrorCode of the error returned by CloudStorage.
+func Code(err error) ErrorCode {
+	if err == nil {
+		return CodeOK
+	}
+
+	var storageErr *storageError
+	if errors.As(err, &storageErr) {
+		return storageErr.code
+	}
+
+	return classifyError(err)
+}
+
+// storageError attaches an ErrorCode to the provider error.
+type storageError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *storageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *storageError) Unwrap() error {
+	return e.err
+}
+
+func (e *storageError) Is(target error) bool {
+	return target == errorCodeSentinels[e.code]
+}
+
+// newError creates an error of the given code for failures detected by the library itself.
+func newError(code ErrorCode, err error) error {
+	return &storageError{
+		code: code,
+		err:  err,
+	}
+}
+
+// wrapError maps a provider error onto the ErrorCode sentinels. io.EOF, context errors and errors
+// which can't be classified are returned as is.
+func wrapError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	var storageErr *storageError
+	if errors.As(err, &storageErr) {
+		return err
+	}
+
+	code := classifyError(err)
+	if code == CodeUnknown {
+		return err
+	}
+
+	return newError(code, err)
+}
+
+// nolint:gocyclo
+func classifyError(err error) ErrorCode {
+	switch {
+	case errors.Is(err, os.ErrNotExist),
+		errors.Is(err, storage.ErrObjectNotExist),
+		errors.Is(err, storage.ErrBucketNotExist):
+		return CodeNotFound
+	// a file storage key nested below another blob, e.g. "a/b" when "a" exists, or the other way round;
+	// the file storage reports the reads of such keys as not found by itself
+	case errors.Is(err, syscall.ENOTDIR), errors.Is(err, syscall.EISDIR):
+		return CodeInvalidArgument
+	case errors.Is(err, os.ErrExist):
+		return CodeAlreadyExists
+	case errors.Is(err, os.ErrPermission):
+		return CodePermissionDenied
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		// the S3 upload manager reports the failed request as the cause of its own error
+		if awsErr.Code() == "MultipartUpload" && awsErr.OrigErr() != nil {
+			return classifyError(awsErr.OrigErr())
+		}
+
+		if code := classifyAWSErrorCode(awsErr.Code()); code != CodeUnknown {
+			return code
+		}
+
+		var requestFailure awserr.RequestFailure
+		if errors.As(err, &requestFailure) {
+			return classifyHTTPStatus(requestFailure.StatusCode())
+		}
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return classifyHTTPStatus(googleErr.Code)
+	}
+
+	var azureErr azblob.StorageError
+	if errors.As(err, &azureErr) {
+		if code := classifyAzureServiceCode(azureErr.ServiceCode()); code != CodeUnknown {
+			return code
+		}
+
+		if azureErr.Response() != nil {
+			return classifyHTTPStatus(azureErr.Response().StatusCode)
+		}
+	}
+
+	// IAM credentials API used to sign GCP URLs is a gRPC service
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		if code := classifyGRPCCode(grpcErr.GRPCStatus().Code()); code != CodeUnknown {
+			return code
+		}
+	}
+
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		return CodeNotFound
+	case gcerrors.AlreadyExists:
+		return CodeAlreadyExists
+	case gcerrors.PermissionDenied:
+		return CodePermissionDenied
+	case gcerrors.FailedPrecondition:
+		return CodePreconditionFailed
+	case gcerrors.ResourceExhausted:
+		return CodeRateLimited
+	case gcerrors.InvalidArgument:
+		return CodeInvalidArgument
+	case gcerrors.Internal:
+		return CodeUnavailable
+	}
+
+	return CodeUnknown
+}
+
+func classifyAWSErrorCode(code string) ErrorCode {
+	switch code {
+	case "NoSuchKey", "NoSuchBucket", "NotFound":
+		return CodeNotFound
+	case "BucketAlreadyExists", "BucketAlreadyOwnedByYou":
+		return CodeAlreadyExists
+	case "AccessDenied", "Forbidden", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return CodePermissionDenied
+	// ConditionalRequestConflict is reported when a concurrent conditional write is in progress
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return CodePreconditionFailed
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequests":
+		return CodeRateLimited
+	case "InvalidArgument", "InvalidRange", "InvalidDigest", "BadDigest":
+		return CodeInvalidArgument
+	}
+
+	return CodeUnknown
+}
+
+func classifyAzureServiceCode(code azblob.ServiceCodeType) ErrorCode {
+	switch code {
+	case azblob.ServiceCodeBlobNotFound, azblob.ServiceCodeContainerNotFound:
+		return CodeNotFound
+	case azblob.ServiceCodeBlobAlreadyExists, azblob.ServiceCodeContainerAlreadyExists:
+		return CodeAlreadyExists
+	case azblob.ServiceCodeConditionNotMet:
+		return CodePreconditionFailed
+	case azblob.ServiceCodeServerBusy:
+		return CodeRateLimited
+	}
+
+	return CodeUnknown
+}
+
+func classifyGRPCCode(code codes.Code) ErrorCode {
+	switch code {
+	case codes.NotFound:
+		return CodeNotFound
+	case codes.AlreadyExists:
+		return CodeAlreadyExists
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return CodePermissionDenied
+	case codes.FailedPrecondition:
+		return CodePreconditionFailed
+	case codes.ResourceExhausted:
+		return CodeRateLimited
+	case codes.InvalidArgument, codes.OutOfRange:
+		return CodeInvalidArgument
+	case codes.Unavailable, codes.Internal:
+		return CodeUnavailable
+	}
+
+	return CodeUnknown
+}
+
+func classifyHTTPStatus(status int) ErrorCode {
+	switch {
+	// 409 also reports other conflicts, e.g. a lease or a bucket being deleted,
+	// only the provider error codes tell an existing object or bucket apart
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return CodePermissionDenied
+	case status == http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case status == http.StatusTooManyRequests:
+		return CodeRateLimited
+	case status == http.StatusBadRequest, status == http.StatusRequestedRangeNotSatisfiable:
+		return CodeInvalidArgument
+	case status >= http.StatusInternalServerError:
+		return CodeUnavailable
+	}
+
+	return CodeUnknown
+}
+
+// errorMappingWriter maps the errors of a writer, most providers only report failures on Close.
+type errorMappingWriter struct {
+	io.WriteCloser
+}
+
+func (w *errorMappingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+
+	return n, wrapError(err)
+}
+
+func (w *errorMappingWriter) Close() error {
+	return wrapError(w.WriteCloser.Close())
+}
+
+// errorMappingReader maps the errors of a reader, a download can fail after the reader was opened.
+type errorMappingReader struct {
+	io.ReadCloser
+}
+
+func (r *errorMappingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	return n, wrapError(err)
+}
+
+func (r *errorMappingReader) Close() error {
+	return wrapError(r.ReadCloser.Close())
+}
+
+func wrapReader(reader io.ReadCloser, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &errorMappingReader{ReadCloser: reader}, nil
+}
+
+func wrapWriter(writer io.WriteCloser, err error) (io.WriteCloser, error) {
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &errorMappingWriter{WriteCloser: writer}, nil
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorCode(t *testing.T) {
+	_, gocloudErr := memblob.OpenBucket(nil).ReadAll(context.Background(), "missing")
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected ErrorCode
+		sentinel error
+	}{
+		{"os not exist", &os.PathError{Op: "open", Path: "key", Err: os.ErrNotExist}, CodeNotFound, ErrNotFound},
+		{"os permission", &os.PathError{Op: "open", Path: "key", Err: os.ErrPermission}, CodePermissionDenied, ErrPermissionDenied},
+		{"gcs object not exist", storage.ErrObjectNotExist, CodeNotFound, ErrNotFound},
+		{"gocloud not found", gocloudErr, CodeNotFound, ErrNotFound},
+		{"aws no such key", awserr.New("NoSuchKey", "", nil), CodeNotFound, ErrNotFound},
+		{"aws conditional request conflict", awserr.NewRequestFailure(awserr.New("ConditionalRequestConflict", "", nil),
+			http.StatusConflict, ""), CodePreconditionFailed, ErrPreconditionFailed},
+		{"aws slow down", awserr.New("SlowDown", "", nil), CodeRateLimited, ErrRateLimited},
+		{"aws multipart upload", awserr.New("MultipartUpload", "upload multipart failed",
+			awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), http.StatusForbidden, "")),
+			CodePermissionDenied, ErrPermissionDenied},
+		{"aws status only", awserr.NewRequestFailure(awserr.New("Unknown", "", nil), http.StatusPreconditionFailed, ""),
+			CodePreconditionFailed, ErrPreconditionFailed},
+		{"aws server error", awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusServiceUnavailable, ""),
+			CodeUnavailable, ErrUnavailable},
+		// other conflicts than an existing object, e.g. a bucket which isn't empty
+		{"googleapi conflict", &googleapi.Error{Code: http.StatusConflict}, CodeUnknown, nil},
+		{"aws operation aborted", awserr.NewRequestFailure(awserr.New("OperationAborted", "", nil),
+			http.StatusConflict, ""), CodeUnknown, nil},
+		{"aws bucket already exists", awserr.NewRequestFailure(awserr.New("BucketAlreadyOwnedByYou", "", nil),
+			http.StatusConflict, ""), CodeAlreadyExists, ErrAlreadyExists},
+		{"os not a directory", &os.PathError{Op: "open", Path: "a/b", Err: syscall.ENOTDIR}, CodeInvalidArgument, ErrInvalidArgument},
+		{"os is a directory", &os.PathError{Op: "open", Path: "a", Err: syscall.EISDIR}, CodeInvalidArgument, ErrInvalidArgument},
+		{"googleapi too many requests", &googleapi.Error{Code: http.StatusTooManyRequests}, CodeRateLimited, ErrRateLimited},
+		{"grpc permission denied", status.Error(codes.PermissionDenied, "denied"), CodePermissionDenied, ErrPermissionDenied},
+		{"wrapped", fmt.Errorf("read failed: %w", awserr.New("AccessDenied", "", nil)), CodePermissionDenied, ErrPermissionDenied},
+		{"unknown", errors.New("boom"), CodeUnknown, nil},
+		{"context", context.Canceled, CodeUnknown, nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := wrapError(testCase.err)
+
+			require.Equal(t, testCase.expected, Code(err))
+			require.Equal(t, testCase.expected, Code(testCase.err))
+			// the original error is kept
+			require.True(t, errors.Is(err, testCase.err))
+			require.Equal(t, testCase.err.Error(), err.Error())
+
+			if testCase.sentinel != nil {
+				require.True(t, errors.Is(err, testCase.sentinel))
+			}
+
+			for _, other := range []error{ErrNotFound, ErrAlreadyExists, ErrPermissionDenied, ErrPreconditionFailed,
+				ErrRateLimited, ErrInvalidArgument, ErrUnavailable} {
+				if other != testCase.sentinel {
+					require.False(t, errors.Is(err, other), other.Error())
+				}
+			}
+		})
+	}
+
+	require.Equal(t, CodeOK, Code(nil))
+	require.NoError(t, wrapError(nil))
+	require.Equal(t, io.EOF, wrapError(io.EOF))
+	require.Equal(t, "precondition_failed", CodePreconditionFailed.String())
+}
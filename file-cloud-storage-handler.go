@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fileURLMethodParam             = "X-Method"
+	fileURLExpiresParam            = "X-Expires"
+	fileURLContentTypeParam        = "X-Content-Type"
+	fileURLEnforceAbsentCTypeParam = "X-Enforce-Absent-Content-Type"
+	fileURLSignatureParam          = "X-Signature"
+)
+
+// fileURLSigner creates and verifies HMAC-SHA256 signed URLs of the file storage.
+type fileURLSigner struct {
+	key        []byte
+	baseURL    *url.URL
+	bucketName string
+}
+
+func newFileURLSigner(signingKey, baseURL, bucketName string) (*fileURLSigner, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("unable to sign file storage URLs without base URL")
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file storage base URL '%s': %v", baseURL, err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid file storage base URL '%s': scheme and host are required", baseURL)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return &fileURLSigner{
+		key:        []byte(signingKey),
+		baseURL:    u,
+		bucketName: bucketName,
+	}, nil
+}
+
+func (s *fileURLSigner) sign(key string, opts *SignedURLOption) (string, error) {
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+	default:
+		return "", newError(CodeInvalidArgument, fmt.Errorf("unsupported signed URL method: %s", opts.Method))
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(opts.Expiry).Unix(), 10)
+
+	query := url.Values{}
+	query.Set(fileURLMethodParam, method)
+	query.Set(fileURLExpiresParam, expires)
+
+	if opts.ContentType != "" {
+		query.Set(fileURLContentTypeParam, opts.ContentType)
+	}
+
+	if opts.EnforceAbsentContentType {
+		query.Set(fileURLEnforceAbsentCTypeParam, "true")
+	}
+
+	query.Set(fileURLSignatureParam, s.signature(key, query))
+
+	u := *s.baseURL
+	u.Path = path.Join(u.Path, s.bucketName, key)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func (s *fileURLSigner) signature(key string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.key)
+
+	// every signed parameter ends with a new line, so values can't be shifted between fields
+	for _, value := range []string{
+		query.Get(fileURLMethodParam),
+		s.bucketName,
+		key,
+		query.Get(fileURLExpiresParam),
+		query.Get(fileURLContentTypeParam),
+		query.Get(fileURLEnforceAbsentCTypeParam),
+	} {
+		io.WriteString(mac, value+"\n") // nolint:errcheck
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signed URL and returns the key it grants access to.
+func (s *fileURLSigner) verify(r *http.Request) (string, int, error) {
+	bucketPath := s.baseURL.Path + "/" + s.bucketName + "/"
+	if !strings.HasPrefix(r.URL.Path, bucketPath) {
+		return "", http.StatusNotFound, fmt.Errorf("unknown bucket")
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, bucketPath)
+	query := r.URL.Query()
+
+	expected, err := hex.DecodeString(s.signature(key, query))
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	actual, err := hex.DecodeString(query.Get(fileURLSignatureParam))
+	if err != nil || !hmac.Equal(expected, actual) {
+		return "", http.StatusForbidden, fmt.Errorf("invalid signature")
+	}
+
+	expires, err := strconv.ParseInt(query.Get(fileURLExpiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", http.StatusForbidden, fmt.Errorf("signed URL expired")
+	}
+
+	method := query.Get(fileURLMethodParam)
+	if r.Method != method && !(r.Method == http.MethodHead && method == http.MethodGet) {
+		return "", http.StatusMethodNotAllowed, fmt.Errorf("signed URL doesn't allow method %s", r.Method)
+	}
+
+	if r.Method == http.MethodPut {
+		contentType := r.Header.Get("Content-Type")
+
+		if expectedContentType := query.Get(fileURLContentTypeParam); expectedContentType != "" && contentType != expectedContentType {
+			return "", http.StatusForbidden, fmt.Errorf("signed URL requires content type %s", expectedContentType)
+		}
+
+		if query.Get(fileURLEnforceAbsentCTypeParam) != "" && contentType != "" {
+			return "", http.StatusForbidden, fmt.Errorf("signed URL requires absent content type")
+		}
+	}
+
+	return key, http.StatusOK, nil
+}
+
+type fileSignedURLHandler struct {
+	storage *FileCloudStorage
+}
+
+// NewFileSignedURLHandler returns an http.Handler which serves the signed URLs created by FileCloudStorage.GetSignedURL.
+// It has to be reachable under FileStorageBaseURL, the path is matched as is, so the handler must not be mounted
+// behind http.StripPrefix.
+func NewFileSignedURLHandler(storage *FileCloudStorage) http.Handler {
+	return &fileSignedURLHandler{
+		storage: storage,
+	}
+}
+
+func (h *fileSignedURLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.storage.signer == nil {
+		http.Error(w, "signed URLs are not enabled", http.StatusNotFound)
+		return
+	}
+
+	key, status, err := h.storage.signer.verify(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveGet(w, r, key)
+	case http.MethodPut:
+		h.servePut(w, r, key)
+	case http.MethodDelete:
+		if err := h.storage.Delete(r.Context(), key); err != nil {
+			writeFileHandlerError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *fileSignedURLHandler) serveGet(w http.ResponseWriter, r *http.Request, key string) {
+	attrs, err := h.storage.Attributes(r.Context(), key)
+	if err != nil {
+		writeFileHandlerError(w, err)
+		return
+	}
+
+	path, err := h.storage.path(key)
+	if err != nil {
+		writeFileHandlerError(w, err)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeFileHandlerError(w, err)
+		return
+	}
+	defer file.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", attrs.ContentType)
+
+	for name, value := range map[string]string{
+		"Cache-Control":       attrs.CacheControl,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Language":    attrs.ContentLanguage,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	// ServeContent takes care of range and conditional requests
+	http.ServeContent(w, r, "", attrs.ModTime, file)
+}
+
+func (h *fileSignedURLHandler) servePut(w http.ResponseWriter, r *http.Request, key string) {
+	attrs := &fileAttributes{
+		ContentType: r.Header.Get("Content-Type"),
+	}
+
+	writer, err := h.storage.newWriter(r.Context(), key, attrs, nil)
+	if err != nil {
+		writeFileHandlerError(w, err)
+		return
+	}
+
+	if _, err := io.Copy(writer, r.Body); err != nil {
+		writer.abort()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		writeFileHandlerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeFileHandlerError(w http.ResponseWriter, err error) {
+	switch Code(err) {
+	case CodeNotFound:
+		http.Error(w, "not found", http.StatusNotFound)
+	case CodePermissionDenied:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case CodeInvalidArgument:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
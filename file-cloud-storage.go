@@ -0,0 +1,1162 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"crypto/md5" // nolint:gosec
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// fileAttrsSuffix is the suffix of the sidecar file which keeps the attributes of a blob
+	fileAttrsSuffix = ".attrs"
+	// fileInternalDir is a directory inside the bucket reserved for temporary files
+	fileInternalDir = ".commonblobgo"
+	// fileUploadsDir is the directory of the resumable uploads inside fileInternalDir
+	fileUploadsDir = "uploads"
+
+	sniffLen = 512
+)
+
+type FileCloudStorage struct {
+	dir        string
+	bucketName string
+	signer     *fileURLSigner
+	// mu serializes the changes of the blobs, so the preconditions are checked atomically
+	mu sync.Mutex
+}
+
+// fileAttributes is the content of the sidecar file, size and modification time are taken from the blob file itself.
+type fileAttributes struct {
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	ContentType        string            `json:"contentType,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	MD5                []byte            `json:"md5,omitempty"`
+}
+
+func newFileCloudStorage(
+	ctx context.Context,
+	rootDir string,
+	bucketName string,
+	signingKey string,
+	baseURL string,
+	options *storageOptions,
+) (*FileCloudStorage, error) {
+	if rootDir == "" {
+		return nil, fmt.Errorf("unable to create file storage without root directory")
+	}
+
+	if bucketName == "" || strings.ContainsAny(bucketName, `/\`) || bucketName == "." || bucketName == ".." {
+		return nil, fmt.Errorf("invalid bucket name for file storage: '%s'", bucketName)
+	}
+
+	info, err := os.Stat(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access file storage root directory: %v", err)
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("file storage root '%s' is not a directory", rootDir)
+	}
+
+	dir := filepath.Join(rootDir, bucketName)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("unable to create bucket directory: %v", err)
+	}
+
+	var signer *fileURLSigner
+	if signingKey != "" {
+		signer, err = newFileURLSigner(signingKey, baseURL, bucketName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	options.logger.Info("FileCloudStorage created", "bucket", bucketName)
+
+	return &FileCloudStorage{
+		dir:        dir,
+		bucketName: bucketName,
+		signer:     signer,
+	}, nil
+}
+
+func (ts *FileCloudStorage) List(
+	ctx context.Context,
+	prefix string,
+) *ListIterator {
+	return ts.ListWithOptions(ctx, &ListOptions{
+		Prefix: prefix,
+	})
+}
+
+func (ts *FileCloudStorage) ListWithOptions(
+	ctx context.Context,
+	listOptions *ListOptions,
+) *ListIterator {
+	objects, err := ts.listObjects(listOptions)
+
+	return filterListIterator(listOptions, newListIterator(func(ctx context.Context) (*ListObject, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		if len(objects) == 0 {
+			return nil, io.EOF
+		}
+
+		item := objects[0]
+		objects = objects[1:]
+
+		return item, nil
+	}))
+}
+
+// ListPage lists the whole prefix and returns the page after the last key of the previous one.
+func (ts *FileCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	opts = listOptionsOrDefault(opts)
+
+	objects, err := ts.listObjects(opts)
+	if err != nil {
+		return nil, "", wrapError(err)
+	}
+
+	return listObjectsPage(opts, objects, pageToken, pageSize)
+}
+
+// listObjects walks the deepest directory covered by the prefix and collects the matching blobs.
+func (ts *FileCloudStorage) listObjects(listOptions *ListOptions) ([]*ListObject, error) {
+	root, err := ts.listRoot(listOptions.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*ListObject
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(ts.dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if key == fileInternalDir {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if strings.HasSuffix(key, fileAttrsSuffix) || !strings.HasPrefix(key, listOptions.Prefix) ||
+			!isListedAfter(key, false, listOptions.StartAfter) {
+			return nil
+		}
+
+		attrs, err := ts.readAttributes(key)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, &ListObject{
+			Key:     key,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			MD5:     attrs.MD5,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return groupListObjects(objects, listOptions.Prefix, listOptions.Delimiter), nil
+}
+
+// listRoot validates the prefix like path validates the keys and returns the deepest directory it covers.
+func (ts *FileCloudStorage) listRoot(prefix string) (string, error) {
+	i := strings.LastIndex(prefix, "/")
+	if i < 0 {
+		return ts.dir, nil
+	}
+
+	dir := prefix[:i]
+
+	for _, part := range strings.Split(dir, "/") {
+		if part == ".." {
+			return "", newError(CodeInvalidArgument, fmt.Errorf("invalid prefix for file storage: '%s'", prefix))
+		}
+	}
+
+	if dir == fileInternalDir || strings.HasPrefix(dir, fileInternalDir+"/") {
+		return "", newError(CodeInvalidArgument,
+			fmt.Errorf("invalid prefix for file storage: '%s', prefix %s is reserved", prefix, fileInternalDir))
+	}
+
+	root := filepath.Join(ts.dir, filepath.FromSlash(dir))
+
+	// the walk never leaves the bucket directory, whatever the prefix
+	rel, err := filepath.Rel(ts.dir, root)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", newError(CodeInvalidArgument, fmt.Errorf("invalid prefix for file storage: '%s'", prefix))
+	}
+
+	return root, nil
+}
+
+// groupListObjects collapses objects sorted by key into "directories" according to the delimiter,
+// the same way the cloud providers do it.
+func groupListObjects(objects []*ListObject, prefix, delimiter string) []*ListObject {
+	if delimiter == "" {
+		return objects
+	}
+
+	result := make([]*ListObject, 0, len(objects))
+
+	var lastDir string
+
+	for _, object := range objects {
+		rest := strings.TrimPrefix(object.Key, prefix)
+
+		i := strings.Index(rest, delimiter)
+		if i < 0 {
+			result = append(result, object)
+			continue
+		}
+
+		dir := prefix + rest[:i+len(delimiter)]
+		if dir == lastDir {
+			continue
+		}
+
+		lastDir = dir
+
+		result = append(result, &ListObject{
+			Key:   dir,
+			IsDir: true,
+		})
+	}
+
+	return result
+}
+
+func (ts *FileCloudStorage) Get(
+	ctx context.Context,
+	key string,
+) ([]byte, error) {
+	path, err := ts.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(path)
+
+	return body, fileReadError(err)
+}
+
+func (ts *FileCloudStorage) GetReader(
+	ctx context.Context,
+	key string,
+) (io.ReadCloser, error) {
+	return ts.GetRangeReader(ctx, key, 0, -1)
+}
+
+func (ts *FileCloudStorage) GetRangeReader(
+	ctx context.Context,
+	key string,
+	offset,
+	length int64,
+) (io.ReadCloser, error) {
+	path, err := ts.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("invalid range offset: %d", offset))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fileReadError(err)
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, wrapError(err)
+	}
+
+	if length < 0 {
+		return wrapReader(file, nil)
+	}
+
+	return wrapReader(&limitedReadCloser{
+		Reader: io.LimitReader(file, length),
+		Closer: file,
+	}, nil)
+}
+
+func (ts *FileCloudStorage) GetWriter(
+	ctx context.Context,
+	key string,
+) (io.WriteCloser, error) {
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *FileCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := opts.preconditions().validateWrite(filePreconditions); err != nil {
+		return nil, err
+	}
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		return wrapWriter(ts.newWriter(ctx, key, newFileAttributes(opts), opts.preconditions()))
+	})
+}
+
+func (ts *FileCloudStorage) CreateBucket(
+	ctx context.Context,
+	bucketPrefix string,
+	expirationTimeDays int64,
+) error {
+	// the bucket directory is created with the storage, expiration isn't supported
+	return wrapError(os.MkdirAll(ts.dir, 0750))
+}
+
+func (ts *FileCloudStorage) describe() (provider, bucket string) {
+	return "file", ts.bucketName
+}
+
+func (ts *FileCloudStorage) Close() {
+	// nothing to release
+}
+
+func (ts *FileCloudStorage) GetSignedURL(
+	ctx context.Context,
+	key string,
+	opts *SignedURLOption,
+) (string, error) {
+	if _, err := ts.path(key); err != nil {
+		return "", err
+	}
+
+	if ts.signer == nil {
+		return "", fmt.Errorf("unable to create file storage signed URL without signing key")
+	}
+
+	signedURL, err := ts.signer.sign(key, opts)
+
+	return signedURL, wrapError(err)
+}
+
+func (ts *FileCloudStorage) Write(
+	ctx context.Context,
+	key string,
+	body []byte,
+	contentType *string,
+) error {
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *FileCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := opts.preconditions().validateWrite(filePreconditions); err != nil {
+		return err
+	}
+
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	writer, err := ts.newWriter(ctx, key, newFileAttributes(opts), opts.preconditions())
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		writer.abort()
+		return wrapError(err)
+	}
+
+	return wrapError(writer.Close())
+}
+
+func (ts *FileCloudStorage) Delete(
+	ctx context.Context,
+	key string,
+) error {
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *FileCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(filePreconditions); err != nil {
+		return err
+	}
+
+	path, err := ts.path(key)
+	if err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.checkPreconditions(ctx, key, preconditions); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fileReadError(err)
+	}
+
+	if err := os.Remove(path + fileAttrsSuffix); err != nil && !os.IsNotExist(err) {
+		return wrapError(err)
+	}
+
+	// buckets have no real directories, so don't leave empty ones behind
+	for dir := filepath.Dir(path); dir != ts.dir && strings.HasPrefix(dir, ts.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (ts *FileCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+}
+
+func (ts *FileCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *FileCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
+}
+
+func (ts *FileCloudStorage) Attributes(
+	ctx context.Context,
+	key string,
+) (*Attributes, error) {
+	path, err := ts.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fileReadError(err)
+	}
+
+	if info.IsDir() {
+		return nil, wrapError(&os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist})
+	}
+
+	attrs, err := ts.readAttributes(key)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	if attrs.ContentType == "" {
+		attrs.ContentType, err = sniffFileContentType(path)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	return &Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		ModTime:            info.ModTime(),
+		Size:               info.Size(),
+		MD5:                attrs.MD5,
+		ETag:               fileETag(attrs.MD5),
+	}, nil
+}
+
+// fileETag derives the entity tag from the MD5 hash like S3 does, blobs without a sidecar file have none.
+func fileETag(md5 []byte) string {
+	if len(md5) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("\"%x\"", md5)
+}
+
+// checkPreconditions compares the stored blob with the preconditions, the caller must hold mu.
+func (ts *FileCloudStorage) checkPreconditions(ctx context.Context, key string, preconditions *Preconditions) error {
+	if preconditions == nil {
+		return nil
+	}
+
+	attrs, err := ts.Attributes(ctx, key)
+	if err != nil && Code(err) != CodeNotFound {
+		return err
+	}
+
+	if !preconditions.matches(attrs) {
+		return preconditionFailedError(key)
+	}
+
+	return nil
+}
+
+func (ts *FileCloudStorage) Exists(
+	ctx context.Context,
+	key string,
+) (bool, error) {
+	path, err := ts.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		err = fileReadError(err)
+		if Code(err) == CodeNotFound {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return !info.IsDir(), nil
+}
+
+func (ts *FileCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	srcPath, err := ts.path(srcKey)
+	if err != nil {
+		return err
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer srcFile.Close()
+
+	attrs, err := ts.readAttributes(srcKey)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if opts != nil && opts.Metadata != nil {
+		attrs.Metadata = opts.Metadata
+	}
+
+	if opts != nil && opts.ContentType != "" {
+		attrs.ContentType = opts.ContentType
+	}
+
+	writer, err := ts.newWriter(ctx, dstKey, attrs, nil)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if _, err := io.Copy(writer, srcFile); err != nil {
+		writer.abort()
+		return wrapError(err)
+	}
+
+	return wrapError(writer.Close())
+}
+
+func (ts *FileCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+// UpdateAttributes rewrites the sidecar file only, the blob file is kept.
+func (ts *FileCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// checks the blob exists
+	currentAttrs, err := ts.Attributes(ctx, key)
+	if err != nil || patch == nil {
+		return currentAttrs, err
+	}
+
+	fileAttrs, err := ts.readAttributes(key)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	// the sniffed content type isn't stored
+	attrs := &Attributes{
+		CacheControl:       fileAttrs.CacheControl,
+		ContentDisposition: fileAttrs.ContentDisposition,
+		ContentEncoding:    fileAttrs.ContentEncoding,
+		ContentLanguage:    fileAttrs.ContentLanguage,
+		ContentType:        fileAttrs.ContentType,
+		Metadata:           fileAttrs.Metadata,
+	}
+	patch.apply(attrs)
+
+	fileAttrs.CacheControl = attrs.CacheControl
+	fileAttrs.ContentDisposition = attrs.ContentDisposition
+	fileAttrs.ContentEncoding = attrs.ContentEncoding
+	fileAttrs.ContentLanguage = attrs.ContentLanguage
+	fileAttrs.ContentType = attrs.ContentType
+	fileAttrs.Metadata = attrs.Metadata
+
+	attrsBody, err := json.Marshal(fileAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir := filepath.Join(ts.dir, fileInternalDir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0750); err != nil {
+		return nil, err
+	}
+
+	path, err := ts.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomically(tmpDir, path+fileAttrsSuffix, attrsBody); err != nil {
+		return nil, err
+	}
+
+	return ts.Attributes(ctx, key)
+}
+
+func (ts *FileCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := opts.preconditions().validateWrite(filePreconditions); err != nil {
+		return nil, err
+	}
+
+	if _, err := ts.path(key); err != nil {
+		return nil, err
+	}
+
+	return startResumableUpload(ctx, ts, key, opts, resumableUploadPartSize, 0, &fileUploadDriver{storage: ts})
+}
+
+func (ts *FileCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeResumableUpload(ctx, ts, token, &fileUploadDriver{storage: ts})
+}
+
+// AbortStaleUploads removes the resumable uploads without any part committed within the TTL.
+func (ts *FileCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	uploadsDir := filepath.Join(ts.dir, fileInternalDir, fileUploadsDir)
+
+	infos, err := ioutil.ReadDir(uploadsDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, wrapError(err)
+	}
+
+	cutoff := time.Now().Add(-ttl)
+	aborted := 0
+
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return aborted, err
+		}
+
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(uploadsDir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return aborted, wrapError(err)
+		}
+
+		aborted++
+	}
+
+	return aborted, nil
+}
+
+// path validates the key and maps it onto the file system.
+func (ts *FileCloudStorage) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\x00") || strings.Contains(key, `\`) {
+		return "", newError(CodeInvalidArgument, fmt.Errorf("invalid key for file storage: '%s'", key))
+	}
+
+	if strings.HasSuffix(key, fileAttrsSuffix) {
+		return "", newError(CodeInvalidArgument,
+			fmt.Errorf("invalid key for file storage: '%s', suffix %s is reserved", key, fileAttrsSuffix))
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", newError(CodeInvalidArgument, fmt.Errorf("invalid key for file storage: '%s'", key))
+		}
+	}
+
+	if key == fileInternalDir || strings.HasPrefix(key, fileInternalDir+"/") {
+		return "", newError(CodeInvalidArgument,
+			fmt.Errorf("invalid key for file storage: '%s', prefix %s is reserved", key, fileInternalDir))
+	}
+
+	return filepath.Join(ts.dir, filepath.FromSlash(key)), nil
+}
+
+// fileReadError maps the error of reading or deleting a key. A key nested below another blob,
+// e.g. "a/b" when "a" exists, can't be written but doesn't exist either.
+func fileReadError(err error) error {
+	if errors.Is(err, syscall.ENOTDIR) {
+		return newError(CodeNotFound, err)
+	}
+
+	return wrapError(err)
+}
+
+// readAttributes reads the sidecar file of the key, blobs copied into the directory by other means don't have one.
+func (ts *FileCloudStorage) readAttributes(key string) (*fileAttributes, error) {
+	body, err := ioutil.ReadFile(filepath.Join(ts.dir, filepath.FromSlash(key)) + fileAttrsSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &fileAttributes{}, nil
+		}
+
+		return nil, err
+	}
+
+	attrs := &fileAttributes{}
+	if err := json.Unmarshal(body, attrs); err != nil {
+		return nil, fmt.Errorf("unable to read attributes of '%s': %v", key, err)
+	}
+
+	return attrs, nil
+}
+
+// newFileAttributes returns the sidecar content of the options, the MD5 is computed by the writer.
+func newFileAttributes(opts *WriteOptions) *fileAttributes {
+	if opts == nil {
+		return &fileAttributes{}
+	}
+
+	return &fileAttributes{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        opts.ContentType,
+		Metadata:           opts.Metadata,
+	}
+}
+
+func (ts *FileCloudStorage) newWriter(
+	ctx context.Context,
+	key string,
+	attrs *fileAttributes,
+	preconditions *Preconditions,
+) (*fileWriter, error) {
+	path, err := ts.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir := filepath.Join(ts.dir, fileInternalDir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0750); err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile(tmpDir, "blob-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileWriter{
+		ctx:           ctx,
+		storage:       ts,
+		key:           key,
+		file:          file,
+		path:          path,
+		attrs:         attrs,
+		preconditions: preconditions,
+		md5:           md5.New(), // nolint:gosec
+	}, nil
+}
+
+func sniffFileContentType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLen)
+
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// fileWriter writes into a temporary file, the blob becomes visible on Close.
+type fileWriter struct {
+	ctx     context.Context
+	storage *FileCloudStorage
+	key     string
+	file    *os.File
+	path    string
+	attrs   *fileAttributes
+	// preconditions are checked on Close
+	preconditions *Preconditions
+	md5           hash.Hash
+	sniff         []byte
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if len(w.sniff) < sniffLen {
+		n := sniffLen - len(w.sniff)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		w.sniff = append(w.sniff, p[:n]...)
+	}
+
+	n, err := w.file.Write(p)
+	w.md5.Write(p[:n])
+
+	return n, err
+}
+
+func (w *fileWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		w.abort()
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+
+	if w.attrs.ContentType == "" {
+		w.attrs.ContentType = http.DetectContentType(w.sniff)
+	}
+
+	w.attrs.MD5 = w.md5.Sum(nil)
+
+	return w.storage.commitFile(w.ctx, w.key, w.file.Name(), w.path, w.attrs, w.preconditions)
+}
+
+// commitFile moves the written temporary file onto the path of the key when the stored blob matches
+// the preconditions, the temporary file is removed on failure.
+func (ts *FileCloudStorage) commitFile(
+	ctx context.Context,
+	key string,
+	tmpPath string,
+	path string,
+	attrs *fileAttributes,
+	preconditions *Preconditions,
+) error {
+	attrsBody, err := json.Marshal(attrs)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.checkPreconditions(ctx, key, preconditions); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// other blobs are nested below the key, e.g. "a/b" when writing "a"
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		os.Remove(tmpPath)
+		return &os.PathError{Op: "rename", Path: path, Err: syscall.EISDIR}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return writeFileAtomically(filepath.Dir(tmpPath), path+fileAttrsSuffix, attrsBody)
+}
+
+func (w *fileWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// writeFileAtomically makes sure readers never observe a partially written file,
+// tmpDir must be on the same file system as path.
+func writeFileAtomically(tmpDir, path string, body []byte) error {
+	file, err := ioutil.TempFile(tmpDir, "attrs-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// fileUploadDriver appends the parts of the resumable uploads to a file named by the upload ID,
+// the last part moves it onto the blob.
+type fileUploadDriver struct {
+	storage *FileCloudStorage
+}
+
+// uploadPath returns the file of the upload, the ID comes from the token and is validated.
+func (d *fileUploadDriver) uploadPath(session *uploadSession) (string, error) {
+	if _, err := uuid.Parse(session.ID); err != nil {
+		return "", newError(CodeInvalidArgument, fmt.Errorf("invalid upload ID '%s'", session.ID))
+	}
+
+	return filepath.Join(d.storage.dir, fileInternalDir, fileUploadsDir, session.ID), nil
+}
+
+func (d *fileUploadDriver) startUpload(ctx context.Context, session *uploadSession) error {
+	session.ID = uuid.New().String()
+
+	path, err := d.uploadPath(session)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// resumeUpload continues after the content of the file, the parts are committed once they are synced.
+func (d *fileUploadDriver) resumeUpload(ctx context.Context, session *uploadSession) error {
+	path, err := d.uploadPath(session)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	session.Offset = info.Size()
+
+	return nil
+}
+
+func (d *fileUploadDriver) uploadPart(ctx context.Context, session *uploadSession, part []byte, last bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := d.uploadPath(session)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	// drop the content a failed part left behind
+	err = file.Truncate(session.Offset)
+	if err == nil {
+		_, err = file.WriteAt(part, session.Offset)
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	session.Offset += int64(len(part))
+
+	if !last {
+		return nil
+	}
+
+	attrs := newFileAttributes(session.Options)
+
+	attrs.MD5, err = fileMD5(path)
+	if err != nil {
+		return err
+	}
+
+	blobPath, err := d.storage.path(session.Key)
+	if err != nil {
+		return err
+	}
+
+	return d.storage.commitFile(ctx, session.Key, path, blobPath, attrs, session.Options.preconditions())
+}
+
+func (d *fileUploadDriver) abortUpload(ctx context.Context, session *uploadSession) error {
+	path, err := d.uploadPath(session)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func fileMD5(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := md5.New() // nolint:gosec
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileCloudStorage(t *testing.T, baseURL string) (*FileCloudStorage, func()) {
+	rootDir, err := ioutil.TempDir("", "common-blob-go")
+	require.NoError(t, err)
+
+	storage, err := newFileCloudStorage(context.Background(), rootDir, "bucket", "signing-key", baseURL, newStorageOptions(nil))
+	require.NoError(t, err)
+
+	return storage, func() {
+		os.RemoveAll(rootDir)
+	}
+}
+
+func TestFileCloudStorageSignedURLHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	storage, cleanup := newTestFileCloudStorage(t, server.URL+"/blobs")
+	defer cleanup()
+
+	mux.Handle("/blobs/", NewFileSignedURLHandler(storage))
+
+	ctx := context.Background()
+	key := "user/1/export.json"
+
+	putURL, err := storage.GetSignedURL(ctx, key, &SignedURLOption{
+		Method:      http.MethodPut,
+		Expiry:      time.Minute,
+		ContentType: "application/json",
+	})
+	require.NoError(t, err)
+
+	// content type is part of the signature
+	resp := doFileRequest(t, http.MethodPut, putURL, "text/plain", "{}")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = doFileRequest(t, http.MethodPut, putURL, "application/json", `{"key": "value"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	attrs, err := storage.Attributes(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "application/json", attrs.ContentType)
+
+	getURL, err := storage.GetSignedURL(ctx, key, &SignedURLOption{
+		Method: http.MethodGet,
+		Expiry: time.Minute,
+	})
+	require.NoError(t, err)
+
+	// a URL signed for GET doesn't allow other methods
+	resp = doFileRequest(t, http.MethodDelete, getURL, "", "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, getURL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=1-5")
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, `"key"`, string(body))
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// tampering with the key invalidates the signature
+	resp = doFileRequest(t, http.MethodGet, strings.Replace(getURL, "export.json", "other.json", 1), "", "")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	expiredURL, err := storage.GetSignedURL(ctx, key, &SignedURLOption{
+		Method: http.MethodGet,
+		Expiry: -time.Minute,
+	})
+	require.NoError(t, err)
+
+	resp = doFileRequest(t, http.MethodGet, expiredURL, "", "")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	deleteURL, err := storage.GetSignedURL(ctx, key, &SignedURLOption{
+		Method: http.MethodDelete,
+		Expiry: time.Minute,
+	})
+	require.NoError(t, err)
+
+	resp = doFileRequest(t, http.MethodDelete, deleteURL, "", "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = doFileRequest(t, http.MethodGet, getURL, "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func doFileRequest(t *testing.T, method, url, contentType, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestFileCloudStorageKeys(t *testing.T) {
+	storage, cleanup := newTestFileCloudStorage(t, "http://localhost/blobs")
+	defer cleanup()
+
+	ctx := context.Background()
+
+	for _, key := range []string{"", "/abs", "a/../b", "a//b", "a.attrs", ".commonblobgo/tmp/x"} {
+		err := storage.Write(ctx, key, []byte("body"), nil)
+		require.True(t, errors.Is(err, ErrInvalidArgument), key)
+	}
+
+	// sidecar files and temporary files are never listed
+	require.NoError(t, storage.Write(ctx, "a/b.txt", []byte("body"), nil))
+	require.NoError(t, storage.Write(ctx, "a/c/d.txt", []byte("body"), nil))
+	require.NoError(t, storage.Write(ctx, "a-e.txt", []byte("body"), nil))
+
+	var keys []string
+
+	iter := storage.ListWithOptions(ctx, &ListOptions{Prefix: "a", Delimiter: "/"})
+
+	for {
+		item, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		keys = append(keys, item.Key)
+	}
+
+	require.Equal(t, []string{"a-e.txt", "a/"}, keys)
+
+	attrs, err := storage.Attributes(ctx, "a/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain; charset=utf-8", attrs.ContentType)
+	require.NotEmpty(t, attrs.MD5)
+
+	// deleting the last blob of a "directory" removes the directory as well
+	require.NoError(t, storage.Delete(ctx, "a/c/d.txt"))
+
+	_, err = os.Stat(storage.dir + "/a/c")
+	require.True(t, os.IsNotExist(err))
+
+	reader, err := storage.GetRangeReader(ctx, "a/b.txt", 2, -1)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.True(t, bytes.Equal([]byte("dy"), body))
+}
+
+func TestFileCloudStorageNestedKeys(t *testing.T) {
+	storage, cleanup := newTestFileCloudStorage(t, "http://localhost/blobs")
+	defer cleanup()
+
+	ctx := context.Background()
+
+	require.NoError(t, storage.Write(ctx, "a", []byte("body"), nil))
+	require.NoError(t, storage.Write(ctx, "b/c", []byte("body"), nil))
+
+	// a blob can't be stored below another one, nor where other blobs are nested
+	err := storage.Write(ctx, "a/b", []byte("body"), nil)
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+
+	err = storage.Write(ctx, "b", []byte("body"), nil)
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+
+	// but it doesn't exist either
+	_, err = storage.Get(ctx, "a/b")
+	require.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+
+	_, err = storage.GetReader(ctx, "a/b")
+	require.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+
+	_, err = storage.Attributes(ctx, "a/b")
+	require.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+
+	exists, err := storage.Exists(ctx, "a/b")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = storage.Delete(ctx, "a/b")
+	require.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+}
+
+func TestFileCloudStorageListPrefixes(t *testing.T) {
+	storage, cleanup := newTestFileCloudStorage(t, "http://localhost/blobs")
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// a file next to the bucket directory
+	outside := storage.dir + "-other"
+	require.NoError(t, os.MkdirAll(outside, 0o755))
+
+	defer os.RemoveAll(outside)
+
+	require.NoError(t, ioutil.WriteFile(outside+"/secret", []byte("secret"), 0o600))
+	require.NoError(t, storage.Write(ctx, "a/b.txt", []byte("body"), nil))
+
+	// a pending resumable upload
+	uploadsDir := filepath.Join(storage.dir, fileInternalDir, fileUploadsDir)
+	require.NoError(t, os.MkdirAll(uploadsDir, 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(uploadsDir, "upload"), []byte("part"), 0o600))
+
+	for _, prefix := range []string{
+		"../bucket-other/",
+		"a/../../bucket-other/",
+		"../",
+		".commonblobgo/",
+		".commonblobgo/uploads/",
+	} {
+		_, err := storage.List(ctx, prefix).Next(ctx)
+		require.True(t, errors.Is(err, ErrInvalidArgument), prefix)
+
+		_, _, err = storage.ListPage(ctx, &ListOptions{Prefix: prefix}, "", 0)
+		require.True(t, errors.Is(err, ErrInvalidArgument), prefix)
+	}
+
+	// the reserved directory is skipped when listing the whole bucket
+	items, err := storage.List(ctx, "").Collect(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "a/b.txt", items[0].Key)
+
+	items, err = storage.List(ctx, ".commonblobgo").Collect(ctx, 0)
+	require.NoError(t, err)
+	require.Empty(t, items)
+}
+
+func TestFileCloudStorageResumableUploads(t *testing.T) {
+	storage, cleanup := newTestFileCloudStorage(t, "http://localhost/blobs")
+	defer cleanup()
+
+	ctx := context.Background()
+
+	upload, err := storage.StartUpload(ctx, "a.txt", &WriteOptions{Upload: &UploadOptions{PartSize: 4}})
+	require.NoError(t, err)
+
+	_, err = upload.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), upload.Offset())
+
+	// a part written by a crashed process without a new token is picked up from the file size
+	stale := upload.session.ID
+
+	_, err = upload.Write([]byte("ab"))
+	require.NoError(t, err)
+
+	upload.session.Offset = 8
+
+	upload, err = storage.ResumeUpload(ctx, upload.Token())
+	require.NoError(t, err)
+	require.Equal(t, int64(12), upload.Offset())
+
+	// the upload ID is a file name, it's never trusted
+	upload.session.ID = "../../a.txt"
+
+	_, err = storage.ResumeUpload(ctx, upload.Token())
+	require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+
+	upload.session.ID = stale
+	path := storage.dir + "/" + fileInternalDir + "/" + fileUploadsDir + "/" + stale
+	old := time.Now().Add(-2 * time.Hour)
+
+	require.NoError(t, os.Chtimes(path, old, old))
+
+	aborted, err := storage.AbortStaleUploads(ctx, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, aborted)
+
+	_, err = storage.ResumeUpload(ctx, upload.Token())
+	require.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+
+	exists, err := storage.Exists(ctx, "a.txt")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
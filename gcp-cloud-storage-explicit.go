@@ -21,10 +21,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/sirupsen/logrus"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/gcsblob"
 	"gocloud.dev/gcp"
@@ -34,6 +34,7 @@ import (
 
 type ExplicitGCPCloudStorage struct {
 	client          *storage.Client
+	httpClient      *http.Client
 	bucket          *blob.Bucket
 	bucketName      string
 	privateKey      []byte
@@ -51,6 +52,7 @@ func newExplicitGCPCloudStorage(
 	ctx context.Context,
 	gcpCredentialJSON string,
 	bucketName string,
+	options *storageOptions,
 ) (*ExplicitGCPCloudStorage, error) {
 	gcpCredentialJSONBytes := []byte(gcpCredentialJSON)
 
@@ -66,19 +68,19 @@ func newExplicitGCPCloudStorage(
 		return nil, fmt.Errorf("unable to unmarshal credentials: %v", err)
 	}
 
-	client, err := storage.NewClient(ctx, option.WithCredentials(creds))
-	if err != nil {
-		return nil, fmt.Errorf("unable to create GCP client: %v", err)
-	}
-
 	bucketHTTPClient, err := gcp.NewHTTPClient(
-		gcp.DefaultTransport(),
+		options.transport(gcp.DefaultTransport()),
 		gcp.CredentialsTokenSource(creds),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create GCP HTTP Client: %v", err)
 	}
 
+	client, err := newGCPStorageClient(ctx, creds, bucketHTTPClient, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GCP client: %v", err)
+	}
+
 	bucket, err := gcsblob.OpenBucket(
 		ctx,
 		bucketHTTPClient,
@@ -89,10 +91,13 @@ func newExplicitGCPCloudStorage(
 		return nil, err
 	}
 
-	logrus.Infof("explicit GCP CloudStorage created")
+	disableGCSRetries(client, bucket, options)
+
+	options.logger.Info("explicit GCP CloudStorage created", "bucket", bucketName)
 
 	return &ExplicitGCPCloudStorage{
 		client:         client,
+		httpClient:     &bucketHTTPClient.Client,
 		bucketName:     bucketName,
 		bucket:         bucket,
 		googleAccessID: sign.GoogleAccessID,
@@ -103,6 +108,36 @@ func newExplicitGCPCloudStorage(
 	}, nil
 }
 
+// newGCPStorageClient creates the raw GCS client, it shares the authorized HTTP client of the bucket
+// when the options customize the requests.
+func newGCPStorageClient(
+	ctx context.Context,
+	creds *google.Credentials,
+	bucketHTTPClient *gcp.HTTPClient,
+	options *storageOptions,
+) (*storage.Client, error) {
+	if options.httpClient == nil && options.userAgent == "" {
+		return storage.NewClient(ctx, option.WithCredentials(creds))
+	}
+
+	return storage.NewClient(ctx, option.WithHTTPClient(&bucketHTTPClient.Client))
+}
+
+// disableGCSRetries turns off the retries of the raw client and of the bucket client when WithRetry is set,
+// the calls are retried by retryingCloudStorage.
+func disableGCSRetries(client *storage.Client, bucket *blob.Bucket, options *storageOptions) {
+	if options.retry == nil {
+		return
+	}
+
+	client.SetRetry(storage.WithPolicy(storage.RetryNever))
+
+	var bucketClient *storage.Client
+	if bucket.As(&bucketClient) {
+		bucketClient.SetRetry(storage.WithPolicy(storage.RetryNever))
+	}
+}
+
 func (ts *ExplicitGCPCloudStorage) List(
 	ctx context.Context,
 	prefix string,
@@ -111,7 +146,7 @@ func (ts *ExplicitGCPCloudStorage) List(
 		Prefix: prefix,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return hideReservedObjects(newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -123,19 +158,24 @@ func (ts *ExplicitGCPCloudStorage) List(
 			Size:    attrs.Size,
 			MD5:     attrs.MD5,
 		}, nil
-	})
+	}))
 }
 
 func (ts *ExplicitGCPCloudStorage) ListWithOptions(
 	ctx context.Context,
 	listOptions *ListOptions,
 ) *ListIterator {
+	// the storage client can't start the listing after a key
+	if listOptions.StartAfter != "" {
+		return filterListIterator(listOptions, newPagedListIterator(ts, listOptions))
+	}
+
 	iter := ts.bucket.List(&blob.ListOptions{
 		Prefix:    listOptions.Prefix,
 		Delimiter: listOptions.Delimiter,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return filterListIterator(listOptions, hideReservedObjects(newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -148,23 +188,40 @@ func (ts *ExplicitGCPCloudStorage) ListWithOptions(
 			MD5:     attrs.MD5,
 			IsDir:   attrs.IsDir,
 		}, nil
-	})
+	})))
+}
+
+func (ts *ExplicitGCPCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	return listGCSPage(ctx, ts.httpClient, ts.bucketName, opts, pageToken, pageSize)
 }
 
 func (ts *ExplicitGCPCloudStorage) Get(
 	ctx context.Context,
 	key string,
 ) ([]byte, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
 	body, err := ts.bucket.ReadAll(ctx, key)
 
-	return body, err
+	return body, wrapError(err)
 }
 
 func (ts *ExplicitGCPCloudStorage) GetReader(
 	ctx context.Context,
 	key string,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewReader(ctx, key, nil)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return wrapReader(ts.bucket.NewReader(ctx, key, nil))
 }
 
 func (ts *ExplicitGCPCloudStorage) GetRangeReader(
@@ -173,14 +230,36 @@ func (ts *ExplicitGCPCloudStorage) GetRangeReader(
 	offset,
 	length int64,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return wrapReader(ts.bucket.NewRangeReader(ctx, key, offset, length, nil))
 }
 
 func (ts *ExplicitGCPCloudStorage) GetWriter(
 	ctx context.Context,
 	key string,
 ) (io.WriteCloser, error) {
-	return ts.bucket.NewWriter(ctx, key, nil)
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *ExplicitGCPCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return nil, err
+	}
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		return wrapWriter(ts.bucket.NewWriter(ctx, key, opts.blobWriterOptions()))
+	})
 }
 
 func (ts *ExplicitGCPCloudStorage) CreateBucket(
@@ -192,6 +271,10 @@ func (ts *ExplicitGCPCloudStorage) CreateBucket(
 	return nil
 }
 
+func (ts *ExplicitGCPCloudStorage) describe() (provider, bucket string) {
+	return "gcp", ts.bucketName
+}
+
 func (ts *ExplicitGCPCloudStorage) Close() {
 	ts.bucketCloseFunc()
 }
@@ -201,12 +284,18 @@ func (ts *ExplicitGCPCloudStorage) GetSignedURL(
 	key string,
 	opts *SignedURLOption,
 ) (string, error) {
-	return storage.SignedURL(ts.bucketName, key, &storage.SignedURLOptions{
+	if err := checkReservedKey("GCS", key); err != nil {
+		return "", err
+	}
+
+	signedURL, err := storage.SignedURL(ts.bucketName, key, &storage.SignedURLOptions{
 		GoogleAccessID: ts.googleAccessID,
 		PrivateKey:     ts.privateKey,
 		Method:         opts.Method,
 		Expires:        time.Now().Add(opts.Expiry).UTC(),
 	})
+
+	return signedURL, wrapError(err)
 }
 
 func (ts *ExplicitGCPCloudStorage) Write(
@@ -215,46 +304,181 @@ func (ts *ExplicitGCPCloudStorage) Write(
 	body []byte,
 	contentType *string,
 ) error {
-	options := &blob.WriterOptions{}
-	if contentType != nil {
-		options.ContentType = *contentType
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *ExplicitGCPCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return err
+	}
+
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return err
+	}
+
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
 	}
 
-	return ts.bucket.WriteAll(ctx, key, body, options)
+	return opts.preconditions().wrapError(key, wrapError(ts.bucket.WriteAll(ctx, key, body, opts.blobWriterOptions())))
 }
 
 func (ts *ExplicitGCPCloudStorage) Delete(
 	ctx context.Context,
 	key string,
 ) error {
-	return ts.client.Bucket(ts.bucketName).Object(key).Delete(ctx)
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *ExplicitGCPCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return err
+	}
+
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(gcsPreconditions); err != nil {
+		return err
+	}
+
+	object := ts.client.Bucket(ts.bucketName).Object(key)
+	if conditions := preconditions.gcsConditions(); conditions != nil {
+		object = object.If(*conditions)
+	}
+
+	return preconditions.wrapError(key, wrapError(object.Delete(ctx)))
+}
+
+// DeleteMany deletes the keys with parallel calls, the JSON API has no batch delete.
+func (ts *ExplicitGCPCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+}
+
+func (ts *ExplicitGCPCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *ExplicitGCPCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
 }
 
 func (ts *ExplicitGCPCloudStorage) Attributes(
 	ctx context.Context,
 	key string,
 ) (*Attributes, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
 	attrs, err := ts.bucket.Attributes(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
-	return &Attributes{
-		CacheControl:       attrs.CacheControl,
-		ContentDisposition: attrs.ContentDisposition,
-		ContentEncoding:    attrs.ContentEncoding,
-		ContentLanguage:    attrs.ContentLanguage,
-		ContentType:        attrs.ContentType,
-		Metadata:           attrs.Metadata,
-		ModTime:            attrs.ModTime,
-		Size:               attrs.Size,
-		MD5:                attrs.MD5,
-	}, nil
+	return newBlobAttributes(attrs), nil
 }
 
 func (ts *ExplicitGCPCloudStorage) Exists(
 	ctx context.Context,
 	key string,
 ) (bool, error) {
-	return ts.bucket.Exists(ctx, key)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return false, err
+	}
+
+	exists, err := ts.bucket.Exists(ctx, key)
+
+	return exists, wrapError(err)
+}
+
+func (ts *ExplicitGCPCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	if err := checkReservedKey("GCS", srcKey); err != nil {
+		return err
+	}
+
+	if err := checkReservedKey("GCS", dstKey); err != nil {
+		return err
+	}
+
+	return wrapError(copyBucketObject(ctx, ts.bucket, ts.bucketName, srcKey, dstKey, opts))
+}
+
+func (ts *ExplicitGCPCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+func (ts *ExplicitGCPCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	attrs, err := updateGCSObjectAttributes(ctx, ts.client, ts.bucketName, key, patch)
+
+	return attrs, wrapError(err)
+}
+
+// StartUpload starts a resumable upload session, the parts are rounded up to a multiple of 256 KiB.
+func (ts *ExplicitGCPCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return startGCSUpload(ctx, ts, ts.httpClient, ts.bucketName, key, opts)
+}
+
+func (ts *ExplicitGCPCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeGCSUpload(ctx, ts, ts.httpClient, ts.bucketName, token)
+}
+
+// AbortStaleUploads cancels the upload sessions started longer than the TTL ago, from their records
+// under the reserved prefix.
+func (ts *ExplicitGCPCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	return abortStaleGCSUploads(ctx, ts.httpClient, ts.bucketName, ttl)
 }
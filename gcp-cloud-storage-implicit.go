@@ -20,12 +20,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	compMeta "cloud.google.com/go/compute/metadata"
 	credentials "cloud.google.com/go/iam/credentials/apiv1"
 	"cloud.google.com/go/storage"
-	"github.com/sirupsen/logrus"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/gcsblob"
 	"gocloud.dev/gcp"
@@ -36,6 +36,7 @@ import (
 
 type ImplicitGCPCloudStorage struct {
 	client               *storage.Client
+	httpClient           *http.Client
 	bucket               *blob.Bucket
 	bucketName           string
 	serviceAccountEmail  string
@@ -47,6 +48,7 @@ type ImplicitGCPCloudStorage struct {
 func newImplicitGCPCloudStorage(
 	ctx context.Context,
 	bucketName string,
+	options *storageOptions,
 ) (*ImplicitGCPCloudStorage, error) {
 	creds, err := gcp.DefaultCredentials(ctx)
 	if err != nil {
@@ -57,29 +59,34 @@ func newImplicitGCPCloudStorage(
 		return nil, fmt.Errorf("unable to initialize GCP creds from default credentials: %v", err)
 	}
 
-	iamCredentialsClient, err := credentials.NewIamCredentialsClient(ctx)
-	if err != nil {
-		return nil, err
+	var iamOptions []option.ClientOption
+	if options.userAgent != "" {
+		iamOptions = append(iamOptions, option.WithUserAgent(options.userAgent))
 	}
 
-	serviceAccountID, err := getDefaultServiceAccountEmail(ctx, creds)
+	iamCredentialsClient, err := credentials.NewIamCredentialsClient(ctx, iamOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := storage.NewClient(ctx, option.WithCredentials(creds))
+	serviceAccountID, err := getDefaultServiceAccountEmail(ctx, creds)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create GCP client: %v", err)
+		return nil, err
 	}
 
 	bucketHTTPClient, err := gcp.NewHTTPClient(
-		gcp.DefaultTransport(),
+		options.transport(gcp.DefaultTransport()),
 		gcp.CredentialsTokenSource(creds),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create GCP HTTP Client: %v", err)
 	}
 
+	client, err := newGCPStorageClient(ctx, creds, bucketHTTPClient, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GCP client: %v", err)
+	}
+
 	bucket, err := gcsblob.OpenBucket(
 		ctx,
 		bucketHTTPClient,
@@ -90,10 +97,13 @@ func newImplicitGCPCloudStorage(
 		return nil, err
 	}
 
-	logrus.Infof("implicit GCP CloudStorage created")
+	disableGCSRetries(client, bucket, options)
+
+	options.logger.Info("implicit GCP CloudStorage created", "bucket", bucketName)
 
 	return &ImplicitGCPCloudStorage{
 		client:              client,
+		httpClient:          &bucketHTTPClient.Client,
 		bucketName:          bucketName,
 		bucket:              bucket,
 		serviceAccountEmail: serviceAccountID,
@@ -112,7 +122,7 @@ func (ts *ImplicitGCPCloudStorage) List(
 		Prefix: prefix,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return hideReservedObjects(newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -124,19 +134,24 @@ func (ts *ImplicitGCPCloudStorage) List(
 			Size:    attrs.Size,
 			MD5:     attrs.MD5,
 		}, nil
-	})
+	}))
 }
 
 func (ts *ImplicitGCPCloudStorage) ListWithOptions(
 	ctx context.Context,
 	listOptions *ListOptions,
 ) *ListIterator {
+	// the storage client can't start the listing after a key
+	if listOptions.StartAfter != "" {
+		return filterListIterator(listOptions, newPagedListIterator(ts, listOptions))
+	}
+
 	iter := ts.bucket.List(&blob.ListOptions{
 		Prefix:    listOptions.Prefix,
 		Delimiter: listOptions.Delimiter,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return filterListIterator(listOptions, hideReservedObjects(newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next(ctx)
 		if err != nil {
 			return nil, err
@@ -149,23 +164,40 @@ func (ts *ImplicitGCPCloudStorage) ListWithOptions(
 			MD5:     attrs.MD5,
 			IsDir:   attrs.IsDir,
 		}, nil
-	})
+	})))
+}
+
+func (ts *ImplicitGCPCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	return listGCSPage(ctx, ts.httpClient, ts.bucketName, opts, pageToken, pageSize)
 }
 
 func (ts *ImplicitGCPCloudStorage) Get(
 	ctx context.Context,
 	key string,
 ) ([]byte, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
 	body, err := ts.bucket.ReadAll(ctx, key)
 
-	return body, err
+	return body, wrapError(err)
 }
 
 func (ts *ImplicitGCPCloudStorage) GetReader(
 	ctx context.Context,
 	key string,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewReader(ctx, key, nil)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return wrapReader(ts.bucket.NewReader(ctx, key, nil))
 }
 
 func (ts *ImplicitGCPCloudStorage) GetRangeReader(
@@ -174,14 +206,36 @@ func (ts *ImplicitGCPCloudStorage) GetRangeReader(
 	offset,
 	length int64,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return wrapReader(ts.bucket.NewRangeReader(ctx, key, offset, length, nil))
 }
 
 func (ts *ImplicitGCPCloudStorage) GetWriter(
 	ctx context.Context,
 	key string,
 ) (io.WriteCloser, error) {
-	return ts.bucket.NewWriter(ctx, key, nil)
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *ImplicitGCPCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return nil, err
+	}
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		return wrapWriter(ts.bucket.NewWriter(ctx, key, opts.blobWriterOptions()))
+	})
 }
 
 func (ts *ImplicitGCPCloudStorage) CreateBucket(
@@ -193,6 +247,10 @@ func (ts *ImplicitGCPCloudStorage) CreateBucket(
 	return nil
 }
 
+func (ts *ImplicitGCPCloudStorage) describe() (provider, bucket string) {
+	return "gcp", ts.bucketName
+}
+
 func (ts *ImplicitGCPCloudStorage) Close() {
 	ts.bucketCloseFunc()
 }
@@ -202,6 +260,10 @@ func (ts *ImplicitGCPCloudStorage) GetSignedURL(
 	key string,
 	opts *SignedURLOption,
 ) (string, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return "", err
+	}
+
 	// we use GCP IAM client to sign bytes body(url)
 	// for details read https://github.com/googleapis/google-cloud-go/issues/1130#issuecomment-484236791
 	name := fmt.Sprintf("projects/-/serviceAccounts/%s", ts.serviceAccountEmail)
@@ -225,7 +287,9 @@ func (ts *ImplicitGCPCloudStorage) GetSignedURL(
 		},
 	}
 
-	return storage.SignedURL(ts.bucketName, key, options)
+	signedURL, err := storage.SignedURL(ts.bucketName, key, options)
+
+	return signedURL, wrapError(err)
 }
 
 func (ts *ImplicitGCPCloudStorage) Write(
@@ -234,48 +298,154 @@ func (ts *ImplicitGCPCloudStorage) Write(
 	body []byte,
 	contentType *string,
 ) error {
-	options := &blob.WriterOptions{}
-	if contentType != nil {
-		options.ContentType = *contentType
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *ImplicitGCPCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return err
+	}
+
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
 	}
 
-	return ts.bucket.WriteAll(ctx, key, body, options)
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return err
+	}
+
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	return opts.preconditions().wrapError(key, wrapError(ts.bucket.WriteAll(ctx, key, body, opts.blobWriterOptions())))
 }
 
 func (ts *ImplicitGCPCloudStorage) Delete(
 	ctx context.Context,
 	key string,
 ) error {
-	return ts.client.Bucket(ts.bucketName).Object(key).Delete(ctx)
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *ImplicitGCPCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return err
+	}
+
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(gcsPreconditions); err != nil {
+		return err
+	}
+
+	object := ts.client.Bucket(ts.bucketName).Object(key)
+	if conditions := preconditions.gcsConditions(); conditions != nil {
+		object = object.If(*conditions)
+	}
+
+	return preconditions.wrapError(key, wrapError(object.Delete(ctx)))
+}
+
+// DeleteMany deletes the keys with parallel calls, the JSON API has no batch delete.
+func (ts *ImplicitGCPCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+}
+
+func (ts *ImplicitGCPCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *ImplicitGCPCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
 }
 
 func (ts *ImplicitGCPCloudStorage) Attributes(
 	ctx context.Context,
 	key string,
 ) (*Attributes, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
 	attrs, err := ts.bucket.Attributes(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
-	return &Attributes{
-		CacheControl:       attrs.CacheControl,
-		ContentDisposition: attrs.ContentDisposition,
-		ContentEncoding:    attrs.ContentEncoding,
-		ContentLanguage:    attrs.ContentLanguage,
-		ContentType:        attrs.ContentType,
-		Metadata:           attrs.Metadata,
-		ModTime:            attrs.ModTime,
-		Size:               attrs.Size,
-		MD5:                attrs.MD5,
-	}, nil
+	return newBlobAttributes(attrs), nil
 }
 
 func (ts *ImplicitGCPCloudStorage) Exists(
 	ctx context.Context,
 	key string,
 ) (bool, error) {
-	return ts.bucket.Exists(ctx, key)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return false, err
+	}
+
+	exists, err := ts.bucket.Exists(ctx, key)
+
+	return exists, wrapError(err)
+}
+
+func (ts *ImplicitGCPCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	if err := checkReservedKey("GCS", srcKey); err != nil {
+		return err
+	}
+
+	if err := checkReservedKey("GCS", dstKey); err != nil {
+		return err
+	}
+
+	return wrapError(copyBucketObject(ctx, ts.bucket, ts.bucketName, srcKey, dstKey, opts))
+}
+
+func (ts *ImplicitGCPCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+func (ts *ImplicitGCPCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	attrs, err := updateGCSObjectAttributes(ctx, ts.client, ts.bucketName, key, patch)
+
+	return attrs, wrapError(err)
 }
 
 func getDefaultServiceAccountEmail(
@@ -309,3 +479,32 @@ func getDefaultServiceAccountEmail(
 
 	return email, nil
 }
+
+// StartUpload starts a resumable upload session, the parts are rounded up to a multiple of 256 KiB.
+func (ts *ImplicitGCPCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return startGCSUpload(ctx, ts, ts.httpClient, ts.bucketName, key, opts)
+}
+
+func (ts *ImplicitGCPCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeGCSUpload(ctx, ts, ts.httpClient, ts.bucketName, token)
+}
+
+// AbortStaleUploads cancels the upload sessions started longer than the TTL ago, from their records
+// under the reserved prefix.
+func (ts *ImplicitGCPCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	return abortStaleGCSUploads(ctx, ts.httpClient, ts.bucketName, ttl)
+}
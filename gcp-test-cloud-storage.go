@@ -22,75 +22,63 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/sirupsen/logrus"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/gcsblob"
 	"gocloud.dev/gcp"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 type GCPTestCloudStorage struct {
+	logger          Logger
 	client          *storage.Client
+	httpClient      *http.Client
 	bucket          *blob.Bucket
 	bucketName      string
 	host            string
 	bucketCloseFunc func()
 }
 
-// nolint:funlen
 func newGCPTestCloudStorage(
 	ctx context.Context,
-	gcpCredentialJSON string,
+	emulatorHost string,
 	bucketName string,
+	options *storageOptions,
 ) (*GCPTestCloudStorage, error) {
 	// validation
-	host := os.Getenv("STORAGE_EMULATOR_HOST")
-	if host == "" {
-		// 3-rd party library expect to have the variable STORAGE_EMULATOR_HOST to switch into test mode
-		return nil, fmt.Errorf("can't create GCP bucket for tests, required ENV variable STORAGE_EMULATOR_HOST")
+	if emulatorHost == "" {
+		return nil, fmt.Errorf("can't create GCP bucket for tests, required GCP storage emulator host")
 	}
 
-	// create vanilla GCP client
+	// every request of this storage goes to the emulator, so the STORAGE_EMULATOR_HOST variable isn't needed
+	// and other GCP clients of the process keep talking to GCP
 	// nolint:gosec
-	transCfg := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+	httpClient := &http.Client{
+		Transport: &gcpEmulatorTransport{
+			host: emulatorHost,
+			base: options.transport(&http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+			}),
+		},
 	}
-	httpClient := &http.Client{Transport: transCfg}
 
+	// create vanilla GCP client
 	client, err := storage.NewClient(
-		context.TODO(),
-		option.WithEndpoint(fmt.Sprintf("http://%s/storage/v1/", host)),
+		ctx,
+		option.WithEndpoint(fmt.Sprintf("http://%s/storage/v1/", emulatorHost)),
 		option.WithHTTPClient(httpClient),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
 
-	// create bucket
-	jsonData := []byte(gcpCredentialJSON)
-
-	gcpCreds, err := google.CredentialsFromJSON(ctx, jsonData, storage.ScopeFullControl)
-	if err != nil {
-		return nil, fmt.Errorf("unable to initialize GCP creds: %v", err)
-	}
-
-	bucketHTTPClient, err := gcp.NewHTTPClient(
-		gcp.DefaultTransport(),
-		gcp.CredentialsTokenSource(gcpCreds),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create GCP HTTP Client: %v", err)
-	}
-
+	// the emulator doesn't check credentials
 	bucket, err := gcsblob.OpenBucket(
 		ctx,
-		bucketHTTPClient,
+		&gcp.HTTPClient{Client: *httpClient},
 		bucketName,
 		nil,
 	)
@@ -98,11 +86,15 @@ func newGCPTestCloudStorage(
 		return nil, err
 	}
 
-	logrus.Infof("GCPTestCloudStorage created")
+	disableGCSRetries(client, bucket, options)
+
+	options.logger.Info("GCPTestCloudStorage created", "bucket", bucketName)
 
 	return &GCPTestCloudStorage{
+		logger:     options.logger,
 		client:     client,
-		host:       host,
+		httpClient: httpClient,
+		host:       emulatorHost,
 		bucketName: bucketName,
 		bucket:     bucket,
 		bucketCloseFunc: func() {
@@ -111,6 +103,21 @@ func newGCPTestCloudStorage(
 	}, nil
 }
 
+// gcpEmulatorTransport sends the requests addressed to GCP to the storage emulator.
+type gcpEmulatorTransport struct {
+	host string
+	base http.RoundTripper
+}
+
+func (t *gcpEmulatorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = t.host
+	req.Host = t.host
+
+	return t.base.RoundTrip(req)
+}
+
 func (ts *GCPTestCloudStorage) List(
 	ctx context.Context,
 	prefix string,
@@ -119,7 +126,7 @@ func (ts *GCPTestCloudStorage) List(
 		Prefix: prefix,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return hideReservedObjects(newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next()
 		if err == iterator.Done {
 			return nil, io.EOF
@@ -135,24 +142,33 @@ func (ts *GCPTestCloudStorage) List(
 			Size:    attrs.Size,
 			MD5:     attrs.MD5,
 		}, nil
-	})
+	}))
 }
 
 func (ts *GCPTestCloudStorage) ListWithOptions(
 	ctx context.Context,
 	listOptions *ListOptions,
 ) *ListIterator {
+	// the storage client can't start the listing after a key
+	if listOptions.StartAfter != "" {
+		return filterListIterator(listOptions, newPagedListIterator(ts, listOptions))
+	}
+
 	iter := ts.client.Bucket(ts.bucketName).Objects(ctx, &storage.Query{
 		Prefix:    listOptions.Prefix,
 		Delimiter: listOptions.Delimiter,
 	})
 
-	return newListIterator(func() (*ListObject, error) {
+	return filterListIterator(listOptions, hideReservedObjects(newListIterator(func(ctx context.Context) (*ListObject, error) {
 		attrs, err := iter.Next()
 		if err == iterator.Done {
 			return nil, io.EOF
 		}
 
+		if err != nil {
+			return nil, err
+		}
+
 		name := attrs.Name
 		isDir := false
 		if attrs.Prefix != "" {
@@ -166,21 +182,40 @@ func (ts *GCPTestCloudStorage) ListWithOptions(
 			MD5:     attrs.MD5,
 			IsDir:   isDir,
 		}, nil
-	})
+	})))
+}
+
+func (ts *GCPTestCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	return listGCSPage(ctx, ts.httpClient, ts.bucketName, opts, pageToken, pageSize)
 }
 
 func (ts *GCPTestCloudStorage) Get(
 	ctx context.Context,
 	key string,
 ) ([]byte, error) {
-	return ts.bucket.ReadAll(ctx, key)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	body, err := ts.bucket.ReadAll(ctx, key)
+
+	return body, wrapError(err)
 }
 
 func (ts *GCPTestCloudStorage) GetReader(
 	ctx context.Context,
 	key string,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewReader(ctx, key, nil)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return wrapReader(ts.bucket.NewReader(ctx, key, nil))
 }
 
 func (ts *GCPTestCloudStorage) GetRangeReader(
@@ -189,14 +224,36 @@ func (ts *GCPTestCloudStorage) GetRangeReader(
 	offset,
 	length int64,
 ) (io.ReadCloser, error) {
-	return ts.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return wrapReader(ts.bucket.NewRangeReader(ctx, key, offset, length, nil))
 }
 
 func (ts *GCPTestCloudStorage) GetWriter(
 	ctx context.Context,
 	key string,
 ) (io.WriteCloser, error) {
-	return ts.bucket.NewWriter(ctx, key, nil)
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *GCPTestCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return nil, err
+	}
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		return wrapWriter(ts.bucket.NewWriter(ctx, key, opts.blobWriterOptions()))
+	})
 }
 
 func (ts *GCPTestCloudStorage) CreateBucket(
@@ -204,7 +261,7 @@ func (ts *GCPTestCloudStorage) CreateBucket(
 	bucketPrefix string,
 	expirationTimeDays int64,
 ) error {
-	logrus.Printf("CreateBucket. Name: %s, Prefix: %s, Exp Time: %v", ts.bucketName, bucketPrefix, expirationTimeDays)
+	ts.logger.Info("CreateBucket", "bucket", ts.bucketName, "prefix", bucketPrefix, "expirationTimeDays", expirationTimeDays)
 
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10) //nolint:gomnd
 	defer cancel()
@@ -223,12 +280,16 @@ func (ts *GCPTestCloudStorage) CreateBucket(
 			},
 		},
 	}); err != nil {
-		return fmt.Errorf("failed to create bucket: %v", err)
+		return wrapError(fmt.Errorf("failed to create bucket: %w", err))
 	}
 
 	return nil
 }
 
+func (ts *GCPTestCloudStorage) describe() (provider, bucket string) {
+	return "gcp", ts.bucketName
+}
+
 func (ts *GCPTestCloudStorage) Close() {
 	ts.bucketCloseFunc()
 }
@@ -238,6 +299,10 @@ func (ts *GCPTestCloudStorage) GetSignedURL(
 	key string,
 	opts *SignedURLOption,
 ) (string, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return "", err
+	}
+
 	return fmt.Sprintf("http://%s/%s/%s", ts.host, ts.bucketName, key), nil
 }
 
@@ -247,46 +312,181 @@ func (ts *GCPTestCloudStorage) Write(
 	body []byte,
 	contentType *string,
 ) error {
-	options := &blob.WriterOptions{}
-	if contentType != nil {
-		options.ContentType = *contentType
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *GCPTestCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return err
+	}
+
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return err
 	}
 
-	return ts.bucket.WriteAll(ctx, key, body, options)
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	return opts.preconditions().wrapError(key, wrapError(ts.bucket.WriteAll(ctx, key, body, opts.blobWriterOptions())))
 }
 
 func (ts *GCPTestCloudStorage) Delete(
 	ctx context.Context,
 	key string,
 ) error {
-	return ts.client.Bucket(ts.bucketName).Object(key).Delete(ctx)
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *GCPTestCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return err
+	}
+
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(gcsPreconditions); err != nil {
+		return err
+	}
+
+	object := ts.client.Bucket(ts.bucketName).Object(key)
+	if conditions := preconditions.gcsConditions(); conditions != nil {
+		object = object.If(*conditions)
+	}
+
+	return preconditions.wrapError(key, wrapError(object.Delete(ctx)))
+}
+
+// DeleteMany deletes the keys with parallel calls, the JSON API has no batch delete.
+func (ts *GCPTestCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+}
+
+func (ts *GCPTestCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *GCPTestCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
 }
 
 func (ts *GCPTestCloudStorage) Attributes(
 	ctx context.Context,
 	key string,
 ) (*Attributes, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
 	attrs, err := ts.client.Bucket(ts.bucketName).Object(key).Attrs(ctx)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
-	return &Attributes{
-		CacheControl:       attrs.CacheControl,
-		ContentDisposition: attrs.ContentDisposition,
-		ContentEncoding:    attrs.ContentEncoding,
-		ContentLanguage:    attrs.ContentLanguage,
-		ContentType:        attrs.ContentType,
-		Metadata:           attrs.Metadata,
-		ModTime:            attrs.Updated,
-		Size:               attrs.Size,
-		MD5:                attrs.MD5,
-	}, nil
+	return newGCSAttributes(attrs), nil
 }
 
 func (ts *GCPTestCloudStorage) Exists(
 	ctx context.Context,
 	key string,
 ) (bool, error) {
-	return ts.bucket.Exists(ctx, key)
+	if err := checkReservedKey("GCS", key); err != nil {
+		return false, err
+	}
+
+	exists, err := ts.bucket.Exists(ctx, key)
+
+	return exists, wrapError(err)
+}
+
+func (ts *GCPTestCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	if err := checkReservedKey("GCS", srcKey); err != nil {
+		return err
+	}
+
+	if err := checkReservedKey("GCS", dstKey); err != nil {
+		return err
+	}
+
+	return wrapError(copyBucketObject(ctx, ts.bucket, ts.bucketName, srcKey, dstKey, opts))
+}
+
+func (ts *GCPTestCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+func (ts *GCPTestCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	attrs, err := updateGCSObjectAttributes(ctx, ts.client, ts.bucketName, key, patch)
+
+	return attrs, wrapError(err)
+}
+
+// StartUpload starts a resumable upload session, the parts are rounded up to a multiple of 256 KiB.
+func (ts *GCPTestCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := checkReservedKey("GCS", key); err != nil {
+		return nil, err
+	}
+
+	return startGCSUpload(ctx, ts, ts.httpClient, ts.bucketName, key, opts)
+}
+
+func (ts *GCPTestCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeGCSUpload(ctx, ts, ts.httpClient, ts.bucketName, token)
+}
+
+// AbortStaleUploads cancels the upload sessions started longer than the TTL ago, from their records
+// under the reserved prefix.
+func (ts *GCPTestCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	return abortStaleGCSUploads(ctx, ts.httpClient, ts.bucketName, ttl)
 }
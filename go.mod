@@ -3,14 +3,24 @@ module github.com/AccelByte/common-blob-go
 go 1.13
 
 require (
-	cloud.google.com/go v0.58.0
-	cloud.google.com/go/storage v1.9.0
+	cloud.google.com/go v0.100.2
+	cloud.google.com/go/compute v1.2.0
+	cloud.google.com/go/iam v0.1.1
+	cloud.google.com/go/storage v1.21.0
+	github.com/Azure/azure-pipeline-go v0.2.2
+	github.com/Azure/azure-storage-blob-go v0.9.0
 	github.com/aws/aws-sdk-go v1.40.50
-	github.com/google/uuid v1.1.1
+	github.com/google/uuid v1.1.2
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.6.0
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	gocloud.dev v0.20.0
-	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	google.golang.org/api v0.26.0
-	google.golang.org/genproto v0.0.0-20200608115520-7c474a2e3482
+	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
+	google.golang.org/api v0.69.0
+	google.golang.org/genproto v0.0.0-20220216160803-4663080d8bc8
+	google.golang.org/grpc v1.44.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// hasFilters reports whether the options filter the listed objects.
+func (o *ListOptions) hasFilters() bool {
+	return !o.ModifiedAfter.IsZero() || !o.ModifiedBefore.IsZero() || o.MinSize > 0 || o.MaxSize > 0 ||
+		o.Suffix != "" || o.Glob != "" || o.Regexp != nil || len(o.ExcludePrefixes) > 0
+}
+
+// validateFilters checks the filters and the cap of the options.
+func (o *ListOptions) validateFilters() error {
+	if o.MinSize < 0 || o.MaxSize < 0 || o.MaxResults < 0 {
+		return newError(CodeInvalidArgument, fmt.Errorf("list options can't be negative"))
+	}
+
+	if o.MaxSize > 0 && o.MinSize > o.MaxSize {
+		return newError(CodeInvalidArgument, fmt.Errorf("min size %d exceeds the max size %d", o.MinSize, o.MaxSize))
+	}
+
+	if o.Glob != "" {
+		if _, err := path.Match(o.Glob, ""); err != nil {
+			return newError(CodeInvalidArgument, fmt.Errorf("invalid glob '%s': %w", o.Glob, err))
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether the listed item passes the filters. The "directories" are only filtered
+// by ExcludePrefixes, they have no size nor modification time.
+func (o *ListOptions) matches(item *ListObject) bool {
+	for _, prefix := range o.ExcludePrefixes {
+		if strings.HasPrefix(item.Key, prefix) {
+			return false
+		}
+	}
+
+	if item.IsDir {
+		return true
+	}
+
+	if (!o.ModifiedAfter.IsZero() && !item.ModTime.After(o.ModifiedAfter)) ||
+		(!o.ModifiedBefore.IsZero() && !item.ModTime.Before(o.ModifiedBefore)) {
+		return false
+	}
+
+	if item.Size < o.MinSize || (o.MaxSize > 0 && item.Size > o.MaxSize) {
+		return false
+	}
+
+	if o.Suffix != "" && !strings.HasSuffix(item.Key, o.Suffix) {
+		return false
+	}
+
+	if o.Glob != "" {
+		// the pattern is validated, it can't fail
+		if ok, _ := path.Match(o.Glob, item.Key); !ok {
+			return false
+		}
+	}
+
+	return o.Regexp == nil || o.Regexp.MatchString(item.Key)
+}
+
+// filterListObjects drops the items of a page which don't pass the filters, MaxResults doesn't apply to pages.
+func filterListObjects(listOptions *ListOptions, page []*ListObject) ([]*ListObject, error) {
+	if err := listOptions.validateFilters(); err != nil {
+		return nil, err
+	}
+
+	if !listOptions.hasFilters() {
+		return page, nil
+	}
+
+	filtered := page[:0:0]
+
+	for _, item := range page {
+		if listOptions.matches(item) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered, nil
+}
+
+// filterListIterator skips the items which don't pass the filters and ends the listing after MaxResults items.
+// None of the providers filters server-side, the skipped items are listed all the same.
+func filterListIterator(listOptions *ListOptions, iterator *ListIterator) *ListIterator {
+	if err := listOptions.validateFilters(); err != nil {
+		return wrapListIterator(iterator, func(context.Context) (*ListObject, error) {
+			return nil, err
+		})
+	}
+
+	if !listOptions.hasFilters() && listOptions.MaxResults == 0 {
+		return iterator
+	}
+
+	var listed int
+
+	return wrapListIterator(iterator, func(ctx context.Context) (*ListObject, error) {
+		if listOptions.MaxResults > 0 && listed >= listOptions.MaxResults {
+			return nil, io.EOF
+		}
+
+		for {
+			item, err := iterator.Next(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			if listOptions.matches(item) {
+				listed++
+				return item, nil
+			}
+		}
+	})
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPageFilters(t *testing.T) {
+	keys := []string{"logs/a.tmp", "logs/b.tmp", "logs/c.json", "logs/d.tmp", "logs/e.json", "logs/f.tmp"}
+
+	for provider, storage := range newListTestStorages(t, keys...) {
+		// the filters apply before the paging, the pages skip the filtered items
+		require.Equal(t, [][]string{{"logs/c.json", "logs/e.json"}},
+			listPages(t, storage, &commonblobgo.ListOptions{Prefix: "logs/", Suffix: ".json"}, 2), provider)
+
+		// the items filtered out leave an empty last page
+		require.Equal(t, [][]string{{}},
+			listPages(t, storage, &commonblobgo.ListOptions{Prefix: "logs/", Suffix: ".csv"}, 2), provider)
+
+		// with StartAfter and the token of the previous page
+		require.Equal(t, [][]string{{"logs/d.tmp"}, {"logs/f.tmp"}},
+			listPages(t, storage, &commonblobgo.ListOptions{Prefix: "logs/", Suffix: ".tmp", StartAfter: "logs/b.tmp"}, 1), provider)
+
+		// MaxResults only caps the iterators
+		require.Equal(t, [][]string{{"logs/c.json", "logs/e.json"}},
+			listPages(t, storage, &commonblobgo.ListOptions{Prefix: "logs/", Suffix: ".json", MaxResults: 1}, 10), provider)
+
+		_, _, err := storage.ListPage(context.Background(), &commonblobgo.ListOptions{Prefix: "logs/", MinSize: 10, MaxSize: 1}, "", 2)
+		require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument), "%s, got: %v", provider, err)
+	}
+}
+
+func TestListIteratorFilters(t *testing.T) {
+	keys := []string{"logs/a.tmp", "logs/b.tmp", "logs/c.json", "logs/d.tmp", "logs/e.json", "logs/f.tmp"}
+
+	for provider, storage := range newListTestStorages(t, keys...) {
+		var listed []string
+
+		err := storage.ListWithOptions(context.Background(), &commonblobgo.ListOptions{
+			Prefix:          "logs/",
+			Suffix:          ".tmp",
+			ExcludePrefixes: []string{"logs/a", "logs/b"},
+			MaxResults:      1,
+		}).ForEach(context.Background(), func(item *commonblobgo.ListObject) error {
+			listed = append(listed, item.Key)
+			return nil
+		})
+		require.NoError(t, err, provider)
+		require.Equal(t, []string{"logs/d.tmp"}, listed, provider)
+
+		// every item filtered out ends the listing
+		_, err = storage.ListWithOptions(context.Background(), &commonblobgo.ListOptions{
+			Prefix: "logs/",
+			Glob:   "logs/*.csv",
+		}).Next(context.Background())
+		require.Equal(t, io.EOF, err, provider)
+	}
+}
@@ -0,0 +1,58 @@
+//go:build go1.23
+// +build go1.23
+
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"io"
+	"iter"
+)
+
+// All returns the listing as a Go iterator for range loops, available with Go 1.23 and newer:
+//
+//	for item, err := range storage.List(ctx, prefix).All(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//	}
+//
+// A failed listing yields its error once and ends, the loop may stop at any time.
+// The iterator is closed when the loop ends.
+func (i *ListIterator) All(ctx context.Context) iter.Seq2[*ListObject, error] {
+	return func(yield func(*ListObject, error) bool) {
+		defer i.Close()
+
+		for {
+			item, err := i.Next(ctx)
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//go:build go1.23
+// +build go1.23
+
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListIteratorAll(t *testing.T) {
+	storage := newTestListStorage(t, 3)
+	ctx := context.Background()
+
+	var keys []string
+
+	for item, err := range storage.List(ctx, "").All(ctx) {
+		require.NoError(t, err)
+
+		keys = append(keys, item.Key)
+
+		if len(keys) == 2 {
+			break
+		}
+	}
+
+	require.Equal(t, []string{"key-00", "key-01"}, keys)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	var errs []error
+
+	for item, err := range storage.List(ctx, "").All(cancelled) {
+		require.Nil(t, item)
+
+		errs = append(errs, err)
+	}
+
+	require.Len(t, errs, 1)
+	require.True(t, errors.Is(errs[0], context.Canceled))
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"io"
+)
+
+// ForEach calls fn with every listed object until the listing ends, fails or fn returns an error.
+// It returns the error of the listing or of fn, nil when every object was listed. The iterator is closed on return.
+func (i *ListIterator) ForEach(ctx context.Context, fn func(item *ListObject) error) error {
+	defer i.Close()
+
+	for {
+		item, err := i.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
+
+// Collect returns up to limit listed objects, all of them if limit isn't positive.
+// The objects listed before a failure are returned with the error. The iterator is closed on return,
+// Next still lists the objects after the limit, but they aren't observed anymore.
+func (i *ListIterator) Collect(ctx context.Context, limit int) ([]*ListObject, error) {
+	defer i.Close()
+
+	var items []*ListObject
+
+	for limit <= 0 || len(items) < limit {
+		item, err := i.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return items, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Stream lists in the background and sends the objects on the returned channel, which is closed
+// when the listing ends, fails or ctx is done. The error channel then receives the error of the listing,
+// ctx.Err() when it was cancelled or nil, and is closed.
+// The caller which stops receiving the objects has to cancel ctx, so the background listing exits.
+func (i *ListIterator) Stream(ctx context.Context) (<-chan *ListObject, <-chan error) {
+	items := make(chan *ListObject)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(items)
+
+		errs <- i.ForEach(ctx, func(item *ListObject) error {
+			select {
+			case items <- item:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return items, errs
+}
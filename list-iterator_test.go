@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestListStorage(t *testing.T, count int) *MemoryCloudStorage {
+	storage := NewMemoryCloudStorage("bucket")
+
+	for i := 0; i < count; i++ {
+		require.NoError(t, storage.Write(context.Background(), fmt.Sprintf("key-%02d", i), []byte("body"), nil))
+	}
+
+	return storage
+}
+
+func TestListIteratorForEach(t *testing.T) {
+	storage := newTestListStorage(t, 5)
+	ctx := context.Background()
+
+	var keys []string
+
+	err := storage.List(ctx, "").ForEach(ctx, func(item *ListObject) error {
+		keys = append(keys, item.Key)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"key-00", "key-01", "key-02", "key-03", "key-04"}, keys)
+
+	stop := errors.New("stop")
+	calls := 0
+
+	err = storage.List(ctx, "").ForEach(ctx, func(item *ListObject) error {
+		calls++
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestListIteratorCollect(t *testing.T) {
+	storage := newTestListStorage(t, 5)
+	ctx := context.Background()
+
+	items, err := storage.List(ctx, "").Collect(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, "key-01", items[1].Key)
+
+	items, err = storage.List(ctx, "").Collect(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, items, 5)
+}
+
+func TestListIteratorNextHonorsContext(t *testing.T) {
+	storage := newTestListStorage(t, 2)
+	iterator := storage.List(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := iterator.Next(ctx)
+	require.True(t, errors.Is(err, context.Canceled), "got: %v", err)
+
+	// the iterator keeps its position
+	item, err := iterator.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "key-00", item.Key)
+}
+
+func TestListIteratorStream(t *testing.T) {
+	storage := newTestListStorage(t, 3)
+
+	items, errs := storage.List(context.Background(), "").Stream(context.Background())
+
+	var keys []string
+	for item := range items {
+		keys = append(keys, item.Key)
+	}
+
+	require.Equal(t, []string{"key-00", "key-01", "key-02"}, keys)
+	require.NoError(t, <-errs)
+
+	// a cancelled stream stops listing and reports the cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+
+	items, errs = storage.List(ctx, "").Stream(ctx)
+
+	item := <-items
+	require.Equal(t, "key-00", item.Key)
+
+	cancel()
+
+	for range items {
+	}
+
+	require.True(t, errors.Is(<-errs, context.Canceled))
+
+	_, open := <-errs
+	require.False(t, open)
+}
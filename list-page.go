@@ -0,0 +1,369 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// maxListPageSize is the default and the largest page size of ListPage, the limit of S3 and GCS
+	maxListPageSize = 1000
+	// gcsAPIURL is the JSON API endpoint of GCS, the test storage sends it to the emulator
+	gcsAPIURL = "https://storage.googleapis.com/storage/v1"
+)
+
+// listPageSize returns the page size of ListPage, the largest one if not positive or above it.
+func listPageSize(pageSize int) int {
+	if pageSize <= 0 || pageSize > maxListPageSize {
+		return maxListPageSize
+	}
+
+	return pageSize
+}
+
+// listOptionsOrDefault returns the options of ListPage, which takes nil to list the whole bucket.
+func listOptionsOrDefault(opts *ListOptions) *ListOptions {
+	if opts == nil {
+		return &ListOptions{}
+	}
+
+	return opts
+}
+
+// isListedAfter reports whether the item comes after StartAfter. A "directory" before StartAfter is listed
+// when it holds StartAfter, the keys after StartAfter in it are listed.
+func isListedAfter(key string, isDir bool, startAfter string) bool {
+	return startAfter == "" || key > startAfter || (isDir && strings.HasPrefix(startAfter, key))
+}
+
+// newPagedListIterator iterates over the pages of ListPage, for the listings only ListPage supports.
+// A failed page fetch keeps the position, so Next can be called again.
+func newPagedListIterator(storage CloudStorage, listOptions *ListOptions) *ListIterator {
+	var (
+		page  []*ListObject
+		token string
+		done  bool
+	)
+
+	return newListIterator(func(ctx context.Context) (*ListObject, error) {
+		for len(page) == 0 {
+			if done {
+				return nil, io.EOF
+			}
+
+			items, nextToken, err := storage.ListPage(ctx, listOptions, token, maxListPageSize)
+			if err != nil {
+				return nil, err
+			}
+
+			page = items
+			token = nextToken
+			done = nextToken == ""
+		}
+
+		item := page[0]
+		page = page[1:]
+
+		return item, nil
+	})
+}
+
+// listObjectsPage returns the page of the whole sorted listing of the file and memory storages,
+// the token is the last key of the previous page.
+func listObjectsPage(
+	listOptions *ListOptions,
+	objects []*ListObject,
+	token string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	objects, err := filterListObjects(listOptions, objects)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var after string
+
+	if token != "" {
+		key, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, "", newError(CodeInvalidArgument, fmt.Errorf("invalid page token: %v", err))
+		}
+
+		after = string(key)
+
+		// the token is the key of an item of the same listing
+		if !strings.HasPrefix(after, listOptions.Prefix) || !isListedAfter(after, true, listOptions.StartAfter) {
+			return nil, "", newError(CodeInvalidArgument, fmt.Errorf("page token '%s' of another listing", token))
+		}
+	}
+
+	start := sort.Search(len(objects), func(i int) bool {
+		return objects[i].Key > after
+	})
+
+	objects = objects[start:]
+
+	if len(objects) <= listPageSize(pageSize) {
+		return objects, "", nil
+	}
+
+	objects = objects[:listPageSize(pageSize)]
+
+	return objects, base64.RawURLEncoding.EncodeToString([]byte(objects[len(objects)-1].Key)), nil
+}
+
+// s3StartAfter sets the StartAfter of the S3 listings of gocloud.dev/blob.
+func s3StartAfter(startAfter string) func(asFunc func(interface{}) bool) error {
+	if startAfter == "" {
+		return nil
+	}
+
+	return func(asFunc func(interface{}) bool) error {
+		var input *s3.ListObjectsV2Input
+		if !asFunc(&input) {
+			return fmt.Errorf("unable to set StartAfter of the S3 listing")
+		}
+
+		input.StartAfter = aws.String(startAfter)
+
+		return nil
+	}
+}
+
+// listS3Page lists a page with ListObjectsV2, the token is the S3 continuation token.
+func listS3Page(
+	ctx context.Context,
+	client *s3.S3,
+	bucketName string,
+	opts *ListOptions,
+	token string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	opts = listOptionsOrDefault(opts)
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int64(int64(listPageSize(pageSize))),
+	}
+
+	if opts.Prefix != "" {
+		input.Prefix = aws.String(opts.Prefix)
+	}
+
+	if opts.Delimiter != "" {
+		input.Delimiter = aws.String(opts.Delimiter)
+	}
+
+	if opts.StartAfter != "" {
+		input.StartAfter = aws.String(opts.StartAfter)
+	}
+
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+
+	resp, err := client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, "", wrapError(err)
+	}
+
+	page := make([]*ListObject, 0, len(resp.Contents)+len(resp.CommonPrefixes))
+
+	for _, object := range resp.Contents {
+		page = append(page, &ListObject{
+			Key:     aws.StringValue(object.Key),
+			ModTime: aws.TimeValue(object.LastModified),
+			Size:    aws.Int64Value(object.Size),
+			MD5:     s3ETagMD5(aws.StringValue(object.ETag)),
+		})
+	}
+
+	for _, prefix := range resp.CommonPrefixes {
+		page = append(page, &ListObject{
+			Key:   aws.StringValue(prefix.Prefix),
+			IsDir: true,
+		})
+	}
+
+	// S3 returns blobs and "directories" separately
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Key < page[j].Key
+	})
+
+	page, err = filterListObjects(opts, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !aws.BoolValue(resp.IsTruncated) {
+		return page, "", nil
+	}
+
+	return page, aws.StringValue(resp.NextContinuationToken), nil
+}
+
+// s3ETagMD5 returns the MD5 hash an ETag holds, the ETags of multipart uploads don't hold one.
+func s3ETagMD5(eTag string) []byte {
+	md5, err := hex.DecodeString(strings.Trim(eTag, `"`))
+	if err != nil || len(md5) != 16 {
+		return nil
+	}
+
+	return md5
+}
+
+// gcsObjectsPage is a page of the objects.list call of the GCS JSON API.
+type gcsObjectsPage struct {
+	Items []struct {
+		Name    string    `json:"name"`
+		Size    int64     `json:"size,string"`
+		Updated time.Time `json:"updated"`
+		MD5Hash []byte    `json:"md5Hash"`
+	} `json:"items"`
+	Prefixes      []string `json:"prefixes"`
+	NextPageToken string   `json:"nextPageToken"`
+}
+
+// listGCSPage lists a page with the JSON API, the storage client doesn't support StartAfter.
+// The token is the GCS page token. The objects under the reserved prefix are dropped.
+func listGCSPage(
+	ctx context.Context,
+	httpClient *http.Client,
+	bucketName string,
+	opts *ListOptions,
+	token string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	page, nextToken, err := listGCSObjects(ctx, httpClient, bucketName, opts, token, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	listed := page[:0]
+
+	for _, item := range page {
+		if !isReservedKey(item.Key) {
+			listed = append(listed, item)
+		}
+	}
+
+	return listed, nextToken, nil
+}
+
+// listGCSObjects lists a page with the JSON API, the reserved prefix included.
+func listGCSObjects(
+	ctx context.Context,
+	httpClient *http.Client,
+	bucketName string,
+	opts *ListOptions,
+	token string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	opts = listOptionsOrDefault(opts)
+
+	query := url.Values{}
+	query.Set("maxResults", strconv.Itoa(listPageSize(pageSize)))
+	query.Set("fields", "items(name,size,updated,md5Hash),prefixes,nextPageToken")
+
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+
+	if opts.Delimiter != "" {
+		query.Set("delimiter", opts.Delimiter)
+	}
+
+	// startOffset is inclusive, StartAfter itself is dropped below
+	if opts.StartAfter != "" {
+		query.Set("startOffset", opts.StartAfter)
+	}
+
+	if token != "" {
+		query.Set("pageToken", token)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		gcsAPIURL+"/b/"+url.PathEscape(bucketName)+"/o?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", wrapError(err)
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, "", wrapError(err)
+	}
+
+	var objects gcsObjectsPage
+	if err := json.NewDecoder(resp.Body).Decode(&objects); err != nil {
+		return nil, "", wrapError(err)
+	}
+
+	page := make([]*ListObject, 0, len(objects.Items)+len(objects.Prefixes))
+
+	for _, item := range objects.Items {
+		if item.Name == opts.StartAfter {
+			continue
+		}
+
+		page = append(page, &ListObject{
+			Key:     item.Name,
+			ModTime: item.Updated,
+			Size:    item.Size,
+			MD5:     item.MD5Hash,
+		})
+	}
+
+	for _, prefix := range objects.Prefixes {
+		page = append(page, &ListObject{
+			Key:   prefix,
+			IsDir: true,
+		})
+	}
+
+	// GCS returns blobs and "directories" separately
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Key < page[j].Key
+	})
+
+	page, err = filterListObjects(opts, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page, objects.NextPageToken, nil
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPageS3(t *testing.T) {
+	var queries []url.Values
+
+	awsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+
+		if r.URL.Query().Get("continuation-token") == "" {
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>bucket</Name>`+
+				`<Contents><Key>logs/b</Key><Size>3</Size><ETag>"0cc175b9c0f1b6a831c399e269772661"</ETag></Contents>`+
+				`<CommonPrefixes><Prefix>logs/a/</Prefix></CommonPrefixes>`+
+				`<IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`)
+
+			return
+		}
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>bucket</Name>`+
+			`<Contents><Key>logs/c</Key><Size>1</Size><ETag>"d41d8cd98f00b204e9800998ecf8427e-2"</ETag></Contents>`+
+			`<IsTruncated>false</IsTruncated></ListBucketResult>`)
+	}))
+	defer awsServer.Close()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), false, "aws", "bucket",
+		commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        awsServer.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     "key",
+			AWSS3SecretAccessKey: "secret",
+		})
+	require.NoError(t, err)
+
+	opts := &commonblobgo.ListOptions{Prefix: "logs/", Delimiter: "/", StartAfter: "logs/0"}
+
+	page, token, err := storage.ListPage(context.Background(), opts, "", 2)
+	require.NoError(t, err)
+	require.Equal(t, "next", token)
+	require.Len(t, page, 2)
+	require.Equal(t, "logs/a/", page[0].Key)
+	require.True(t, page[0].IsDir)
+	require.Equal(t, "logs/b", page[1].Key)
+	require.Equal(t, int64(3), page[1].Size)
+	require.Equal(t, "0cc175b9c0f1b6a831c399e269772661", fmt.Sprintf("%x", page[1].MD5))
+
+	page, token, err = storage.ListPage(context.Background(), opts, token, 2)
+	require.NoError(t, err)
+	require.Empty(t, token)
+	require.Len(t, page, 1)
+	// the ETag of a multipart upload isn't an MD5 hash
+	require.Nil(t, page[0].MD5)
+
+	require.Equal(t, "2", queries[0].Get("max-keys"))
+	require.Equal(t, "logs/", queries[0].Get("prefix"))
+	require.Equal(t, "/", queries[0].Get("delimiter"))
+	require.Equal(t, "logs/0", queries[0].Get("start-after"))
+	require.Equal(t, "next", queries[1].Get("continuation-token"))
+}
+
+func TestListPageGCS(t *testing.T) {
+	var queries []url.Values
+
+	gcsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/storage/v1/b/bucket/o", r.URL.Path)
+
+		queries = append(queries, r.URL.Query())
+
+		page := map[string]interface{}{
+			"items": []map[string]interface{}{
+				// startOffset is inclusive
+				{"name": "logs/b", "size": "3"},
+				{"name": "logs/c", "size": "1", "updated": "2020-01-02T03:04:05Z", "md5Hash": "DMF1ucDxtqgxw5niaXcmYQ=="},
+			},
+			"prefixes": []string{"logs/bb/"},
+		}
+
+		if r.URL.Query().Get("pageToken") == "" {
+			page["nextPageToken"] = "next"
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(page))
+	}))
+	defer gcsServer.Close()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), true, "gcp", "bucket",
+		commonblobgo.CloudStorageOption{
+			GCPStorageEmulatorHost: strings.TrimPrefix(gcsServer.URL, "http://"),
+		})
+	require.NoError(t, err)
+
+	opts := &commonblobgo.ListOptions{Prefix: "logs/", Delimiter: "/", StartAfter: "logs/b"}
+
+	page, token, err := storage.ListPage(context.Background(), opts, "", 10)
+	require.NoError(t, err)
+	require.Equal(t, "next", token)
+	require.Len(t, page, 2)
+	require.Equal(t, "logs/bb/", page[0].Key)
+	require.True(t, page[0].IsDir)
+	require.Equal(t, "logs/c", page[1].Key)
+	require.Equal(t, int64(1), page[1].Size)
+	require.Equal(t, "0cc175b9c0f1b6a831c399e269772661", fmt.Sprintf("%x", page[1].MD5))
+	require.Equal(t, 2020, page[1].ModTime.Year())
+
+	require.Equal(t, "10", queries[0].Get("maxResults"))
+	require.Equal(t, "logs/", queries[0].Get("prefix"))
+	require.Equal(t, "/", queries[0].Get("delimiter"))
+	require.Equal(t, "logs/b", queries[0].Get("startOffset"))
+
+	// ListWithOptions pages through ListPage when StartAfter is set
+	var keys []string
+
+	iterator := storage.ListWithOptions(context.Background(), opts)
+
+	for {
+		item, err := iterator.Next(context.Background())
+		if err != nil {
+			break
+		}
+
+		keys = append(keys, item.Key)
+	}
+
+	require.Equal(t, []string{"logs/bb/", "logs/c", "logs/bb/", "logs/c"}, keys)
+	require.Equal(t, "next", queries[2].Get("pageToken"))
+}
+
+// newListTestStorages returns a memory and a file storage holding the keys.
+func newListTestStorages(t *testing.T, keys ...string) map[string]commonblobgo.CloudStorage {
+	rootDir, err := ioutil.TempDir("", "common-blob-go")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(rootDir)
+	})
+
+	storages := map[string]commonblobgo.CloudStorage{}
+
+	for _, provider := range []string{"memory", "file"} {
+		storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), true, provider, "bucket",
+			commonblobgo.CloudStorageOption{
+				FileStorageRootDir:    rootDir,
+				FileStorageSigningKey: "signing-key",
+				FileStorageBaseURL:    "http://localhost:8080/blobs",
+			})
+		require.NoError(t, err)
+
+		for _, key := range keys {
+			require.NoError(t, storage.Write(context.Background(), key, []byte("body"), nil))
+		}
+
+		storages[provider] = storage
+	}
+
+	return storages
+}
+
+// listPages lists all the pages and returns their keys.
+func listPages(t *testing.T, storage commonblobgo.CloudStorage, opts *commonblobgo.ListOptions, pageSize int) [][]string {
+	var (
+		pages [][]string
+		token string
+	)
+
+	for {
+		page, nextToken, err := storage.ListPage(context.Background(), opts, token, pageSize)
+		require.NoError(t, err)
+
+		keys := []string{}
+		for _, item := range page {
+			keys = append(keys, item.Key)
+		}
+
+		pages = append(pages, keys)
+
+		if nextToken == "" {
+			return pages
+		}
+
+		token = nextToken
+	}
+}
+
+func TestListPageStartAfterWithToken(t *testing.T) {
+	for provider, storage := range newListTestStorages(t, "logs/a", "logs/b", "logs/c/d", "logs/c/e", "logs/f", "other") {
+		// the token goes on after the last item of the page, StartAfter still applies
+		require.Equal(t, [][]string{{"logs/c/d", "logs/c/e"}, {"logs/f"}},
+			listPages(t, storage, &commonblobgo.ListOptions{Prefix: "logs/", StartAfter: "logs/b"}, 2), provider)
+
+		// the "directory" holding StartAfter is listed
+		require.Equal(t, [][]string{{"logs/c/"}, {"logs/f"}},
+			listPages(t, storage, &commonblobgo.ListOptions{Prefix: "logs/", Delimiter: "/", StartAfter: "logs/c/d"}, 1), provider)
+	}
+}
+
+func TestListPageInvalidToken(t *testing.T) {
+	for provider, storage := range newListTestStorages(t, "logs/a", "logs/b", "users/a", "users/b") {
+		ctx := context.Background()
+
+		_, token, err := storage.ListPage(ctx, &commonblobgo.ListOptions{Prefix: "logs/"}, "", 1)
+		require.NoError(t, err, provider)
+		require.NotEmpty(t, token, provider)
+
+		for _, tc := range []struct {
+			name  string
+			opts  *commonblobgo.ListOptions
+			token string
+		}{
+			{name: "malformed", opts: &commonblobgo.ListOptions{Prefix: "logs/"}, token: "not a token"},
+			// an S3 continuation token
+			{name: "foreign", opts: &commonblobgo.ListOptions{Prefix: "logs/"}, token: "1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM="},
+			{name: "other prefix", opts: &commonblobgo.ListOptions{Prefix: "users/"}, token: token},
+			{name: "before StartAfter", opts: &commonblobgo.ListOptions{Prefix: "logs/", StartAfter: "logs/b"}, token: token},
+		} {
+			_, _, err := storage.ListPage(ctx, tc.opts, tc.token, 1)
+			require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument), "%s %s, got: %v", provider, tc.name, err)
+		}
+
+		page, nextToken, err := storage.ListPage(ctx, &commonblobgo.ListOptions{Prefix: "logs/"}, token, 1)
+		require.NoError(t, err, provider)
+		require.Empty(t, nextToken, provider)
+		require.Len(t, page, 1, provider)
+		require.Equal(t, "logs/b", page[0].Key, provider)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger receives the log events of the library. The arguments are alternating keys and values,
+// so *slog.Logger can be used as is.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NopLogger discards all the log events, it's the default logger.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// NewLogrusLogger adapts a logrus logger, e.g. logrus.StandardLogger().
+func NewLogrusLogger(logger logrus.FieldLogger) Logger {
+	return &logrusLogger{
+		logger: logger,
+	}
+}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+func (l *logrusLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Debug(msg)
+}
+
+func (l *logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Info(msg)
+}
+
+func (l *logrusLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Warn(msg)
+}
+
+func (l *logrusLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.entry(keysAndValues).Error(msg)
+}
+
+func (l *logrusLogger) entry(keysAndValues []interface{}) *logrus.Entry {
+	fields := make(logrus.Fields, len(keysAndValues)/2)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+
+		if i+1 < len(keysAndValues) {
+			fields[key] = keysAndValues[i+1]
+		} else {
+			// same as slog, a key without a value is kept
+			fields["!BADKEY"] = key
+		}
+	}
+
+	return l.logger.WithFields(fields)
+}
+
+// loggingObserver logs every storage operation, failures at the error level and the rest at the debug level.
+// Missing objects are expected by many callers, so they are logged at the debug level too.
+type loggingObserver struct {
+	logger Logger
+}
+
+func (o *loggingObserver) started(ctx context.Context, obs *observation) context.Context {
+	return ctx
+}
+
+func (o *loggingObserver) finished(ctx context.Context, obs *observation) {
+	keysAndValues := []interface{}{
+		"method", obs.method,
+		"provider", obs.provider,
+		"bucket", obs.bucket,
+		"key", obs.key,
+		"duration", time.Since(obs.start),
+	}
+
+	if obs.destination != "" {
+		keysAndValues = append(keysAndValues, "destination", obs.destination)
+	}
+
+	if obs.method == "GetRangeReader" {
+		keysAndValues = append(keysAndValues, "offset", obs.offset, "length", obs.length)
+	}
+
+	if obs.bytes >= 0 {
+		keysAndValues = append(keysAndValues, "bytes", obs.bytes)
+	}
+
+	if obs.objects >= 0 {
+		keysAndValues = append(keysAndValues, "objects", obs.objects)
+	}
+
+	switch {
+	case obs.err == nil:
+		o.logger.Debug("storage operation", keysAndValues...)
+	case Code(obs.err) == CodeNotFound:
+		o.logger.Debug("storage operation", append(keysAndValues, "error", obs.err)...)
+	default:
+		o.logger.Error("storage operation failed", append(keysAndValues, "error", obs.err)...)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+	logger := &recordingLogger{}
+
+	storage, err := commonblobgo.OpenCloudStorage(ctx, "mem://bucket", commonblobgo.WithLogger(logger))
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Write(ctx, "user/1.json", []byte("{}"), nil))
+
+	_, err = storage.Get(ctx, "user/2.json")
+	require.Error(t, err)
+
+	reader, err := storage.GetRangeReader(ctx, "user/1.json", 1, 1)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	list := storage.List(ctx, "user/")
+	for {
+		_, err = list.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+	}
+
+	// the durations differ on every run
+	duration := regexp.MustCompile(`duration \S+`)
+	messages := make([]string, 0, len(logger.messages))
+
+	for _, message := range logger.messages {
+		messages = append(messages, duration.ReplaceAllString(message, "duration D"))
+	}
+
+	require.Equal(t, []string{
+		"debug storage operation method Write provider memory bucket bucket key user/1.json duration D bytes 2",
+		"debug storage operation method Get provider memory bucket bucket key user/2.json duration D bytes 0 error open user/2.json: file does not exist",
+		"debug storage operation method GetRangeReader provider memory bucket bucket key user/1.json duration D offset 1 length 1 bytes 1",
+		"debug storage operation method List provider memory bucket bucket key user/ duration D objects 1",
+	}, messages)
+
+	// the default logger keeps the storage type as is
+	storage, err = commonblobgo.OpenCloudStorage(ctx, "mem://bucket")
+	require.NoError(t, err)
+	require.IsType(t, &commonblobgo.MemoryCloudStorage{}, storage)
+}
@@ -0,0 +1,690 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // nolint:gosec
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryCloudStorage keeps blobs in memory. It is meant for unit tests and is safe for concurrent use.
+type MemoryCloudStorage struct {
+	bucketName string
+
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+	// uploads holds the committed content of the resumable uploads by their IDs
+	uploads map[string]*memoryUpload
+	// generation is the generation of the last written object
+	generation int64
+}
+
+type memoryObject struct {
+	body  []byte
+	attrs Attributes
+}
+
+type memoryUpload struct {
+	body    []byte
+	updated time.Time
+}
+
+// NewMemoryCloudStorage creates an empty in-memory storage.
+func NewMemoryCloudStorage(bucketName string) *MemoryCloudStorage {
+	return &MemoryCloudStorage{
+		bucketName: bucketName,
+		objects:    make(map[string]*memoryObject),
+		uploads:    make(map[string]*memoryUpload),
+	}
+}
+
+func (ts *MemoryCloudStorage) List(
+	ctx context.Context,
+	prefix string,
+) *ListIterator {
+	return ts.ListWithOptions(ctx, &ListOptions{
+		Prefix: prefix,
+	})
+}
+
+func (ts *MemoryCloudStorage) ListWithOptions(
+	ctx context.Context,
+	listOptions *ListOptions,
+) *ListIterator {
+	objects := ts.listObjects(listOptions)
+
+	return filterListIterator(listOptions, newListIterator(func(ctx context.Context) (*ListObject, error) {
+		if len(objects) == 0 {
+			return nil, io.EOF
+		}
+
+		item := objects[0]
+		objects = objects[1:]
+
+		return item, nil
+	}))
+}
+
+// ListPage lists the whole prefix and returns the page after the last key of the previous one.
+func (ts *MemoryCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	opts = listOptionsOrDefault(opts)
+
+	return listObjectsPage(opts, ts.listObjects(opts), pageToken, pageSize)
+}
+
+// listObjects collects the matching objects sorted by key.
+func (ts *MemoryCloudStorage) listObjects(listOptions *ListOptions) []*ListObject {
+	ts.mu.RLock()
+
+	objects := make([]*ListObject, 0, len(ts.objects))
+
+	for key, object := range ts.objects {
+		if !strings.HasPrefix(key, listOptions.Prefix) || !isListedAfter(key, false, listOptions.StartAfter) {
+			continue
+		}
+
+		objects = append(objects, &ListObject{
+			Key:     key,
+			ModTime: object.attrs.ModTime,
+			Size:    object.attrs.Size,
+			MD5:     object.attrs.MD5,
+		})
+	}
+
+	ts.mu.RUnlock()
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return groupListObjects(objects, listOptions.Prefix, listOptions.Delimiter)
+}
+
+func (ts *MemoryCloudStorage) Get(
+	ctx context.Context,
+	key string,
+) ([]byte, error) {
+	object, err := ts.object(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), object.body...), nil
+}
+
+func (ts *MemoryCloudStorage) GetReader(
+	ctx context.Context,
+	key string,
+) (io.ReadCloser, error) {
+	return ts.GetRangeReader(ctx, key, 0, -1)
+}
+
+func (ts *MemoryCloudStorage) GetRangeReader(
+	ctx context.Context,
+	key string,
+	offset,
+	length int64,
+) (io.ReadCloser, error) {
+	object, err := ts.object(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("invalid range offset: %d", offset))
+	}
+
+	// the body is never modified in place, a new write replaces the whole object
+	body := object.body
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+
+	body = body[offset:]
+	if length >= 0 && length < int64(len(body)) {
+		body = body[:length]
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (ts *MemoryCloudStorage) GetWriter(
+	ctx context.Context,
+	key string,
+) (io.WriteCloser, error) {
+	return ts.GetWriterWithOptions(ctx, key, nil)
+}
+
+func (ts *MemoryCloudStorage) GetWriterWithOptions(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (io.WriteCloser, error) {
+	if err := opts.preconditions().validateWrite(memoryPreconditions); err != nil {
+		return nil, err
+	}
+
+	return newOptionsWriter(ctx, key, opts, func(ctx context.Context) (io.WriteCloser, error) {
+		return &memoryWriter{
+			ctx:           ctx,
+			storage:       ts,
+			key:           key,
+			attrs:         opts.attributes(),
+			preconditions: opts.preconditions(),
+		}, nil
+	})
+}
+
+func (ts *MemoryCloudStorage) CreateBucket(
+	ctx context.Context,
+	bucketPrefix string,
+	expirationTimeDays int64,
+) error {
+	// the bucket always exists, expiration isn't supported
+	return nil
+}
+
+func (ts *MemoryCloudStorage) describe() (provider, bucket string) {
+	return "memory", ts.bucketName
+}
+
+func (ts *MemoryCloudStorage) Close() {
+	// nothing to release
+}
+
+// GetSignedURL returns a deterministic fake URL, it can't be used to access the blob.
+func (ts *MemoryCloudStorage) GetSignedURL(
+	ctx context.Context,
+	key string,
+	opts *SignedURLOption,
+) (string, error) {
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	query := url.Values{}
+	query.Set("method", method)
+	query.Set("expiry", strconv.FormatInt(int64(opts.Expiry/time.Second), 10))
+
+	if opts.ContentType != "" {
+		query.Set("contentType", opts.ContentType)
+	}
+
+	signedURL := url.URL{
+		Scheme:   "memory",
+		Host:     ts.bucketName,
+		Path:     "/" + key,
+		RawQuery: query.Encode(),
+	}
+
+	return signedURL.String(), nil
+}
+
+func (ts *MemoryCloudStorage) Write(
+	ctx context.Context,
+	key string,
+	body []byte,
+	contentType *string,
+) error {
+	return ts.WriteWithOptions(ctx, key, body, newWriteOptions(contentType))
+}
+
+func (ts *MemoryCloudStorage) WriteWithOptions(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *WriteOptions,
+) error {
+	if opts.upload() != nil {
+		return writeByUpload(ctx, ts, key, body, opts)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := opts.preconditions().validateWrite(memoryPreconditions); err != nil {
+		return err
+	}
+
+	if err := opts.verifyContentMD5(body); err != nil {
+		return err
+	}
+
+	return ts.put(key, append([]byte(nil), body...), opts.attributes(), opts.preconditions())
+}
+
+func (ts *MemoryCloudStorage) Delete(
+	ctx context.Context,
+	key string,
+) error {
+	return ts.DeleteWithOptions(ctx, key, nil)
+}
+
+func (ts *MemoryCloudStorage) DeleteWithOptions(
+	ctx context.Context,
+	key string,
+	opts *DeleteOptions,
+) error {
+	preconditions := opts.preconditions()
+	if err := preconditions.validateDelete(memoryPreconditions); err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	object, ok := ts.objects[key]
+	if !ok {
+		return memoryNotFoundError(key)
+	}
+
+	if !preconditions.matches(&object.attrs) {
+		return preconditionFailedError(key)
+	}
+
+	delete(ts.objects, key)
+
+	return nil
+}
+
+func (ts *MemoryCloudStorage) DeleteMany(
+	ctx context.Context,
+	keys []string,
+) ([]DeleteResult, error) {
+	return deleteConcurrently(ctx, keys, deleteManyConcurrency, ts.Delete)
+}
+
+func (ts *MemoryCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, ts, prefix, opts)
+}
+
+func (ts *MemoryCloudStorage) Download(
+	ctx context.Context,
+	key string,
+	w io.WriterAt,
+	opts *DownloadOptions,
+) (int64, error) {
+	return download(ctx, ts, key, w, opts)
+}
+
+func (ts *MemoryCloudStorage) Attributes(
+	ctx context.Context,
+	key string,
+) (*Attributes, error) {
+	object, err := ts.object(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.attributes(), nil
+}
+
+func (ts *MemoryCloudStorage) Exists(
+	ctx context.Context,
+	key string,
+) (bool, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	_, ok := ts.objects[key]
+
+	return ok, nil
+}
+
+func (ts *MemoryCloudStorage) Copy(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+	opts *CopyOptions,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	object, err := ts.object(srcKey)
+	if err != nil {
+		return err
+	}
+
+	attrs := object.attrs
+	opts.apply(&attrs)
+
+	// the body is never modified in place, so it can be shared
+	return ts.put(dstKey, object.body, attrs, nil)
+}
+
+func (ts *MemoryCloudStorage) Move(
+	ctx context.Context,
+	srcKey,
+	dstKey string,
+) error {
+	return moveByCopy(ctx, ts, srcKey, dstKey)
+}
+
+func (ts *MemoryCloudStorage) UpdateAttributes(
+	ctx context.Context,
+	key string,
+	patch *AttributesPatch,
+) (*Attributes, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	object, ok := ts.objects[key]
+	if !ok {
+		return nil, memoryNotFoundError(key)
+	}
+
+	if patch != nil {
+		// the patch keys are lowercased first, so they replace the stored keys
+		lowercasePatch := *patch
+		lowercasePatch.Metadata = lowercaseMetadata(patch.Metadata)
+
+		attrs := object.attrs
+		lowercasePatch.apply(&attrs)
+
+		if attrs.ContentType == "" {
+			attrs.ContentType = http.DetectContentType(object.body)
+		}
+
+		attrs.Metadata = copyMetadata(attrs.Metadata)
+		attrs.ModTime = time.Now()
+		attrs.Metageneration++
+
+		object = &memoryObject{
+			body:  object.body,
+			attrs: attrs,
+		}
+		ts.objects[key] = object
+	}
+
+	return object.attributes(), nil
+}
+
+func (ts *MemoryCloudStorage) StartUpload(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := opts.preconditions().validateWrite(memoryPreconditions); err != nil {
+		return nil, err
+	}
+
+	return startResumableUpload(ctx, ts, key, opts, resumableUploadPartSize, 0, &memoryUploadDriver{storage: ts})
+}
+
+func (ts *MemoryCloudStorage) ResumeUpload(
+	ctx context.Context,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeResumableUpload(ctx, ts, token, &memoryUploadDriver{storage: ts})
+}
+
+// AbortStaleUploads discards the resumable uploads without any part committed within the TTL.
+func (ts *MemoryCloudStorage) AbortStaleUploads(
+	ctx context.Context,
+	ttl time.Duration,
+) (int, error) {
+	cutoff := time.Now().Add(-ttl)
+	aborted := 0
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for id, upload := range ts.uploads {
+		if upload.updated.Before(cutoff) {
+			delete(ts.uploads, id)
+			aborted++
+		}
+	}
+
+	return aborted, nil
+}
+
+func (ts *MemoryCloudStorage) object(key string) (*memoryObject, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	object, ok := ts.objects[key]
+	if !ok {
+		return nil, memoryNotFoundError(key)
+	}
+
+	return object, nil
+}
+
+// put stores the body as a new generation when the stored object matches the preconditions,
+// the caller must not modify the body afterwards.
+func (ts *MemoryCloudStorage) put(key string, body []byte, attrs Attributes, preconditions *Preconditions) error {
+	sum := md5.Sum(body) // nolint:gosec
+
+	if attrs.ContentType == "" {
+		attrs.ContentType = http.DetectContentType(body)
+	}
+
+	attrs.Metadata = lowercaseMetadata(attrs.Metadata)
+	attrs.Size = int64(len(body))
+	attrs.MD5 = sum[:]
+	attrs.ETag = fmt.Sprintf("\"%x\"", sum)
+	attrs.ModTime = time.Now()
+	attrs.Metageneration = 1
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var current *Attributes
+	if object, ok := ts.objects[key]; ok {
+		current = &object.attrs
+	}
+
+	if !preconditions.matches(current) {
+		return preconditionFailedError(key)
+	}
+
+	ts.generation++
+	attrs.Generation = ts.generation
+
+	ts.objects[key] = &memoryObject{
+		body:  body,
+		attrs: attrs,
+	}
+
+	return nil
+}
+
+// attributes returns a copy of the attributes, the caller may modify it.
+func (o *memoryObject) attributes() *Attributes {
+	attrs := o.attrs
+	attrs.Metadata = copyMetadata(o.attrs.Metadata)
+	attrs.MD5 = append([]byte(nil), o.attrs.MD5...)
+
+	return &attrs
+}
+
+func memoryNotFoundError(key string) error {
+	return wrapError(&os.PathError{Op: "open", Path: key, Err: os.ErrNotExist})
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[k] = v
+	}
+
+	return result
+}
+
+// lowercaseMetadata copies the metadata with lowercase keys, as Attributes promises.
+func lowercaseMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[strings.ToLower(k)] = v
+	}
+
+	return result
+}
+
+// memoryWriter buffers the body, the blob becomes visible on Close.
+type memoryWriter struct {
+	ctx     context.Context
+	storage *MemoryCloudStorage
+	key     string
+	attrs   Attributes
+	// preconditions are checked on Close
+	preconditions *Preconditions
+	buf           bytes.Buffer
+	closed        bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed writer")
+	}
+
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+
+	w.closed = true
+
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	return w.storage.put(w.key, w.buf.Bytes(), w.attrs, w.preconditions)
+}
+
+// memoryUploadDriver keeps the committed content of the resumable uploads in the storage.
+type memoryUploadDriver struct {
+	storage *MemoryCloudStorage
+}
+
+func (d *memoryUploadDriver) startUpload(ctx context.Context, session *uploadSession) error {
+	session.ID = uuid.New().String()
+
+	d.storage.mu.Lock()
+	defer d.storage.mu.Unlock()
+
+	d.storage.uploads[session.ID] = &memoryUpload{updated: time.Now()}
+
+	return nil
+}
+
+func (d *memoryUploadDriver) resumeUpload(ctx context.Context, session *uploadSession) error {
+	d.storage.mu.RLock()
+	defer d.storage.mu.RUnlock()
+
+	upload, ok := d.storage.uploads[session.ID]
+	if !ok {
+		return memoryNotFoundError(session.Key)
+	}
+
+	session.Offset = int64(len(upload.body))
+
+	return nil
+}
+
+func (d *memoryUploadDriver) uploadPart(ctx context.Context, session *uploadSession, part []byte, last bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.storage.mu.Lock()
+
+	upload, ok := d.storage.uploads[session.ID]
+	if !ok {
+		d.storage.mu.Unlock()
+		return memoryNotFoundError(session.Key)
+	}
+
+	upload.body = append(upload.body[:session.Offset], part...)
+	upload.updated = time.Now()
+	session.Offset = int64(len(upload.body))
+	// the stored object gets its own copy, the upload stays until it's stored
+	body := append([]byte(nil), upload.body...)
+
+	d.storage.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	// a failed put keeps the upload, so it can be resumed and completed again
+	if err := d.storage.put(session.Key, body, session.Options.attributes(), session.Options.preconditions()); err != nil {
+		return err
+	}
+
+	d.storage.mu.Lock()
+	defer d.storage.mu.Unlock()
+
+	delete(d.storage.uploads, session.ID)
+
+	return nil
+}
+
+func (d *memoryUploadDriver) abortUpload(ctx context.Context, session *uploadSession) error {
+	d.storage.mu.Lock()
+	defer d.storage.mu.Unlock()
+
+	if _, ok := d.storage.uploads[session.ID]; !ok {
+		return memoryNotFoundError(session.Key)
+	}
+
+	delete(d.storage.uploads, session.ID)
+
+	return nil
+}
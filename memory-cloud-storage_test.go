@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCloudStorageConcurrentUse(t *testing.T) {
+	t.Parallel()
+
+	storage := NewMemoryCloudStorage("bucket")
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			key := fmt.Sprintf("dir/%d", i)
+
+			writer, err := storage.GetWriter(ctx, key)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, err = writer.Write([]byte("body"))
+			assert.NoError(t, err)
+
+			// nothing is visible before the writer is closed
+			exists, err := storage.Exists(ctx, key)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			assert.NoError(t, writer.Close())
+
+			list := storage.List(ctx, "dir/")
+
+			for {
+				_, err := list.Next(ctx)
+				if err == io.EOF {
+					break
+				}
+
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	list := storage.ListWithOptions(ctx, &ListOptions{Delimiter: "/"})
+
+	item, err := list.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "dir/", item.Key)
+	require.True(t, item.IsDir)
+
+	_, err = list.Next(ctx)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestMemoryCloudStorageSignedURLIsDeterministic(t *testing.T) {
+	t.Parallel()
+
+	storage := NewMemoryCloudStorage("bucket")
+	opts := &SignedURLOption{Method: "PUT", Expiry: time.Hour, ContentType: "application/json"}
+
+	first, err := storage.GetSignedURL(context.Background(), "a/b.json", opts)
+	require.NoError(t, err)
+
+	second, err := storage.GetSignedURL(context.Background(), "a/b.json", opts)
+	require.NoError(t, err)
+
+	require.Equal(t, first, second)
+	require.Equal(t, "memory://bucket/a/b.json?contentType=application%2Fjson&expiry=3600&method=PUT", first)
+}
+
+func TestMemoryCloudStorageLowercasesMetadata(t *testing.T) {
+	t.Parallel()
+
+	storage := NewMemoryCloudStorage("bucket")
+	ctx := context.Background()
+
+	require.NoError(t, storage.WriteWithOptions(ctx, "a.json", []byte("{}"), &WriteOptions{
+		Metadata: map[string]string{"Owner": "user-1"},
+	}))
+
+	attrs, err := storage.Attributes(ctx, "a.json")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"owner": "user-1"}, attrs.Metadata)
+
+	// a mixed-case key of a patch replaces the stored key
+	attrs, err = storage.UpdateAttributes(ctx, "a.json", &AttributesPatch{
+		Metadata: map[string]string{"OWNER": "user-2", "Region": "eu"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"owner": "user-2", "region": "eu"}, attrs.Metadata)
+
+	require.NoError(t, storage.Copy(ctx, "a.json", "b.json", &CopyOptions{
+		Metadata: map[string]string{"Copied-From": "a.json"},
+	}))
+
+	attrs, err = storage.Attributes(ctx, "b.json")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"copied-from": "a.json"}, attrs.Metadata)
+}
+
+func TestMemoryCloudStorageAbortStaleUploads(t *testing.T) {
+	t.Parallel()
+
+	storage := NewMemoryCloudStorage("bucket")
+	ctx := context.Background()
+
+	upload, err := storage.StartUpload(ctx, "key", &WriteOptions{Upload: &UploadOptions{PartSize: 4}})
+	require.NoError(t, err)
+
+	_, err = upload.Write([]byte("01234"))
+	require.NoError(t, err)
+
+	aborted, err := storage.AbortStaleUploads(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, aborted)
+
+	aborted, err = storage.AbortStaleUploads(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, aborted)
+
+	_, err = storage.ResumeUpload(ctx, upload.Token())
+	assert.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+}
+
+func TestMemoryCloudStorageResumeFailedUpload(t *testing.T) {
+	t.Parallel()
+
+	storage := NewMemoryCloudStorage("bucket")
+	ctx := context.Background()
+
+	upload, err := storage.StartUpload(ctx, "key", &WriteOptions{
+		Preconditions: &Preconditions{DoesNotExist: true},
+		Upload:        &UploadOptions{PartSize: 4},
+	})
+	require.NoError(t, err)
+
+	_, err = upload.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	// the object written meanwhile fails the precondition of the final put
+	require.NoError(t, storage.Write(ctx, "key", []byte("other"), nil))
+
+	err = upload.Close()
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), "got: %v", err)
+
+	require.NoError(t, storage.Delete(ctx, "key"))
+
+	// the committed content is kept
+	upload, err = storage.ResumeUpload(ctx, upload.Token())
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), upload.Offset())
+	require.NoError(t, upload.Close())
+
+	body, err := storage.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	_, err = storage.ResumeUpload(ctx, upload.Token())
+	assert.True(t, errors.Is(err, ErrNotFound), "got: %v", err)
+}
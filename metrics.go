@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "commonblob"
+
+var (
+	metricsLabels      = []string{"provider", "bucket", "method"}
+	errorMetricsLabels = []string{"provider", "bucket", "method", "code"}
+)
+
+// WithMetrics wraps the storage into a decorator which exports Prometheus metrics of every call:
+//
+//	commonblob_operations_total                 calls by provider, bucket and method
+//	commonblob_operation_duration_seconds       latency histogram, streams are measured until Close
+//	commonblob_read_bytes_total                 bytes read by Get, GetReader, GetRangeReader and Download
+//	commonblob_written_bytes_total              bytes written by Write, GetWriter and the WithOptions variants
+//	commonblob_operation_errors_total           failed calls, labelled with the ErrorCode too
+//
+// The metrics are registered with the registerer, prometheus.DefaultRegisterer if nil.
+// Storages decorated with the same registerer share the metrics.
+func WithMetrics(storage CloudStorage, registerer prometheus.Registerer) (CloudStorage, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	observer, err := newMetricsObserver(registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, bucket := describe(storage)
+
+	return newObservedCloudStorage(storage, provider, bucket, observer), nil
+}
+
+type metricsObserver struct {
+	operations   *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	readBytes    *prometheus.CounterVec
+	writtenBytes *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+}
+
+func newMetricsObserver(registerer prometheus.Registerer) (*metricsObserver, error) {
+	operations, err := registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_total",
+		Help:      "Number of storage operations.",
+	}, metricsLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of storage operations, streaming operations last until the stream is closed.",
+		Buckets:   prometheus.DefBuckets,
+	}, metricsLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	readBytes, err := registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "read_bytes_total",
+		Help:      "Number of bytes read from the storage.",
+	}, metricsLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	writtenBytes, err := registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "written_bytes_total",
+		Help:      "Number of bytes written to the storage.",
+	}, metricsLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	errorCounts, err := registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_errors_total",
+		Help:      "Number of failed storage operations by error code.",
+	}, errorMetricsLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsObserver{
+		operations:   operations.(*prometheus.CounterVec),
+		duration:     duration.(*prometheus.HistogramVec),
+		readBytes:    readBytes.(*prometheus.CounterVec),
+		writtenBytes: writtenBytes.(*prometheus.CounterVec),
+		errors:       errorCounts.(*prometheus.CounterVec),
+	}, nil
+}
+
+// registerCollector registers the collector or returns the equal one registered before.
+func registerCollector(registerer prometheus.Registerer, collector prometheus.Collector) (prometheus.Collector, error) {
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		return alreadyRegistered.ExistingCollector, nil
+	}
+
+	return nil, err
+}
+
+func (o *metricsObserver) started(ctx context.Context, obs *observation) context.Context {
+	return ctx
+}
+
+func (o *metricsObserver) finished(ctx context.Context, obs *observation) {
+	labels := prometheus.Labels{
+		"provider": obs.provider,
+		"bucket":   obs.bucket,
+		"method":   obs.method,
+	}
+
+	o.operations.With(labels).Inc()
+	o.duration.With(labels).Observe(time.Since(obs.start).Seconds())
+
+	if obs.bytes > 0 {
+		if obs.write {
+			o.writtenBytes.With(labels).Add(float64(obs.bytes))
+		} else {
+			o.readBytes.With(labels).Add(float64(obs.bytes))
+		}
+	}
+
+	if obs.err != nil {
+		labels["code"] = Code(obs.err).String()
+		o.errors.With(labels).Inc()
+	}
+}
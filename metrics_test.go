@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	registry := prometheus.NewRegistry()
+
+	storage, err := commonblobgo.WithMetrics(commonblobgo.NewMemoryCloudStorage("bucket"), registry)
+	require.NoError(t, err)
+
+	writer, err := storage.GetWriter(ctx, "user/1.json")
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(`{"id":1}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	reader, err := storage.GetRangeReader(ctx, "user/1.json", 1, 4)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	_, err = storage.Get(ctx, "user/2.json")
+	require.Error(t, err)
+
+	// a second storage shares the metrics
+	_, err = commonblobgo.WithMetrics(commonblobgo.NewMemoryCloudStorage("other"), registry)
+	require.NoError(t, err)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP commonblob_operations_total Number of storage operations.
+# TYPE commonblob_operations_total counter
+commonblob_operations_total{bucket="bucket",method="Get",provider="memory"} 1
+commonblob_operations_total{bucket="bucket",method="GetRangeReader",provider="memory"} 1
+commonblob_operations_total{bucket="bucket",method="GetWriter",provider="memory"} 1
+# HELP commonblob_read_bytes_total Number of bytes read from the storage.
+# TYPE commonblob_read_bytes_total counter
+commonblob_read_bytes_total{bucket="bucket",method="GetRangeReader",provider="memory"} 4
+# HELP commonblob_written_bytes_total Number of bytes written to the storage.
+# TYPE commonblob_written_bytes_total counter
+commonblob_written_bytes_total{bucket="bucket",method="GetWriter",provider="memory"} 8
+# HELP commonblob_operation_errors_total Number of failed storage operations by error code.
+# TYPE commonblob_operation_errors_total counter
+commonblob_operation_errors_total{bucket="bucket",code="not_found",method="Get",provider="memory"} 1
+`),
+		"commonblob_operations_total",
+		"commonblob_read_bytes_total",
+		"commonblob_written_bytes_total",
+		"commonblob_operation_errors_total",
+	))
+
+	histograms, err := testutil.GatherAndCount(registry, "commonblob_operation_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 3, histograms)
+}
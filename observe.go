@@ -0,0 +1,590 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// observation describes a single CloudStorage call.
+type observation struct {
+	method   string
+	provider string
+	bucket   string
+	key      string
+	// destination is the destination key of Copy and Move
+	destination string
+	// offset and length are set by GetRangeReader only
+	offset int64
+	length int64
+	// bytes is the number of bytes read or written, -1 for the methods which don't transfer content
+	bytes int64
+	// write is set when the bytes were written, not read
+	write bool
+	// objects is the number of objects listed by List, ListWithOptions and ListPage, deleted by DeleteMany
+	// and DeletePrefix or aborted uploads of AbortStaleUploads, -1 for the other methods
+	objects int
+	start   time.Time
+	err     error
+}
+
+// observer is notified about the calls of an observedCloudStorage.
+type observer interface {
+	// started is called before the call, the returned context is passed to the storage.
+	started(ctx context.Context, obs *observation) context.Context
+	// finished is called once the call returns, streaming calls finish when the reader or writer
+	// is closed and List calls when the iterator is exhausted or fails.
+	finished(ctx context.Context, obs *observation)
+}
+
+// describer is implemented by the storages of this package to label their logs, metrics and traces.
+type describer interface {
+	describe() (provider, bucket string)
+}
+
+// decorator is implemented by the decorators of this package to expose the storage they wrap.
+type decorator interface {
+	unwrap() CloudStorage
+}
+
+// unwrap returns the storage under the decorators of this package.
+func unwrap(storage CloudStorage) CloudStorage {
+	for {
+		d, ok := storage.(decorator)
+		if !ok {
+			return storage
+		}
+
+		storage = d.unwrap()
+	}
+}
+
+// describe returns the provider and the bucket of the storage, custom implementations are "unknown".
+func describe(storage CloudStorage) (provider, bucket string) {
+	if d, ok := storage.(describer); ok {
+		return d.describe()
+	}
+
+	return "unknown", ""
+}
+
+// observedCloudStorage reports every call of the wrapped storage to the observer.
+type observedCloudStorage struct {
+	storage  CloudStorage
+	provider string
+	bucket   string
+	observer observer
+}
+
+func newObservedCloudStorage(storage CloudStorage, provider, bucket string, observer observer) *observedCloudStorage {
+	return &observedCloudStorage{
+		storage:  storage,
+		provider: provider,
+		bucket:   bucket,
+		observer: observer,
+	}
+}
+
+func (s *observedCloudStorage) start(ctx context.Context, method, key string) (context.Context, *observation) {
+	obs := &observation{
+		method:   method,
+		provider: s.provider,
+		bucket:   s.bucket,
+		key:      key,
+		bytes:    -1,
+		objects:  -1,
+		start:    time.Now(),
+	}
+
+	return s.observer.started(ctx, obs), obs
+}
+
+func (s *observedCloudStorage) finish(ctx context.Context, obs *observation, err error) {
+	obs.err = err
+	s.observer.finished(ctx, obs)
+}
+
+func (s *observedCloudStorage) List(ctx context.Context, prefix string) *ListIterator {
+	ctx, obs := s.start(ctx, "List", prefix)
+
+	return s.observeList(ctx, obs, s.storage.List(ctx, prefix))
+}
+
+func (s *observedCloudStorage) ListWithOptions(ctx context.Context, options *ListOptions) *ListIterator {
+	var prefix string
+	if options != nil {
+		prefix = options.Prefix
+	}
+
+	ctx, obs := s.start(ctx, "ListWithOptions", prefix)
+
+	return s.observeList(ctx, obs, s.storage.ListWithOptions(ctx, options))
+}
+
+func (s *observedCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	ctx, obs := s.start(ctx, "ListPage", listOptionsOrDefault(opts).Prefix)
+
+	page, nextToken, err := s.storage.ListPage(ctx, opts, pageToken, pageSize)
+	obs.objects = len(page)
+
+	s.finish(ctx, obs, err)
+
+	return page, nextToken, err
+}
+
+// observeList finishes the observation when the listing ends or fails, when the iterator is closed
+// or when the context of the listing is done, so abandoned iterators are observed too.
+func (s *observedCloudStorage) observeList(ctx context.Context, obs *observation, iterator *ListIterator) *ListIterator {
+	var (
+		mu   sync.Mutex
+		done = make(chan struct{})
+	)
+
+	obs.objects = 0
+
+	finish := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		close(done)
+		s.finish(ctx, obs, err)
+	}
+
+	// a context which is never done can't end the observation
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				finish(ctx.Err())
+			case <-done:
+			}
+		}()
+	}
+
+	// the calls of the iterator use the context of Next, the observation the one of the listing
+	observed := newListIterator(func(nextCtx context.Context) (*ListObject, error) {
+		item, err := iterator.Next(nextCtx)
+
+		switch {
+		case err == io.EOF:
+			finish(nil)
+		case err != nil:
+			finish(err)
+		default:
+			mu.Lock()
+			select {
+			case <-done:
+			default:
+				obs.objects++
+			}
+			mu.Unlock()
+		}
+
+		return item, err
+	})
+
+	observed.close = func() {
+		finish(nil)
+		iterator.Close()
+	}
+
+	return observed
+}
+
+func (s *observedCloudStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, obs := s.start(ctx, "Get", key)
+
+	body, err := s.storage.Get(ctx, key)
+	obs.bytes = int64(len(body))
+	s.finish(ctx, obs, err)
+
+	return body, err
+}
+
+func (s *observedCloudStorage) Delete(ctx context.Context, key string) error {
+	ctx, obs := s.start(ctx, "Delete", key)
+
+	err := s.storage.Delete(ctx, key)
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) DeleteWithOptions(ctx context.Context, key string, opts *DeleteOptions) error {
+	ctx, obs := s.start(ctx, "DeleteWithOptions", key)
+
+	err := s.storage.DeleteWithOptions(ctx, key, opts)
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) DeleteMany(ctx context.Context, keys []string) ([]DeleteResult, error) {
+	ctx, obs := s.start(ctx, "DeleteMany", "")
+
+	results, err := s.storage.DeleteMany(ctx, keys)
+
+	obs.objects = 0
+
+	for _, result := range results {
+		if result.Err == nil {
+			obs.objects++
+		}
+	}
+
+	s.finish(ctx, obs, err)
+
+	return results, err
+}
+
+func (s *observedCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	ctx, obs := s.start(ctx, "DeletePrefix", prefix)
+
+	result, err := s.storage.DeletePrefix(ctx, prefix, opts)
+
+	obs.objects = 0
+	if result != nil {
+		obs.objects = result.Deleted
+	}
+
+	s.finish(ctx, obs, err)
+
+	return result, err
+}
+
+func (s *observedCloudStorage) CreateBucket(ctx context.Context, bucketPrefix string, expirationTimeDays int64) error {
+	ctx, obs := s.start(ctx, "CreateBucket", bucketPrefix)
+
+	err := s.storage.CreateBucket(ctx, bucketPrefix, expirationTimeDays)
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) describe() (provider, bucket string) {
+	return s.provider, s.bucket
+}
+
+func (s *observedCloudStorage) unwrap() CloudStorage {
+	return s.storage
+}
+
+func (s *observedCloudStorage) Close() {
+	s.storage.Close()
+}
+
+func (s *observedCloudStorage) GetSignedURL(ctx context.Context, key string, opts *SignedURLOption) (string, error) {
+	ctx, obs := s.start(ctx, "GetSignedURL", key)
+
+	signedURL, err := s.storage.GetSignedURL(ctx, key, opts)
+	s.finish(ctx, obs, err)
+
+	return signedURL, err
+}
+
+func (s *observedCloudStorage) Write(ctx context.Context, key string, body []byte, contentType *string) error {
+	ctx, obs := s.start(ctx, "Write", key)
+	obs.write = true
+
+	err := s.storage.Write(ctx, key, body, contentType)
+
+	obs.bytes = 0
+	if err == nil {
+		obs.bytes = int64(len(body))
+	}
+
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) WriteWithOptions(ctx context.Context, key string, body []byte, opts *WriteOptions) error {
+	ctx, obs := s.start(ctx, "WriteWithOptions", key)
+	obs.write = true
+
+	err := s.storage.WriteWithOptions(ctx, key, body, opts)
+
+	obs.bytes = 0
+	if err == nil {
+		obs.bytes = int64(len(body))
+	}
+
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	ctx, obs := s.start(ctx, "Attributes", key)
+
+	attrs, err := s.storage.Attributes(ctx, key)
+	s.finish(ctx, obs, err)
+
+	return attrs, err
+}
+
+func (s *observedCloudStorage) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, obs := s.start(ctx, "GetReader", key)
+
+	reader, err := s.storage.GetReader(ctx, key)
+
+	return s.observeReader(ctx, obs, reader, err)
+}
+
+func (s *observedCloudStorage) GetRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	ctx, obs := s.start(ctx, "GetRangeReader", key)
+	obs.offset = offset
+	obs.length = length
+
+	reader, err := s.storage.GetRangeReader(ctx, key, offset, length)
+
+	return s.observeReader(ctx, obs, reader, err)
+}
+
+func (s *observedCloudStorage) Download(ctx context.Context, key string, w io.WriterAt, opts *DownloadOptions) (int64, error) {
+	ctx, obs := s.start(ctx, "Download", key)
+
+	written, err := s.storage.Download(ctx, key, w, opts)
+	obs.bytes = written
+	s.finish(ctx, obs, err)
+
+	return written, err
+}
+
+func (s *observedCloudStorage) observeReader(
+	ctx context.Context,
+	obs *observation,
+	reader io.ReadCloser,
+	err error,
+) (io.ReadCloser, error) {
+	obs.bytes = 0
+
+	if err != nil {
+		s.finish(ctx, obs, err)
+		return nil, err
+	}
+
+	return &observedReader{
+		reader:  reader,
+		storage: s,
+		ctx:     ctx,
+		obs:     obs,
+	}, nil
+}
+
+func (s *observedCloudStorage) GetWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	ctx, obs := s.start(ctx, "GetWriter", key)
+	obs.bytes = 0
+	obs.write = true
+
+	writer, err := s.storage.GetWriter(ctx, key)
+
+	return s.observeWriter(ctx, obs, writer, err)
+}
+
+func (s *observedCloudStorage) GetWriterWithOptions(ctx context.Context, key string, opts *WriteOptions) (io.WriteCloser, error) {
+	ctx, obs := s.start(ctx, "GetWriterWithOptions", key)
+	obs.bytes = 0
+	obs.write = true
+
+	writer, err := s.storage.GetWriterWithOptions(ctx, key, opts)
+
+	return s.observeWriter(ctx, obs, writer, err)
+}
+
+func (s *observedCloudStorage) observeWriter(
+	ctx context.Context,
+	obs *observation,
+	writer io.WriteCloser,
+	err error,
+) (io.WriteCloser, error) {
+	if err != nil {
+		s.finish(ctx, obs, err)
+		return nil, err
+	}
+
+	return &observedWriter{
+		writer:  writer,
+		storage: s,
+		ctx:     ctx,
+		obs:     obs,
+	}, nil
+}
+
+func (s *observedCloudStorage) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, obs := s.start(ctx, "Exists", key)
+
+	exists, err := s.storage.Exists(ctx, key)
+	s.finish(ctx, obs, err)
+
+	return exists, err
+}
+
+func (s *observedCloudStorage) Copy(ctx context.Context, srcKey, dstKey string, opts *CopyOptions) error {
+	ctx, obs := s.start(ctx, "Copy", srcKey)
+	obs.destination = dstKey
+
+	err := s.storage.Copy(ctx, srcKey, dstKey, opts)
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) Move(ctx context.Context, srcKey, dstKey string) error {
+	ctx, obs := s.start(ctx, "Move", srcKey)
+	obs.destination = dstKey
+
+	err := s.storage.Move(ctx, srcKey, dstKey)
+	s.finish(ctx, obs, err)
+
+	return err
+}
+
+func (s *observedCloudStorage) UpdateAttributes(ctx context.Context, key string, patch *AttributesPatch) (*Attributes, error) {
+	ctx, obs := s.start(ctx, "UpdateAttributes", key)
+
+	attrs, err := s.storage.UpdateAttributes(ctx, key, patch)
+	s.finish(ctx, obs, err)
+
+	return attrs, err
+}
+
+// observedReader counts the read bytes and finishes the observation on Close.
+type observedReader struct {
+	reader  io.ReadCloser
+	storage *observedCloudStorage
+	ctx     context.Context
+	obs     *observation
+	// readErr is the first read error other than io.EOF
+	readErr error
+	once    sync.Once
+}
+
+func (r *observedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.obs.bytes += int64(n)
+
+	if err != nil && err != io.EOF && r.readErr == nil {
+		r.readErr = err
+	}
+
+	return n, err
+}
+
+func (r *observedReader) Close() error {
+	err := r.reader.Close()
+
+	r.once.Do(func() {
+		finishErr := r.readErr
+		if finishErr == nil {
+			finishErr = err
+		}
+
+		r.storage.finish(r.ctx, r.obs, finishErr)
+	})
+
+	return err
+}
+
+// observedWriter counts the written bytes and finishes the observation on Close.
+type observedWriter struct {
+	writer  io.WriteCloser
+	storage *observedCloudStorage
+	ctx     context.Context
+	obs     *observation
+	// writeErr is the first write error
+	writeErr error
+	once     sync.Once
+}
+
+func (w *observedWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.obs.bytes += int64(n)
+
+	if err != nil && w.writeErr == nil {
+		w.writeErr = err
+	}
+
+	return n, err
+}
+
+func (w *observedWriter) Close() error {
+	err := w.writer.Close()
+
+	w.once.Do(func() {
+		finishErr := w.writeErr
+		if finishErr == nil {
+			finishErr = err
+		}
+
+		w.storage.finish(w.ctx, w.obs, finishErr)
+	})
+
+	return err
+}
+
+// StartUpload is observed as a call, the parts committed by the upload aren't.
+func (s *observedCloudStorage) StartUpload(ctx context.Context, key string, opts *WriteOptions) (*ResumableUpload, error) {
+	ctx, obs := s.start(ctx, "StartUpload", key)
+
+	upload, err := s.storage.StartUpload(ctx, key, opts)
+	s.finish(ctx, obs, err)
+
+	return upload, err
+}
+
+func (s *observedCloudStorage) ResumeUpload(ctx context.Context, token string) (*ResumableUpload, error) {
+	ctx, obs := s.start(ctx, "ResumeUpload", "")
+
+	upload, err := s.storage.ResumeUpload(ctx, token)
+	if upload != nil {
+		obs.key = upload.Key()
+	}
+
+	s.finish(ctx, obs, err)
+
+	return upload, err
+}
+
+func (s *observedCloudStorage) AbortStaleUploads(ctx context.Context, ttl time.Duration) (int, error) {
+	ctx, obs := s.start(ctx, "AbortStaleUploads", "")
+
+	aborted, err := s.storage.AbortStaleUploads(ctx, ttl)
+	obs.objects = aborted
+
+	s.finish(ctx, obs, err)
+
+	return aborted, err
+}
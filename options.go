@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"net/http"
+)
+
+// Option configures the storage created by NewCloudStorageWithOption, NewCloudStorageFromConfig or OpenCloudStorage.
+type Option func(*storageOptions)
+
+type storageOptions struct {
+	httpClient *http.Client
+	logger     Logger
+	retry      *RetryPolicy
+	userAgent  string
+}
+
+func newStorageOptions(opts []Option) *storageOptions {
+	options := &storageOptions{
+		logger: NopLogger(),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+// decorate wraps the storage into the decorators enabled by the options.
+func (o *storageOptions) decorate(storage CloudStorage, provider, bucket string) CloudStorage {
+	if o.retry != nil {
+		storage = newRetryingCloudStorage(storage, *o.retry, o.logger)
+	}
+
+	if _, isNop := o.logger.(nopLogger); !isNop {
+		storage = newObservedCloudStorage(storage, provider, bucket, &loggingObserver{logger: o.logger})
+	}
+
+	return storage
+}
+
+// WithHTTPClient sets the HTTP client the provider SDKs send their requests with,
+// e.g. for proxies or custom timeouts. Authorization is added on top of its transport.
+// It has no effect on the file and memory storages.
+func WithHTTPClient(client *http.Client) Option {
+	return func(options *storageOptions) {
+		options.httpClient = client
+	}
+}
+
+// WithLogger sets the logger of the storage. Besides the SDK setup, every operation is logged
+// with its key, bucket, provider, duration and error. The default logger discards everything.
+func WithLogger(logger Logger) Option {
+	return func(options *storageOptions) {
+		options.logger = logger
+	}
+}
+
+// WithRetry retries the idempotent operations failed with a transient error, see RetryPolicy.
+// The retries of the AWS, Azure and GCS SDKs are disabled then, so every provider retries the same way.
+func WithRetry(policy RetryPolicy) Option {
+	return func(options *storageOptions) {
+		options.retry = &policy
+	}
+}
+
+// WithUserAgent adds the value to the User-Agent header of every request.
+func WithUserAgent(userAgent string) Option {
+	return func(options *storageOptions) {
+		options.userAgent = userAgent
+	}
+}
+
+// transport returns the transport of the configured HTTP client with the user agent applied,
+// defaultTransport is used when no client is configured.
+func (o *storageOptions) transport(defaultTransport http.RoundTripper) http.RoundTripper {
+	transport := defaultTransport
+
+	if o.httpClient != nil {
+		transport = o.httpClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+	}
+
+	if o.userAgent != "" {
+		transport = &userAgentTransport{
+			userAgent: o.userAgent,
+			base:      transport,
+		}
+	}
+
+	return transport
+}
+
+// newHTTPClient returns the configured HTTP client with the user agent applied,
+// nil means the SDK default client should be used.
+func (o *storageOptions) newHTTPClient() *http.Client {
+	if o.httpClient == nil && o.userAgent == "" {
+		return nil
+	}
+
+	client := &http.Client{}
+	if o.httpClient != nil {
+		*client = *o.httpClient
+	}
+
+	client.Transport = o.transport(http.DefaultTransport)
+
+	return client
+}
+
+// userAgentTransport prepends the user agent to the one set by the SDK.
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	userAgent := t.userAgent
+	if sdkUserAgent := req.Header.Get("User-Agent"); sdkUserAgent != "" {
+		userAgent += " " + sdkUserAgent
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+
+	return t.base.RoundTrip(req)
+}
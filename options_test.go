@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(level, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, strings.TrimSpace(fmt.Sprintln(append([]interface{}{level, msg}, keysAndValues...)...)))
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record("debug", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record("info", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record("warn", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.record("error", msg, keysAndValues)
+}
+
+func TestOptions(t *testing.T) {
+	var userAgents []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	requests := 0
+	// the AWS SDK accepts only *http.Transport, so the client is recognized by its proxy function
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			requests++
+			return nil, nil
+		},
+	}
+	logger := &recordingLogger{}
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(
+		context.Background(),
+		false,
+		"aws",
+		"bucket",
+		commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        server.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     "key",
+			AWSS3SecretAccessKey: "secret",
+		},
+		commonblobgo.WithHTTPClient(&http.Client{Transport: transport}),
+		commonblobgo.WithUserAgent("gdpr-service/1.0"),
+		commonblobgo.WithLogger(logger),
+		commonblobgo.WithRetry(commonblobgo.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		}),
+	)
+	require.NoError(t, err)
+
+	_, err = storage.Get(context.Background(), "key")
+	require.Equal(t, commonblobgo.CodeUnavailable, commonblobgo.Code(err))
+
+	require.Equal(t, 3, requests)
+	require.Len(t, userAgents, 3)
+
+	for _, userAgent := range userAgents {
+		require.True(t, strings.HasPrefix(userAgent, "aws-sdk-go/"), userAgent)
+		require.True(t, strings.HasSuffix(userAgent, " gdpr-service/1.0"), userAgent)
+	}
+
+	require.Len(t, logger.messages, 4)
+	require.Equal(t, "info AWSCloudStorage created bucket bucket", logger.messages[0])
+	require.True(t, strings.HasPrefix(logger.messages[1], "warn retrying storage operation method Get key key attempt 1 delay 1ms"),
+		logger.messages[1])
+	require.True(t, strings.HasPrefix(logger.messages[2], "warn retrying storage operation method Get key key attempt 2 delay 1ms"),
+		logger.messages[2])
+	require.True(t, strings.HasPrefix(logger.messages[3], "error storage operation failed method Get provider aws bucket bucket key key"),
+		logger.messages[3])
+}
+
+func TestOptionsRetryGCS(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(
+		context.Background(),
+		true,
+		"gcp",
+		"bucket",
+		commonblobgo.CloudStorageOption{
+			GCPStorageEmulatorHost: strings.TrimPrefix(server.URL, "http://"),
+		},
+		commonblobgo.WithRetry(commonblobgo.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		}),
+	)
+	require.NoError(t, err)
+
+	// the GCS client doesn't retry on its own, every attempt is a single request
+	_, err = storage.Get(context.Background(), "key")
+	require.Equal(t, commonblobgo.CodeUnavailable, commonblobgo.Code(err))
+	require.Equal(t, 3, requests)
+
+	requests = 0
+
+	_, err = storage.Attributes(context.Background(), "key")
+	require.Equal(t, commonblobgo.CodeUnavailable, commonblobgo.Code(err))
+	require.Equal(t, 3, requests)
+}
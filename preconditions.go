@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Preconditions make a write or a delete fail with a *PreconditionError when the stored object
+// doesn't match them, so concurrent writers can't silently overwrite each other.
+// Take ETag, Generation and Metageneration from Attributes and pass them back unchanged.
+//
+// GCS supports DoesNotExist, GenerationMatch and MetagenerationMatch, S3 and Azure DoesNotExist and ETagMatch,
+// the memory storage all of them and the file storage DoesNotExist and ETagMatch among the callers
+// of the same FileCloudStorage.
+// Unsupported preconditions fail with ErrInvalidArgument, nothing is written or deleted then.
+type Preconditions struct {
+	// DoesNotExist requires the object to be missing, it can't be combined with the other preconditions
+	// and isn't available for deletes
+	DoesNotExist bool
+	// GenerationMatch requires the object to have the generation
+	GenerationMatch int64
+	// MetagenerationMatch requires the object to have the metageneration
+	MetagenerationMatch int64
+	// ETagMatch requires the object to have the entity tag
+	ETagMatch string
+}
+
+// DeleteOptions controls DeleteWithOptions.
+type DeleteOptions struct {
+	Preconditions *Preconditions
+}
+
+// preconditions returns the preconditions of the options, nil if there are none.
+func (o *DeleteOptions) preconditions() *Preconditions {
+	if o == nil {
+		return nil
+	}
+
+	return o.Preconditions
+}
+
+// PreconditionError is returned when the stored object doesn't match the preconditions.
+// It matches ErrPreconditionFailed.
+type PreconditionError struct {
+	Key string
+	// Err is the error reported by the provider
+	Err error
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("precondition of '%s' failed: %v", e.Key, e.Err)
+}
+
+func (e *PreconditionError) Unwrap() error {
+	return e.Err
+}
+
+// preconditionsSupport lists the preconditions a provider is able to check.
+type preconditionsSupport struct {
+	provider    string
+	generations bool
+	etags       bool
+}
+
+var (
+	gcsPreconditions    = preconditionsSupport{provider: "GCS", generations: true}
+	s3Preconditions     = preconditionsSupport{provider: "S3", etags: true}
+	azurePreconditions  = preconditionsSupport{provider: "Azure", etags: true}
+	filePreconditions   = preconditionsSupport{provider: "file storage", etags: true}
+	memoryPreconditions = preconditionsSupport{provider: "memory storage", generations: true, etags: true}
+)
+
+// validateWrite checks the provider is able to check the preconditions of a write.
+func (p *Preconditions) validateWrite(support preconditionsSupport) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.DoesNotExist && (p.GenerationMatch != 0 || p.MetagenerationMatch != 0 || p.ETagMatch != "") {
+		return newError(CodeInvalidArgument, fmt.Errorf("the DoesNotExist precondition can't be combined with others"))
+	}
+
+	if !support.generations && (p.GenerationMatch != 0 || p.MetagenerationMatch != 0) {
+		return newError(CodeInvalidArgument, fmt.Errorf("generation preconditions aren't supported by %s", support.provider))
+	}
+
+	if !support.etags && p.ETagMatch != "" {
+		return newError(CodeInvalidArgument, fmt.Errorf("ETag preconditions aren't supported by %s", support.provider))
+	}
+
+	return nil
+}
+
+// validateDelete checks the provider is able to check the preconditions of a delete.
+func (p *Preconditions) validateDelete(support preconditionsSupport) error {
+	if p != nil && p.DoesNotExist {
+		return newError(CodeInvalidArgument, fmt.Errorf("the DoesNotExist precondition isn't available for deletes"))
+	}
+
+	return p.validateWrite(support)
+}
+
+// wrapError turns the failed precondition reported by the provider into a *PreconditionError.
+// Some services report an existing object as a conflict.
+func (p *Preconditions) wrapError(key string, err error) error {
+	if p == nil || err == nil {
+		return err
+	}
+
+	var preconditionErr *PreconditionError
+	if errors.As(err, &preconditionErr) {
+		return err
+	}
+
+	code := Code(err)
+	if code != CodePreconditionFailed && !(p.DoesNotExist && code == CodeAlreadyExists) {
+		return err
+	}
+
+	return newError(CodePreconditionFailed, &PreconditionError{
+		Key: key,
+		Err: err,
+	})
+}
+
+// preconditionFailedError is returned by the storages which check the preconditions by themselves.
+func preconditionFailedError(key string) error {
+	return newError(CodePreconditionFailed, &PreconditionError{
+		Key: key,
+		Err: errors.New("the stored object doesn't match the preconditions"),
+	})
+}
+
+// matches checks the preconditions against the attributes of a stored object, nil when it's missing.
+func (p *Preconditions) matches(attrs *Attributes) bool {
+	if p == nil {
+		return true
+	}
+
+	if p.DoesNotExist {
+		return attrs == nil
+	}
+
+	if attrs == nil {
+		return p.GenerationMatch == 0 && p.MetagenerationMatch == 0 && p.ETagMatch == ""
+	}
+
+	return (p.GenerationMatch == 0 || p.GenerationMatch == attrs.Generation) &&
+		(p.MetagenerationMatch == 0 || p.MetagenerationMatch == attrs.Metageneration) &&
+		(p.ETagMatch == "" || p.ETagMatch == attrs.ETag)
+}
+
+// gcsConditions converts the preconditions, nil means there are none.
+func (p *Preconditions) gcsConditions() *storage.Conditions {
+	if p == nil || (!p.DoesNotExist && p.GenerationMatch == 0 && p.MetagenerationMatch == 0) {
+		return nil
+	}
+
+	return &storage.Conditions{
+		DoesNotExist:        p.DoesNotExist,
+		GenerationMatch:     p.GenerationMatch,
+		MetagenerationMatch: p.MetagenerationMatch,
+	}
+}
+
+// azureAccessConditions converts the preconditions.
+func (p *Preconditions) azureAccessConditions() azblob.BlobAccessConditions {
+	conditions := azblob.BlobAccessConditions{}
+
+	if p == nil {
+		return conditions
+	}
+
+	if p.DoesNotExist {
+		conditions.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	}
+
+	if p.ETagMatch != "" {
+		conditions.ModifiedAccessConditions.IfMatch = azblob.ETag(p.ETagMatch)
+	}
+
+	return conditions
+}
+
+type s3ConditionalHeadersKey struct{}
+
+// withS3ConditionalHeaders passes the preconditions to the S3 requests made with the context,
+// the SDK version in use has no fields for the conditional headers of writes and deletes.
+func withS3ConditionalHeaders(ctx context.Context, p *Preconditions) context.Context {
+	if p == nil || (!p.DoesNotExist && p.ETagMatch == "") {
+		return ctx
+	}
+
+	headers := http.Header{}
+
+	if p.DoesNotExist {
+		headers.Set("If-None-Match", "*")
+	}
+
+	if p.ETagMatch != "" {
+		headers.Set("If-Match", p.ETagMatch)
+	}
+
+	return context.WithValue(ctx, s3ConditionalHeadersKey{}, headers)
+}
+
+// addS3ConditionalHeaders is a build handler of the S3 clients which adds the headers of withS3ConditionalHeaders
+// to the requests creating or deleting an object. Multipart uploads are checked when they are completed.
+func addS3ConditionalHeaders(r *request.Request) {
+	headers, ok := r.Context().Value(s3ConditionalHeadersKey{}).(http.Header)
+	if !ok {
+		return
+	}
+
+	switch r.Operation.Name {
+	case "PutObject", "CompleteMultipartUpload", "DeleteObject":
+		for name := range headers {
+			r.HTTPRequest.Header.Set(name, headers.Get(name))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreconditionsValidate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		preconditions *Preconditions
+		support       preconditionsSupport
+		valid         bool
+	}{
+		{"none", nil, s3Preconditions, true},
+		{"does not exist", &Preconditions{DoesNotExist: true}, s3Preconditions, true},
+		{"does not exist with etag", &Preconditions{DoesNotExist: true, ETagMatch: `"a"`}, memoryPreconditions, false},
+		{"generation on gcs", &Preconditions{GenerationMatch: 1, MetagenerationMatch: 1}, gcsPreconditions, true},
+		{"generation on s3", &Preconditions{GenerationMatch: 1}, s3Preconditions, false},
+		{"etag on azure", &Preconditions{ETagMatch: `"0x1"`}, azurePreconditions, true},
+		{"etag on gcs", &Preconditions{ETagMatch: "CAE="}, gcsPreconditions, false},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.preconditions.validateWrite(testCase.support)
+			if testCase.valid {
+				require.NoError(t, err)
+			} else {
+				require.True(t, errors.Is(err, ErrInvalidArgument), "got: %v", err)
+			}
+		})
+	}
+
+	err := (&Preconditions{DoesNotExist: true}).validateDelete(memoryPreconditions)
+	require.True(t, errors.Is(err, ErrInvalidArgument))
+}
+
+func TestPreconditionsWrapError(t *testing.T) {
+	alreadyExists := newError(CodeAlreadyExists, errors.New("blob already exists"))
+
+	err := (&Preconditions{DoesNotExist: true}).wrapError("user/1.json", alreadyExists)
+	require.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	var preconditionErr *PreconditionError
+	require.True(t, errors.As(err, &preconditionErr))
+	require.Equal(t, "user/1.json", preconditionErr.Key)
+
+	// wrapped once only
+	require.Equal(t, err, (&Preconditions{DoesNotExist: true}).wrapError("user/1.json", err))
+
+	// a conflict is a failed precondition for DoesNotExist only
+	require.Equal(t, alreadyExists, (&Preconditions{ETagMatch: `"a"`}).wrapError("user/1.json", alreadyExists))
+	require.Equal(t, alreadyExists, (*Preconditions)(nil).wrapError("user/1.json", alreadyExists))
+}
+
+func TestAddS3ConditionalHeaders(t *testing.T) {
+	newRequest := func(ctx context.Context, operation string) *request.Request {
+		r := &request.Request{
+			Operation:   &request.Operation{Name: operation},
+			HTTPRequest: &http.Request{Header: http.Header{}, URL: &url.URL{}},
+		}
+		r.SetContext(ctx)
+
+		return r
+	}
+
+	ctx := withS3ConditionalHeaders(context.Background(), &Preconditions{DoesNotExist: true})
+
+	put := newRequest(ctx, "PutObject")
+	addS3ConditionalHeaders(put)
+	require.Equal(t, "*", put.HTTPRequest.Header.Get("If-None-Match"))
+
+	part := newRequest(ctx, "UploadPart")
+	addS3ConditionalHeaders(part)
+	require.Empty(t, part.HTTPRequest.Header.Get("If-None-Match"))
+
+	ctx = withS3ConditionalHeaders(context.Background(), &Preconditions{ETagMatch: `"abc"`})
+
+	del := newRequest(ctx, "DeleteObject")
+	addS3ConditionalHeaders(del)
+	require.Equal(t, `"abc"`, del.HTTPRequest.Header.Get("If-Match"))
+
+	plain := newRequest(context.Background(), "PutObject")
+	addS3ConditionalHeaders(plain)
+	require.Empty(t, plain.HTTPRequest.Header)
+}
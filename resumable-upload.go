@@ -0,0 +1,890 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// resumableUploadPartSize is the default part size of the resumable uploads, GCS uses gcsDefaultChunkSize
+	resumableUploadPartSize = 8 * 1024 * 1024
+	// gcsChunkAlignment is the size GCS resumable upload chunks are a multiple of
+	gcsChunkAlignment = 256 * 1024
+	// gcsUploadURL is the JSON API endpoint of the GCS uploads, the test storage sends it to the emulator
+	gcsUploadURL = "https://storage.googleapis.com/upload/storage/v1"
+	// gcsResumeIncomplete is the status of a resumable upload waiting for more content
+	gcsResumeIncomplete = 308
+	// reservedPrefix holds the internal objects of the Azure and GCS storages, like the directory the file
+	// storage reserves. It's hidden from the listings and the keys under it are rejected
+	reservedPrefix = fileInternalDir + "/"
+	// reservedUploadsPrefix holds the Azure staging blobs and the GCS session records of the resumable uploads
+	reservedUploadsPrefix = reservedPrefix + fileUploadsDir + "/"
+)
+
+// isReservedKey reports whether the key is under the reserved prefix.
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, reservedPrefix)
+}
+
+// checkReservedKey rejects the keys under the reserved prefix.
+func checkReservedKey(provider, key string) error {
+	if isReservedKey(key) {
+		return newError(CodeInvalidArgument,
+			fmt.Errorf("invalid key for %s storage: '%s', prefix %s is reserved", provider, key, reservedPrefix))
+	}
+
+	return nil
+}
+
+// hideReservedObjects skips the items under the reserved prefix.
+func hideReservedObjects(iterator *ListIterator) *ListIterator {
+	return wrapListIterator(iterator, func(ctx context.Context) (*ListObject, error) {
+		for {
+			item, err := iterator.Next(ctx)
+			if err != nil || !isReservedKey(item.Key) {
+				return item, err
+			}
+		}
+	})
+}
+
+// ResumableUpload uploads a blob in parts which survive the process, see CloudStorage.StartUpload.
+//
+// Offset is the size of the committed content and Token the serialized state of the upload.
+// A process which stores the token can continue the upload after a restart with CloudStorage.ResumeUpload,
+// writing the content from Offset on. The content written after Offset isn't committed yet and is lost
+// with the process. After a failed Write the upload has to be resumed from Offset too.
+//
+// The upload uses the context it was started or resumed with. It isn't safe for concurrent use.
+type ResumableUpload struct {
+	ctx     context.Context
+	driver  uploadDriver
+	session *uploadSession
+	buffer  []byte
+	// progress is called with the offset after every committed part
+	progress func(written int64)
+	// err makes the upload fail after a failed part
+	err error
+}
+
+// uploadSession is the state of a resumable upload, Token serializes it.
+type uploadSession struct {
+	Provider string `json:"provider"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	// ID is the S3 upload ID, the GCS session URI or the ID of the Azure, file or memory upload,
+	// it's empty until the first part is committed
+	ID        string       `json:"id,omitempty"`
+	PartSize  int64        `json:"partSize"`
+	Offset    int64        `json:"offset"`
+	Parts     []uploadPart `json:"parts,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	// Options are the write options without the upload options
+	Options *WriteOptions `json:"options,omitempty"`
+}
+
+// uploadPart is a committed S3 part or Azure block.
+type uploadPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag,omitempty"`
+	Size   int64  `json:"size"`
+}
+
+// uploadDriver commits the parts of the resumable uploads of a storage.
+type uploadDriver interface {
+	// startUpload starts the upload of the session with the first part and sets its ID
+	startUpload(ctx context.Context, session *uploadSession) error
+	// resumeUpload checks the upload is still alive, it moves the offset to the committed content
+	// when the storage knows it better than the token
+	resumeUpload(ctx context.Context, session *uploadSession) error
+	// uploadPart commits the part at the offset of the session and advances it, the last part completes the upload
+	uploadPart(ctx context.Context, session *uploadSession, part []byte, last bool) error
+	// abortUpload discards the committed parts
+	abortUpload(ctx context.Context, session *uploadSession) error
+}
+
+// startResumableUpload validates the options and starts an upload, the driver is called with the first part.
+func startResumableUpload(
+	ctx context.Context,
+	storage CloudStorage,
+	key string,
+	opts *WriteOptions,
+	defaultPartSize int64,
+	minPartSize int64,
+	driver uploadDriver,
+) (*ResumableUpload, error) {
+	if key == "" {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("unable to upload an empty key"))
+	}
+
+	if opts != nil && len(opts.ContentMD5) > 0 {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("ContentMD5 isn't supported by resumable uploads"))
+	}
+
+	if err := opts.upload().validate(minPartSize); err != nil {
+		return nil, err
+	}
+
+	provider, bucket := describe(storage)
+
+	session := &uploadSession{
+		Provider:  provider,
+		Bucket:    bucket,
+		Key:       key,
+		PartSize:  opts.upload().partSize(defaultPartSize),
+		CreatedAt: time.Now().UTC(),
+		Options:   &WriteOptions{},
+	}
+
+	if opts != nil {
+		session.Options = writeOptionsOf(&Attributes{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        opts.ContentType,
+			Metadata:           opts.Metadata,
+		})
+		session.Options.Preconditions = opts.Preconditions
+	}
+
+	return newResumableUpload(ctx, driver, session, opts.upload()), nil
+}
+
+// resumeResumableUpload parses the token of an upload of the storage and resumes it.
+func resumeResumableUpload(
+	ctx context.Context,
+	storage CloudStorage,
+	token string,
+	driver uploadDriver,
+) (*ResumableUpload, error) {
+	body, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("invalid upload token: %v", err))
+	}
+
+	session := &uploadSession{}
+	if err := json.Unmarshal(body, session); err != nil {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("invalid upload token: %v", err))
+	}
+
+	provider, bucket := describe(storage)
+	if session.Provider != provider || session.Bucket != bucket {
+		return nil, newError(CodeInvalidArgument, fmt.Errorf("the upload token belongs to %s bucket '%s'",
+			session.Provider, session.Bucket))
+	}
+
+	if session.ID != "" {
+		if err := driver.resumeUpload(ctx, session); err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	return newResumableUpload(ctx, driver, session, nil), nil
+}
+
+func newResumableUpload(
+	ctx context.Context,
+	driver uploadDriver,
+	session *uploadSession,
+	upload *UploadOptions,
+) *ResumableUpload {
+	resumableUpload := &ResumableUpload{
+		ctx:     ctx,
+		driver:  driver,
+		session: session,
+	}
+
+	if upload != nil {
+		resumableUpload.progress = upload.Progress
+	}
+
+	return resumableUpload
+}
+
+// Key returns the key of the uploaded blob.
+func (u *ResumableUpload) Key() string {
+	return u.session.Key
+}
+
+// Offset returns the size of the committed content, a resumed upload continues from there.
+func (u *ResumableUpload) Offset() int64 {
+	return u.session.Offset
+}
+
+// Token returns the serialized state of the upload, pass it to ResumeUpload to continue the upload.
+// The token changes with every committed part. It grants access to the upload, keep it secret.
+func (u *ResumableUpload) Token() string {
+	body, _ := json.Marshal(u.session) // nolint:errchkjson
+
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+// Write buffers the content and commits every full part.
+func (u *ResumableUpload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+
+	u.buffer = append(u.buffer, p...)
+
+	var committed int64
+
+	for int64(len(u.buffer))-committed >= u.session.PartSize {
+		if err := u.commit(u.buffer[committed:committed+u.session.PartSize], false); err != nil {
+			return 0, err
+		}
+
+		committed += u.session.PartSize
+	}
+
+	if committed > 0 {
+		u.buffer = append(u.buffer[:0], u.buffer[committed:]...)
+	}
+
+	return len(p), nil
+}
+
+// Close commits the rest of the content and completes the upload, the blob becomes visible.
+func (u *ResumableUpload) Close() error {
+	if u.err != nil {
+		return u.err
+	}
+
+	err := u.commit(u.buffer, true)
+	u.buffer = nil
+
+	return err
+}
+
+// Abort discards the committed parts, the token can't be resumed anymore.
+func (u *ResumableUpload) Abort() error {
+	u.buffer = nil
+	u.err = newError(CodeInvalidArgument, fmt.Errorf("the upload of '%s' is aborted", u.session.Key))
+
+	if u.session.ID == "" {
+		return nil
+	}
+
+	return wrapError(u.driver.abortUpload(u.ctx, u.session))
+}
+
+func (u *ResumableUpload) commit(part []byte, last bool) error {
+	preconditions := u.session.Options.preconditions()
+
+	if u.session.ID == "" {
+		if u.session.Options.ContentType == "" {
+			u.session.Options.ContentType = http.DetectContentType(part)
+		}
+
+		if err := u.driver.startUpload(u.ctx, u.session); err != nil {
+			u.err = preconditions.wrapError(u.session.Key, wrapError(err))
+			return u.err
+		}
+	}
+
+	if err := u.driver.uploadPart(u.ctx, u.session, part, last); err != nil {
+		u.err = preconditions.wrapError(u.session.Key, wrapError(err))
+		return u.err
+	}
+
+	if u.progress != nil {
+		u.progress(u.session.Offset)
+	}
+
+	return nil
+}
+
+// s3UploadDriver commits the parts of S3 multipart uploads.
+type s3UploadDriver struct {
+	client     *s3.S3
+	bucketName string
+}
+
+func (d *s3UploadDriver) startUpload(ctx context.Context, session *uploadSession) error {
+	uploadInput := newS3UploadInput(d.bucketName, session.Key, session.Options)
+
+	resp, err := d.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             uploadInput.Bucket,
+		Key:                uploadInput.Key,
+		CacheControl:       uploadInput.CacheControl,
+		ContentDisposition: uploadInput.ContentDisposition,
+		ContentEncoding:    uploadInput.ContentEncoding,
+		ContentLanguage:    uploadInput.ContentLanguage,
+		ContentType:        uploadInput.ContentType,
+		Metadata:           uploadInput.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	session.ID = aws.StringValue(resp.UploadId)
+
+	return nil
+}
+
+func (d *s3UploadDriver) resumeUpload(ctx context.Context, session *uploadSession) error {
+	_, err := d.client.ListPartsWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(d.bucketName),
+		Key:      aws.String(session.Key),
+		UploadId: aws.String(session.ID),
+		MaxParts: aws.Int64(1),
+	})
+
+	return err
+}
+
+func (d *s3UploadDriver) uploadPart(ctx context.Context, session *uploadSession, part []byte, last bool) error {
+	// every part but the last one is at least 5 MiB, an empty blob is uploaded as a single empty part
+	if len(part) > 0 || len(session.Parts) == 0 {
+		number := len(session.Parts) + 1
+
+		resp, err := d.client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(d.bucketName),
+			Key:        aws.String(session.Key),
+			UploadId:   aws.String(session.ID),
+			PartNumber: aws.Int64(int64(number)),
+			Body:       bytes.NewReader(part),
+		})
+		if err != nil {
+			return err
+		}
+
+		session.Parts = append(session.Parts, uploadPart{
+			Number: number,
+			ETag:   aws.StringValue(resp.ETag),
+			Size:   int64(len(part)),
+		})
+		session.Offset += int64(len(part))
+	}
+
+	if !last {
+		return nil
+	}
+
+	completedParts := make([]*s3.CompletedPart, 0, len(session.Parts))
+	for _, p := range session.Parts {
+		completedParts = append(completedParts, &s3.CompletedPart{
+			PartNumber: aws.Int64(int64(p.Number)),
+			ETag:       aws.String(p.ETag),
+		})
+	}
+
+	ctx = withS3ConditionalHeaders(ctx, session.Options.preconditions())
+
+	_, err := d.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.bucketName),
+		Key:             aws.String(session.Key),
+		UploadId:        aws.String(session.ID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
+	})
+
+	return err
+}
+
+func (d *s3UploadDriver) abortUpload(ctx context.Context, session *uploadSession) error {
+	_, err := d.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(d.bucketName),
+		Key:      aws.String(session.Key),
+		UploadId: aws.String(session.ID),
+	})
+
+	return err
+}
+
+// abortStaleS3Uploads aborts the multipart uploads of the bucket started before the TTL,
+// including the ones of GetWriter and of other clients.
+func abortStaleS3Uploads(ctx context.Context, client *s3.S3, bucketName string, ttl time.Duration) (int, error) {
+	cutoff := time.Now().Add(-ttl)
+	aborted := 0
+
+	var abortErr error
+
+	err := client.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucketName),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			if upload.Initiated == nil || !upload.Initiated.Before(cutoff) {
+				continue
+			}
+
+			_, abortErr = client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucketName),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if abortErr != nil {
+				return false
+			}
+
+			aborted++
+		}
+
+		return true
+	})
+	if err == nil {
+		err = abortErr
+	}
+
+	return aborted, wrapError(err)
+}
+
+// startS3Upload starts a multipart upload of the S3 storages, the parts are at least 5 MiB.
+func startS3Upload(
+	ctx context.Context,
+	storage CloudStorage,
+	client *s3.S3,
+	bucketName string,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := opts.preconditions().validateWrite(s3Preconditions); err != nil {
+		return nil, err
+	}
+
+	return startResumableUpload(ctx, storage, key, opts, resumableUploadPartSize, s3manager.MinUploadPartSize,
+		&s3UploadDriver{
+			client:     client,
+			bucketName: bucketName,
+		})
+}
+
+// resumeS3Upload resumes an upload of startS3Upload.
+func resumeS3Upload(
+	ctx context.Context,
+	storage CloudStorage,
+	client *s3.S3,
+	bucketName string,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeResumableUpload(ctx, storage, token, &s3UploadDriver{
+		client:     client,
+		bucketName: bucketName,
+	})
+}
+
+// gcsUploadDriver commits the chunks of GCS resumable upload sessions with the JSON API,
+// the storage client doesn't expose the session URI. GCS can't list the sessions, so every session
+// is recorded by an object under the reserved prefix until it's completed or aborted.
+type gcsUploadDriver struct {
+	// httpClient authorizes the requests starting the sessions, the session URIs are authorized by themselves
+	httpClient *http.Client
+	bucketName string
+}
+
+func (d *gcsUploadDriver) startUpload(ctx context.Context, session *uploadSession) error {
+	query := url.Values{}
+	query.Set("uploadType", "resumable")
+	query.Set("name", session.Key)
+
+	if conditions := session.Options.preconditions().gcsConditions(); conditions != nil {
+		if conditions.DoesNotExist {
+			query.Set("ifGenerationMatch", "0")
+		}
+
+		if conditions.GenerationMatch != 0 {
+			query.Set("ifGenerationMatch", strconv.FormatInt(conditions.GenerationMatch, 10))
+		}
+
+		if conditions.MetagenerationMatch != 0 {
+			query.Set("ifMetagenerationMatch", strconv.FormatInt(conditions.MetagenerationMatch, 10))
+		}
+	}
+
+	opts := session.Options
+
+	body, err := json.Marshal(map[string]interface{}{
+		"name":               session.Key,
+		"cacheControl":       opts.CacheControl,
+		"contentDisposition": opts.ContentDisposition,
+		"contentEncoding":    opts.ContentEncoding,
+		"contentLanguage":    opts.ContentLanguage,
+		"contentType":        opts.ContentType,
+		"metadata":           opts.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		gcsUploadURL+"/b/"+url.PathEscape(d.bucketName)+"/o?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", opts.ContentType)
+
+	resp, err := d.do(req)
+	if err != nil {
+		return err
+	}
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+
+	sessionURI := resp.Header.Get("Location")
+	if sessionURI == "" {
+		return fmt.Errorf("GCS didn't return the session URI of the upload of '%s'", session.Key)
+	}
+
+	// an unrecorded session couldn't be aborted by AbortStaleUploads
+	if err := d.record(ctx, sessionURI); err != nil {
+		_ = d.cancel(ctx, sessionURI)
+		return err
+	}
+
+	session.ID = sessionURI
+
+	return nil
+}
+
+// recordName returns the object recording the session, named after the hash of the session URI
+// which grants access to the upload.
+func (d *gcsUploadDriver) recordName(sessionURI string) string {
+	sum := sha256.Sum256([]byte(sessionURI))
+	return reservedUploadsPrefix + hex.EncodeToString(sum[:])
+}
+
+// record writes the object recording the session, its creation time is the start of the upload.
+func (d *gcsUploadDriver) record(ctx context.Context, sessionURI string) error {
+	query := url.Values{}
+	query.Set("uploadType", "media")
+	query.Set("name", d.recordName(sessionURI))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		gcsUploadURL+"/b/"+url.PathEscape(d.bucketName)+"/o?"+query.Encode(), strings.NewReader(sessionURI))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := d.do(req)
+	if err != nil {
+		return err
+	}
+
+	return googleapi.CheckResponse(resp)
+}
+
+// recordedSession reads the session URI from the object recording the session.
+func (d *gcsUploadDriver) recordedSession(ctx context.Context, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.objectURL(name)+"?alt=media", http.NoBody)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return "", err
+	}
+
+	sessionURI, err := ioutil.ReadAll(resp.Body)
+
+	return string(sessionURI), err
+}
+
+// unrecord deletes the object recording the session, it may be deleted already.
+func (d *gcsUploadDriver) unrecord(ctx context.Context, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, d.objectURL(name), http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return googleapi.CheckResponse(resp)
+}
+
+func (d *gcsUploadDriver) objectURL(name string) string {
+	return gcsAPIURL + "/b/" + url.PathEscape(d.bucketName) + "/o/" + url.PathEscape(name)
+}
+
+func (d *gcsUploadDriver) resumeUpload(ctx context.Context, session *uploadSession) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.ID, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Range", "bytes */*")
+
+	resp, err := d.do(req)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case gcsResumeIncomplete:
+		session.Offset = gcsCommittedOffset(resp)
+		return nil
+	case http.StatusOK, http.StatusCreated:
+		return newError(CodeAlreadyExists, fmt.Errorf("the upload of '%s' is already completed", session.Key))
+	}
+
+	return googleapi.CheckResponse(resp)
+}
+
+func (d *gcsUploadDriver) uploadPart(ctx context.Context, session *uploadSession, part []byte, last bool) error {
+	end := session.Offset + int64(len(part))
+
+	// GCS may commit a part of a chunk only, the rest is sent again
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.ID, bytes.NewReader(part))
+		if err != nil {
+			return err
+		}
+
+		total := "*"
+		if last {
+			total = strconv.FormatInt(end, 10)
+		}
+
+		if len(part) == 0 {
+			req.Header.Set("Content-Range", "bytes */"+total)
+		} else {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", session.Offset, end-1, total))
+		}
+
+		resp, err := d.do(req)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+			session.Offset = end
+
+			// a record left behind is removed by AbortStaleUploads
+			_ = d.unrecord(ctx, d.recordName(session.ID))
+
+			return nil
+		case resp.StatusCode != gcsResumeIncomplete:
+			return googleapi.CheckResponse(resp)
+		}
+
+		committed := gcsCommittedOffset(resp)
+		if committed < session.Offset || committed > end {
+			return fmt.Errorf("GCS committed %d bytes of the upload of '%s', expected %d to %d",
+				committed, session.Key, session.Offset, end)
+		}
+
+		part = part[committed-session.Offset:]
+		session.Offset = committed
+
+		if committed == end && !last {
+			return nil
+		}
+	}
+}
+
+func (d *gcsUploadDriver) abortUpload(ctx context.Context, session *uploadSession) error {
+	if err := d.cancel(ctx, session.ID); err != nil {
+		return err
+	}
+
+	return d.unrecord(ctx, d.recordName(session.ID))
+}
+
+// cancel cancels the session, its committed content is discarded.
+func (d *gcsUploadDriver) cancel(ctx context.Context, sessionURI string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, sessionURI, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.do(req)
+	if err != nil {
+		return err
+	}
+
+	// a cancelled session replies with 499
+	if resp.StatusCode == 499 || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return googleapi.CheckResponse(resp)
+}
+
+// do sends the request and reads the response body, so CheckResponse can report the error.
+func (d *gcsUploadDriver) do(req *http.Request) (*http.Response, error) {
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// gcsCommittedOffset parses the Range header of an incomplete upload, e.g. "bytes=0-1023".
+func gcsCommittedOffset(resp *http.Response) int64 {
+	committedRange := resp.Header.Get("Range")
+
+	i := strings.LastIndex(committedRange, "-")
+	if i < 0 {
+		return 0
+	}
+
+	last, err := strconv.ParseInt(committedRange[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return last + 1
+}
+
+// gcsPartSize rounds the part size of the options up to a multiple of 256 KiB, as GCS requires for the chunks.
+func gcsPartSize(opts *WriteOptions) *WriteOptions {
+	upload := opts.upload()
+	if upload == nil || upload.PartSize%gcsChunkAlignment == 0 {
+		return opts
+	}
+
+	aligned := *upload
+	aligned.PartSize = (upload.PartSize/gcsChunkAlignment + 1) * gcsChunkAlignment
+
+	optsCopy := *opts
+	optsCopy.Upload = &aligned
+
+	return &optsCopy
+}
+
+// startGCSUpload starts a resumable upload of the GCS storages, the session URI is the ID of the upload.
+func startGCSUpload(
+	ctx context.Context,
+	storage CloudStorage,
+	httpClient *http.Client,
+	bucketName string,
+	key string,
+	opts *WriteOptions,
+) (*ResumableUpload, error) {
+	if err := opts.preconditions().validateWrite(gcsPreconditions); err != nil {
+		return nil, err
+	}
+
+	return startResumableUpload(ctx, storage, key, gcsPartSize(opts), gcsDefaultChunkSize, gcsChunkAlignment,
+		&gcsUploadDriver{
+			httpClient: httpClient,
+			bucketName: bucketName,
+		})
+}
+
+// abortStaleGCSUploads cancels the sessions of startGCSUpload recorded before the TTL.
+// A session completed or expired meanwhile only has its record deleted.
+func abortStaleGCSUploads(
+	ctx context.Context,
+	httpClient *http.Client,
+	bucketName string,
+	ttl time.Duration,
+) (int, error) {
+	cutoff := time.Now().Add(-ttl)
+	driver := &gcsUploadDriver{httpClient: httpClient, bucketName: bucketName}
+	aborted := 0
+
+	for token := ""; ; {
+		page, nextToken, err := listGCSObjects(ctx, httpClient, bucketName,
+			&ListOptions{Prefix: reservedUploadsPrefix}, token, 0)
+		if err != nil {
+			return aborted, err
+		}
+
+		for _, item := range page {
+			if !item.ModTime.Before(cutoff) {
+				continue
+			}
+
+			sessionURI, err := driver.recordedSession(ctx, item.Key)
+			if err != nil {
+				// the upload completed since the listing
+				if Code(wrapError(err)) == CodeNotFound {
+					continue
+				}
+
+				return aborted, wrapError(err)
+			}
+
+			err = driver.abortUpload(ctx, &uploadSession{ID: sessionURI})
+			if Code(wrapError(err)) == CodeNotFound {
+				err = driver.unrecord(ctx, item.Key)
+			} else if err == nil {
+				aborted++
+			}
+
+			if err != nil {
+				return aborted, wrapError(err)
+			}
+		}
+
+		if nextToken == "" {
+			return aborted, nil
+		}
+
+		token = nextToken
+	}
+}
+
+// resumeGCSUpload resumes an upload of startGCSUpload.
+func resumeGCSUpload(
+	ctx context.Context,
+	storage CloudStorage,
+	httpClient *http.Client,
+	bucketName string,
+	token string,
+) (*ResumableUpload, error) {
+	return resumeResumableUpload(ctx, storage, token, &gcsUploadDriver{
+		httpClient: httpClient,
+		bucketName: bucketName,
+	})
+}
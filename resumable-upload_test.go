@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumableUploadS3(t *testing.T) {
+	server := newMultipartServer("")
+	storage := newMultipartStorage(t, server)
+	body := bytes.Repeat([]byte{1}, uploadPartSize+1024)
+
+	upload, err := storage.StartUpload(context.Background(), "key", &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: uploadPartSize},
+	})
+	require.NoError(t, err)
+
+	_, err = upload.Write(body[:uploadPartSize+10])
+	require.NoError(t, err)
+	require.Equal(t, int64(uploadPartSize), upload.Offset())
+
+	upload, err = storage.ResumeUpload(context.Background(), upload.Token())
+	require.NoError(t, err)
+	require.Equal(t, int64(uploadPartSize), upload.Offset())
+
+	_, err = upload.Write(body[upload.Offset():])
+	require.NoError(t, err)
+	require.NoError(t, upload.Close())
+	require.True(t, server.completed)
+	require.Equal(t, map[string]int{"1": uploadPartSize, "2": 1024}, server.partSizes)
+}
+
+func TestResumableUploadS3PartSizeTooSmall(t *testing.T) {
+	storage := newMultipartStorage(t, newMultipartServer(""))
+
+	_, err := storage.StartUpload(context.Background(), "key", &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: 1024},
+	})
+	require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument), "got: %v", err)
+}
+
+func TestAbortStaleUploadsS3(t *testing.T) {
+	var aborted []string
+
+	awsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		switch {
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListMultipartUploadsResult><Bucket>bucket</Bucket>`+
+				`<Upload><Key>stale</Key><UploadId>stale-upload</UploadId><Initiated>%s</Initiated></Upload>`+
+				`<Upload><Key>fresh</Key><UploadId>fresh-upload</UploadId><Initiated>%s</Initiated></Upload>`+
+				`</ListMultipartUploadsResult>`,
+				time.Now().Add(-48*time.Hour).UTC().Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339))
+		case r.Method == http.MethodDelete:
+			aborted = append(aborted, query.Get("uploadId"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer awsServer.Close()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), false, "aws", "bucket",
+		commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        awsServer.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     "key",
+			AWSS3SecretAccessKey: "secret",
+		})
+	require.NoError(t, err)
+
+	count, err := storage.AbortStaleUploads(context.Background(), 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, []string{"stale-upload"}, aborted)
+}
+
+// resumableGCSServer is a fake GCS which commits only half of the first chunk it receives.
+type resumableGCSServer struct {
+	t           *testing.T
+	body        []byte
+	contentType string
+	completed   bool
+	halved      bool
+	// record is the object recording the session, until it's deleted
+	record string
+}
+
+func (s *resumableGCSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "media":
+		require.True(s.t, strings.HasPrefix(r.URL.Query().Get("name"), ".commonblobgo/uploads/"))
+
+		sessionURI, err := ioutil.ReadAll(r.Body)
+		require.NoError(s.t, err)
+		require.Equal(s.t, "http://"+r.Host+"/upload/session", string(sessionURI))
+
+		s.record = r.URL.Query().Get("name")
+		fmt.Fprint(w, `{}`)
+
+		return
+	case r.Method == http.MethodDelete:
+		require.Equal(s.t, "/storage/v1/b/bucket/o/"+url.PathEscape(s.record), r.URL.EscapedPath())
+
+		s.record = ""
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		require.Equal(s.t, "resumable", r.URL.Query().Get("uploadType"))
+		require.Equal(s.t, "key", r.URL.Query().Get("name"))
+
+		var object map[string]interface{}
+		require.NoError(s.t, json.NewDecoder(r.Body).Decode(&object))
+
+		s.contentType, _ = object["contentType"].(string)
+
+		w.Header().Set("Location", "http://"+r.Host+"/upload/session")
+
+		return
+	}
+
+	require.Equal(s.t, "/upload/session", r.URL.Path)
+
+	chunk, err := ioutil.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	// e.g. "bytes 0-1023/*", "bytes */*" or "bytes 1024-2047/2048"
+	contentRange := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	slash := strings.Index(contentRange, "/")
+	total := contentRange[slash+1:]
+
+	if contentRange[:slash] != "*" {
+		start, err := strconv.Atoi(strings.Split(contentRange[:slash], "-")[0])
+		require.NoError(s.t, err)
+		require.Equal(s.t, len(s.body), start)
+
+		if !s.halved {
+			s.halved = true
+			chunk = chunk[:len(chunk)/2]
+		}
+
+		s.body = append(s.body, chunk...)
+	}
+
+	if total != "*" && strconv.Itoa(len(s.body)) == total {
+		s.completed = true
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	if len(s.body) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.body)-1))
+	}
+
+	w.WriteHeader(308)
+}
+
+func TestResumableUploadGCS(t *testing.T) {
+	server := &resumableGCSServer{t: t}
+	gcsServer := httptest.NewServer(server)
+	defer gcsServer.Close()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), true, "gcp", "bucket",
+		commonblobgo.CloudStorageOption{
+			GCPStorageEmulatorHost: strings.TrimPrefix(gcsServer.URL, "http://"),
+		})
+	require.NoError(t, err)
+
+	const partSize = 256 * 1024
+
+	body := bytes.Repeat([]byte("0123456789abcdef"), (2*partSize+64)/16)
+
+	upload, err := storage.StartUpload(context.Background(), "key", &commonblobgo.WriteOptions{
+		// rounded up to 256 KiB
+		Upload: &commonblobgo.UploadOptions{PartSize: partSize - 1},
+	})
+	require.NoError(t, err)
+
+	_, err = upload.Write(body[:partSize+10])
+	require.NoError(t, err)
+	require.Equal(t, int64(partSize), upload.Offset())
+	require.True(t, server.halved)
+
+	upload, err = storage.ResumeUpload(context.Background(), upload.Token())
+	require.NoError(t, err)
+	require.Equal(t, int64(partSize), upload.Offset())
+
+	_, err = upload.Write(body[upload.Offset():])
+	require.NoError(t, err)
+	require.NotEmpty(t, server.record)
+	require.NoError(t, upload.Close())
+	require.True(t, server.completed)
+	require.Equal(t, body, server.body)
+	require.Equal(t, "text/plain; charset=utf-8", server.contentType)
+	// the completed session isn't recorded anymore
+	require.Empty(t, server.record)
+}
+
+func TestAbortStaleUploadsGCS(t *testing.T) {
+	var (
+		cancelled []string
+		deleted   []string
+	)
+
+	gcsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionURI := "http://" + r.Host + "/upload/session-"
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bucket/o":
+			require.Equal(t, ".commonblobgo/uploads/", r.URL.Query().Get("prefix"))
+
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"items": []map[string]interface{}{
+					{"name": ".commonblobgo/uploads/stale", "updated": time.Now().Add(-48 * time.Hour)},
+					{"name": ".commonblobgo/uploads/expired", "updated": time.Now().Add(-48 * time.Hour)},
+					{"name": ".commonblobgo/uploads/fresh", "updated": time.Now()},
+				},
+			}))
+		case r.Method == http.MethodGet:
+			require.Equal(t, "media", r.URL.Query().Get("alt"))
+			fmt.Fprint(w, sessionURI+strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/.commonblobgo/uploads/"))
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/upload/"):
+			cancelled = append(cancelled, r.URL.Path)
+
+			// GCS forgets the expired sessions
+			if r.URL.Path == "/upload/session-expired" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.WriteHeader(499)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer gcsServer.Close()
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), true, "gcp", "bucket",
+		commonblobgo.CloudStorageOption{
+			GCPStorageEmulatorHost: strings.TrimPrefix(gcsServer.URL, "http://"),
+		})
+	require.NoError(t, err)
+
+	count, err := storage.AbortStaleUploads(context.Background(), 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, []string{"/upload/session-expired", "/upload/session-stale"}, cancelled)
+	require.Len(t, deleted, 2)
+
+	// the records can't be written by the callers
+	err = storage.Write(context.Background(), ".commonblobgo/uploads/stale", []byte("uri"), nil)
+	require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument), "got: %v", err)
+}
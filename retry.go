@@ -0,0 +1,416 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RetryPolicy configures the retries of the idempotent operations failed with a transient error:
+// ErrUnavailable, ErrRateLimited, a reset connection or a network timeout.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, 1 disables retries
+	MaxAttempts int `yaml:"maxAttempts" json:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry, it doubles with every next retry
+	InitialBackoff time.Duration `yaml:"initialBackoff" json:"initialBackoff"`
+	// MaxBackoff caps the delay between retries, 0 means no cap
+	MaxBackoff time.Duration `yaml:"maxBackoff" json:"maxBackoff"`
+	// Jitter shortens every delay by a random fraction of it up to this value, from 0 to 1,
+	// so the clients which failed together don't retry together
+	Jitter float64 `yaml:"jitter" json:"jitter"`
+	// Methods overrides the policy of CloudStorage methods by their names, e.g. "Get"
+	Methods map[string]RetryPolicy `yaml:"methods" json:"methods"`
+}
+
+// forMethod returns the policy of the method.
+func (p *RetryPolicy) forMethod(method string) RetryPolicy {
+	if override, ok := p.Methods[method]; ok {
+		return override
+	}
+
+	return *p
+}
+
+// backoff returns the delay before the retry, the first retry is 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+
+	for i := 1; i < retry; i++ {
+		delay *= 2
+
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	if jitter > 0 {
+		delay -= time.Duration(jitter * rand.Float64() * float64(delay)) // nolint:gosec
+	}
+
+	return delay
+}
+
+// isTransientError reports whether the failed call is worth retrying.
+func isTransientError(err error) bool {
+	switch Code(err) {
+	case CodeUnavailable, CodeRateLimited:
+		return true
+	}
+
+	// the AWS SDK reports network failures with its own code and doesn't expose the cause to errors.Is
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == request.ErrCodeRequestError {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// retryingCloudStorage retries the idempotent calls of the wrapped storage.
+// GetWriter, GetWriterWithOptions, CreateBucket and Move aren't retried, a stream can't be replayed, a bucket creation isn't idempotent
+// and a retried Move would fail once the source is deleted.
+// A retried Delete may report ErrNotFound when the failed attempt has deleted the object after all,
+// for the same reason a retried write or delete with preconditions may report ErrPreconditionFailed.
+type retryingCloudStorage struct {
+	storage CloudStorage
+	policy  RetryPolicy
+	logger  Logger
+}
+
+func newRetryingCloudStorage(storage CloudStorage, policy RetryPolicy, logger Logger) *retryingCloudStorage {
+	return &retryingCloudStorage{
+		storage: storage,
+		policy:  policy,
+		logger:  logger,
+	}
+}
+
+// do calls f until it succeeds, fails permanently, runs out of attempts or the context is done.
+// No retry is started when the context deadline comes before the retry would.
+func (s *retryingCloudStorage) do(ctx context.Context, method, key string, f func() error) error {
+	policy := s.policy.forMethod(method)
+
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || err == io.EOF || attempt >= policy.MaxAttempts || ctx.Err() != nil || !isTransientError(err) {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		s.logger.Warn("retrying storage operation",
+			"method", method,
+			"key", key,
+			"attempt", attempt,
+			"delay", delay,
+			"error", err,
+		)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *retryingCloudStorage) List(ctx context.Context, prefix string) *ListIterator {
+	return s.retryList("List", prefix, s.storage.List(ctx, prefix))
+}
+
+func (s *retryingCloudStorage) ListWithOptions(ctx context.Context, options *ListOptions) *ListIterator {
+	var prefix string
+	if options != nil {
+		prefix = options.Prefix
+	}
+
+	return s.retryList("ListWithOptions", prefix, s.storage.ListWithOptions(ctx, options))
+}
+
+// retryList retries the failed Next calls, the iterators keep their position when a page fetch fails.
+func (s *retryingCloudStorage) retryList(method, prefix string, iterator *ListIterator) *ListIterator {
+	return wrapListIterator(iterator, func(ctx context.Context) (*ListObject, error) {
+		var item *ListObject
+
+		err := s.do(ctx, method, prefix, func() error {
+			var err error
+			item, err = iterator.Next(ctx)
+
+			return err
+		})
+
+		return item, err
+	})
+}
+
+func (s *retryingCloudStorage) ListPage(
+	ctx context.Context,
+	opts *ListOptions,
+	pageToken string,
+	pageSize int,
+) ([]*ListObject, string, error) {
+	var (
+		page      []*ListObject
+		nextToken string
+	)
+
+	err := s.do(ctx, "ListPage", listOptionsOrDefault(opts).Prefix, func() error {
+		var err error
+		page, nextToken, err = s.storage.ListPage(ctx, opts, pageToken, pageSize)
+
+		return err
+	})
+
+	return page, nextToken, err
+}
+
+func (s *retryingCloudStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	var body []byte
+
+	err := s.do(ctx, "Get", key, func() error {
+		var err error
+		body, err = s.storage.Get(ctx, key)
+
+		return err
+	})
+
+	return body, err
+}
+
+func (s *retryingCloudStorage) Delete(ctx context.Context, key string) error {
+	return s.do(ctx, "Delete", key, func() error {
+		return s.storage.Delete(ctx, key)
+	})
+}
+
+func (s *retryingCloudStorage) DeleteWithOptions(ctx context.Context, key string, opts *DeleteOptions) error {
+	return s.do(ctx, "DeleteWithOptions", key, func() error {
+		return s.storage.DeleteWithOptions(ctx, key, opts)
+	})
+}
+
+// DeleteMany retries the whole batch, the objects deleted by a failed attempt count as deleted again.
+func (s *retryingCloudStorage) DeleteMany(ctx context.Context, keys []string) ([]DeleteResult, error) {
+	var results []DeleteResult
+
+	err := s.do(ctx, "DeleteMany", "", func() error {
+		var err error
+		results, err = s.storage.DeleteMany(ctx, keys)
+
+		return err
+	})
+
+	return results, err
+}
+
+// DeletePrefix lists and deletes through the decorator, so the single calls are retried.
+func (s *retryingCloudStorage) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+	opts *DeletePrefixOptions,
+) (*DeletePrefixResult, error) {
+	return deletePrefix(ctx, s, prefix, opts)
+}
+
+func (s *retryingCloudStorage) CreateBucket(ctx context.Context, bucketPrefix string, expirationTimeDays int64) error {
+	return s.storage.CreateBucket(ctx, bucketPrefix, expirationTimeDays)
+}
+
+func (s *retryingCloudStorage) describe() (provider, bucket string) {
+	return describe(s.storage)
+}
+
+func (s *retryingCloudStorage) unwrap() CloudStorage {
+	return s.storage
+}
+
+func (s *retryingCloudStorage) Close() {
+	s.storage.Close()
+}
+
+func (s *retryingCloudStorage) GetSignedURL(ctx context.Context, key string, opts *SignedURLOption) (string, error) {
+	var signedURL string
+
+	err := s.do(ctx, "GetSignedURL", key, func() error {
+		var err error
+		signedURL, err = s.storage.GetSignedURL(ctx, key, opts)
+
+		return err
+	})
+
+	return signedURL, err
+}
+
+func (s *retryingCloudStorage) Write(ctx context.Context, key string, body []byte, contentType *string) error {
+	return s.do(ctx, "Write", key, func() error {
+		return s.storage.Write(ctx, key, body, contentType)
+	})
+}
+
+func (s *retryingCloudStorage) WriteWithOptions(ctx context.Context, key string, body []byte, opts *WriteOptions) error {
+	return s.do(ctx, "WriteWithOptions", key, func() error {
+		return s.storage.WriteWithOptions(ctx, key, body, opts)
+	})
+}
+
+func (s *retryingCloudStorage) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	var attrs *Attributes
+
+	err := s.do(ctx, "Attributes", key, func() error {
+		var err error
+		attrs, err = s.storage.Attributes(ctx, key)
+
+		return err
+	})
+
+	return attrs, err
+}
+
+func (s *retryingCloudStorage) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+
+	err := s.do(ctx, "GetReader", key, func() error {
+		var err error
+		reader, err = s.storage.GetReader(ctx, key)
+
+		return err
+	})
+
+	return reader, err
+}
+
+func (s *retryingCloudStorage) GetRangeReader(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+
+	err := s.do(ctx, "GetRangeReader", key, func() error {
+		var err error
+		reader, err = s.storage.GetRangeReader(ctx, key, offset, length)
+
+		return err
+	})
+
+	return reader, err
+}
+
+// Download retries the failed ranges itself, the writes to w of a retried call wouldn't be undone.
+func (s *retryingCloudStorage) Download(ctx context.Context, key string, w io.WriterAt, opts *DownloadOptions) (int64, error) {
+	return s.storage.Download(ctx, key, w, opts)
+}
+
+func (s *retryingCloudStorage) GetWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	return s.storage.GetWriter(ctx, key)
+}
+
+func (s *retryingCloudStorage) GetWriterWithOptions(ctx context.Context, key string, opts *WriteOptions) (io.WriteCloser, error) {
+	return s.storage.GetWriterWithOptions(ctx, key, opts)
+}
+
+func (s *retryingCloudStorage) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+
+	err := s.do(ctx, "Exists", key, func() error {
+		var err error
+		exists, err = s.storage.Exists(ctx, key)
+
+		return err
+	})
+
+	return exists, err
+}
+
+func (s *retryingCloudStorage) Copy(ctx context.Context, srcKey, dstKey string, opts *CopyOptions) error {
+	return s.do(ctx, "Copy", srcKey, func() error {
+		return s.storage.Copy(ctx, srcKey, dstKey, opts)
+	})
+}
+
+func (s *retryingCloudStorage) Move(ctx context.Context, srcKey, dstKey string) error {
+	return s.storage.Move(ctx, srcKey, dstKey)
+}
+
+func (s *retryingCloudStorage) UpdateAttributes(ctx context.Context, key string, patch *AttributesPatch) (*Attributes, error) {
+	var attrs *Attributes
+
+	err := s.do(ctx, "UpdateAttributes", key, func() error {
+		var err error
+		attrs, err = s.storage.UpdateAttributes(ctx, key, patch)
+
+		return err
+	})
+
+	return attrs, err
+}
+
+// StartUpload isn't retried, it doesn't call the service, the parts are committed by the upload itself.
+func (s *retryingCloudStorage) StartUpload(ctx context.Context, key string, opts *WriteOptions) (*ResumableUpload, error) {
+	return s.storage.StartUpload(ctx, key, opts)
+}
+
+func (s *retryingCloudStorage) ResumeUpload(ctx context.Context, token string) (*ResumableUpload, error) {
+	var upload *ResumableUpload
+
+	err := s.do(ctx, "ResumeUpload", "", func() error {
+		var err error
+		upload, err = s.storage.ResumeUpload(ctx, token)
+
+		return err
+	})
+
+	return upload, err
+}
+
+func (s *retryingCloudStorage) AbortStaleUploads(ctx context.Context, ttl time.Duration) (int, error) {
+	var aborted int
+
+	err := s.do(ctx, "AbortStaleUploads", "", func() error {
+		var err error
+		aborted, err = s.storage.AbortStaleUploads(ctx, ttl)
+
+		return err
+	})
+
+	return aborted, err
+}
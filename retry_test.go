@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyCloudStorage fails the calls with the queued errors before passing them to the memory storage.
+type flakyCloudStorage struct {
+	*MemoryCloudStorage
+	failures []error
+	calls    int
+}
+
+func (s *flakyCloudStorage) fail() error {
+	s.calls++
+
+	if len(s.failures) == 0 {
+		return nil
+	}
+
+	err := s.failures[0]
+	s.failures = s.failures[1:]
+
+	return err
+}
+
+func (s *flakyCloudStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.MemoryCloudStorage.Get(ctx, key)
+}
+
+func (s *flakyCloudStorage) Write(ctx context.Context, key string, body []byte, contentType *string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+
+	return s.MemoryCloudStorage.Write(ctx, key, body, contentType)
+}
+
+func (s *flakyCloudStorage) GetWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.MemoryCloudStorage.GetWriter(ctx, key)
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	unavailable := newError(CodeUnavailable, errors.New("503"))
+
+	newStorage := func(policy RetryPolicy, failures ...error) (*flakyCloudStorage, CloudStorage) {
+		flaky := &flakyCloudStorage{
+			MemoryCloudStorage: NewMemoryCloudStorage("bucket"),
+			failures:           failures,
+		}
+
+		return flaky, newRetryingCloudStorage(flaky, policy, NopLogger())
+	}
+
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Jitter:         0.5,
+		Methods: map[string]RetryPolicy{
+			"Write": {MaxAttempts: 1},
+		},
+	}
+
+	// transient errors are retried
+	flaky, storage := newStorage(policy, unavailable, syscall.ECONNRESET)
+	require.NoError(t, flaky.MemoryCloudStorage.Write(ctx, "key", []byte("body"), nil))
+
+	body, err := storage.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, "body", string(body))
+	require.Equal(t, 3, flaky.calls)
+
+	// up to MaxAttempts
+	flaky, storage = newStorage(policy, unavailable, unavailable, unavailable, unavailable)
+	_, err = storage.Get(ctx, "key")
+	require.True(t, errors.Is(err, ErrUnavailable))
+	require.Equal(t, 3, flaky.calls)
+
+	// permanent errors aren't retried
+	flaky, storage = newStorage(policy)
+	_, err = storage.Get(ctx, "key")
+	require.True(t, errors.Is(err, ErrNotFound))
+	require.Equal(t, 1, flaky.calls)
+
+	// per-method overrides
+	flaky, storage = newStorage(policy, unavailable)
+	require.Error(t, storage.Write(ctx, "key", []byte("body"), nil))
+	require.Equal(t, 1, flaky.calls)
+
+	// streams aren't retried
+	flaky, storage = newStorage(policy, unavailable)
+	_, err = storage.GetWriter(ctx, "key")
+	require.Error(t, err)
+	require.Equal(t, 1, flaky.calls)
+
+	// no retry is started after the deadline
+	flaky, storage = newStorage(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}, unavailable)
+
+	deadlineCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	_, err = storage.Get(deadlineCtx, "key")
+	require.True(t, errors.Is(err, ErrUnavailable))
+	require.Equal(t, 1, flaky.calls)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+
+	require.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	require.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	require.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	require.Equal(t, time.Second, policy.backoff(5))
+	require.Equal(t, time.Second, policy.backoff(100))
+
+	policy.Jitter = 0.5
+
+	for i := 0; i < 100; i++ {
+		delay := policy.backoff(2)
+		require.True(t, delay > 100*time.Millisecond && delay <= 200*time.Millisecond, delay)
+	}
+}
@@ -0,0 +1,1124 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+// Package storagetest provides the conformance test suite every commonblobgo.CloudStorage implementation passes.
+// Use it to check wrappers and custom implementations against the behavior of the built-in providers:
+//
+//	func TestMyStorage(t *testing.T) {
+//		storagetest.Run(t, func() commonblobgo.CloudStorage {
+//			return NewMyStorage()
+//		})
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // nolint:gosec
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+// Run runs the conformance suite against the storage created by newStorage.
+// newStorage is called once, the storage is closed when the suite finishes.
+func Run(t *testing.T, newStorage func() commonblobgo.CloudStorage) {
+	suite.Run(t, &Suite{
+		NewStorage: newStorage,
+	})
+}
+
+// Suite is the conformance test suite, it can be embedded to add implementation specific tests.
+// All blobs are written under a random prefix, so the suite can run against a shared bucket.
+type Suite struct {
+	suite.Suite
+
+	// NewStorage creates the storage under test
+	NewStorage func() commonblobgo.CloudStorage
+
+	ctx          context.Context
+	storage      commonblobgo.CloudStorage
+	bucketPrefix string
+}
+
+func (s *Suite) SetupSuite() {
+	s.Require().NotNil(s.NewStorage, "NewStorage is required")
+
+	s.ctx = context.Background()
+	s.bucketPrefix = fmt.Sprintf("test_%s", uuid.New().String())
+
+	s.storage = s.NewStorage()
+	s.Require().NotNil(s.storage)
+
+	err := s.storage.CreateBucket(s.ctx, s.bucketPrefix, 1)
+	s.Require().NoError(err)
+}
+
+func (s *Suite) TearDownSuite() {
+	if s.storage != nil {
+		s.storage.Close()
+	}
+}
+
+// Storage returns the storage under test.
+func (s *Suite) Storage() commonblobgo.CloudStorage {
+	return s.storage
+}
+
+// Context returns the context the suite uses for every call.
+func (s *Suite) Context() context.Context {
+	return s.ctx
+}
+
+// GenerateFileName returns a new unique key under the prefix of the suite.
+func (s *Suite) GenerateFileName() string {
+	return fmt.Sprintf("%s/%s.json", s.bucketPrefix, uuid.New().String())
+}
+
+// BucketPrefix returns the random prefix all the blobs of the suite are written under.
+func (s *Suite) BucketPrefix() string {
+	return s.bucketPrefix
+}
+
+func (s *Suite) write(key string, body []byte) {
+	err := s.storage.Write(s.ctx, key, body, nil)
+	s.Require().NoError(err)
+}
+
+func (s *Suite) readAll(reader io.ReadCloser) string {
+	body, err := ioutil.ReadAll(reader)
+	s.Require().NoError(err)
+	s.Require().NoError(reader.Close())
+
+	return string(body)
+}
+
+func (s *Suite) listAll(iter *commonblobgo.ListIterator) []*commonblobgo.ListObject {
+	var items []*commonblobgo.ListObject
+
+	for {
+		item, err := iter.Next(s.ctx)
+		if err == io.EOF {
+			break
+		}
+
+		s.Require().NoError(err)
+
+		items = append(items, item)
+	}
+
+	// the iterator stays exhausted
+	_, err := iter.Next(s.ctx)
+	s.Require().Equal(io.EOF, err)
+
+	return items
+}
+
+func (s *Suite) TestCreateBucket() {
+	prefix := uuid.New().String()
+
+	err := s.storage.CreateBucket(s.ctx, prefix, 1)
+	s.Require().NoError(err)
+}
+
+func (s *Suite) TestWriteAndGet() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+
+	s.write(fileName, body)
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(storedBody)
+
+	s.Require().JSONEq(string(body), string(storedBody))
+}
+
+func (s *Suite) TestWriteOverwrites() {
+	fileName := s.GenerateFileName()
+
+	s.write(fileName, []byte(`{"key": "value1"}`))
+	s.write(fileName, []byte(`{"key": "value2"}`))
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().JSONEq(`{"key": "value2"}`, string(storedBody))
+}
+
+func (s *Suite) TestWriteAndGetUsingReaderAndWriter() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value", "key2": "value2"}`)
+
+	writer, err := s.storage.GetWriter(s.ctx, fileName)
+	s.Require().NoError(err)
+
+	_, err = writer.Write(body[:10])
+	s.Require().NoError(err)
+
+	_, err = writer.Write(body[10:20])
+	s.Require().NoError(err)
+
+	_, err = writer.Write(body[20:])
+	s.Require().NoError(err)
+
+	err = writer.Close()
+	s.Require().NoError(err)
+
+	reader, err := s.storage.GetReader(s.ctx, fileName)
+	s.Require().NoError(err)
+
+	s.Require().JSONEq(string(body), s.readAll(reader))
+}
+
+func (s *Suite) TestWriteAndGetUsingRangeReader() {
+	fileName := s.GenerateFileName()
+	body := []byte(`0123456789`)
+
+	writer, err := s.storage.GetWriter(s.ctx, fileName)
+	s.Require().NoError(err)
+
+	_, err = writer.Write(body[:10])
+	s.Require().NoError(err)
+
+	err = writer.Close()
+	s.Require().NoError(err)
+
+	for _, tc := range []struct {
+		offset, length int64
+		expected       string
+	}{
+		{offset: 0, length: 5, expected: "01234"},
+		{offset: 5, length: 5, expected: "56789"},
+		{offset: 3, length: 2, expected: "34"},
+		// a negative length reads until the end of the blob
+		{offset: 0, length: -1, expected: "0123456789"},
+		{offset: 7, length: -1, expected: "789"},
+		// the range is cut at the end of the blob
+		{offset: 8, length: 10, expected: "89"},
+	} {
+		rangeReader, err := s.storage.GetRangeReader(s.ctx, fileName, tc.offset, tc.length)
+		s.Require().NoError(err, "offset: %d, length: %d", tc.offset, tc.length)
+
+		s.Require().Equal(tc.expected, s.readAll(rangeReader), "offset: %d, length: %d", tc.offset, tc.length)
+	}
+}
+
+func (s *Suite) TestDownload() {
+	fileName := s.GenerateFileName()
+	body := make([]byte, 3*1024*1024+100)
+
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+
+	s.write(fileName, body)
+
+	file, err := ioutil.TempFile("", "common-blob-go")
+	s.Require().NoError(err)
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	var progress []int64
+
+	written, err := s.storage.Download(s.ctx, fileName, file, &commonblobgo.DownloadOptions{
+		PartSize:    1024 * 1024,
+		Concurrency: 3,
+		Progress: func(downloaded int64) {
+			progress = append(progress, downloaded)
+		},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(int64(len(body)), written)
+	s.Require().Equal([]int64{1024 * 1024, 2 * 1024 * 1024, 3 * 1024 * 1024, int64(len(body))}, progress)
+
+	downloaded, err := ioutil.ReadFile(file.Name())
+	s.Require().NoError(err)
+	s.Require().True(bytes.Equal(body, downloaded))
+
+	// an empty blob has no ranges
+	emptyFileName := s.GenerateFileName()
+	s.write(emptyFileName, []byte{})
+
+	written, err = s.storage.Download(s.ctx, emptyFileName, file, nil)
+	s.Require().NoError(err)
+	s.Require().Equal(int64(0), written)
+
+	_, err = s.storage.Download(s.ctx, s.GenerateFileName(), file, nil)
+	s.requireNotFound(err)
+}
+
+func (s *Suite) TestWriteAndList() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+
+	s.write(fileName, body)
+
+	var fileFound bool
+
+	for _, item := range s.listAll(s.storage.List(s.ctx, s.bucketPrefix)) {
+		s.Require().False(item.IsDir)
+
+		if item.Key == fileName {
+			fileFound = true
+
+			s.Require().Equal(int64(len(body)), item.Size)
+		}
+	}
+
+	s.Require().True(fileFound)
+}
+
+func (s *Suite) TestListIsSortedAndFilteredByPrefix() {
+	prefix := s.bucketPrefix + "/sorted_" + uuid.New().String() + "/"
+	keys := []string{prefix + "b", prefix + "a", prefix + "c/d"}
+
+	for _, key := range keys {
+		s.write(key, []byte(`{}`))
+	}
+
+	s.write(s.bucketPrefix+"/other_"+uuid.New().String(), []byte(`{}`))
+
+	var listed []string
+	for _, item := range s.listAll(s.storage.List(s.ctx, prefix)) {
+		listed = append(listed, item.Key)
+	}
+
+	s.Require().Equal([]string{prefix + "a", prefix + "b", prefix + "c/d"}, listed)
+}
+
+func (s *Suite) TestListStartAfter() {
+	prefix := s.bucketPrefix + "/after_" + uuid.New().String() + "/"
+
+	for _, key := range []string{"a", "b", "c/d", "c/e", "f"} {
+		s.write(prefix+key, []byte(`{}`))
+	}
+
+	var listed []string
+	for _, item := range s.listAll(s.storage.ListWithOptions(s.ctx, &commonblobgo.ListOptions{
+		Prefix:     prefix,
+		StartAfter: prefix + "b",
+	})) {
+		listed = append(listed, item.Key)
+	}
+
+	s.Require().Equal([]string{prefix + "c/d", prefix + "c/e", prefix + "f"}, listed)
+
+	// the "directory" holding StartAfter is listed
+	listed = nil
+	for _, item := range s.listAll(s.storage.ListWithOptions(s.ctx, &commonblobgo.ListOptions{
+		Prefix:     prefix,
+		Delimiter:  "/",
+		StartAfter: prefix + "c/d",
+	})) {
+		listed = append(listed, item.Key)
+	}
+
+	s.Require().Equal([]string{prefix + "c/", prefix + "f"}, listed)
+}
+
+func (s *Suite) TestListWithFilters() {
+	prefix := s.bucketPrefix + "/filters_" + uuid.New().String() + "/"
+
+	s.write(prefix+"a.json", []byte(`{}`))
+	s.write(prefix+"b.json", []byte(`{"key": "value"}`))
+	s.write(prefix+"c.csv", []byte(`a,b`))
+	s.write(prefix+"tmp/d.json", []byte(`{}`))
+	s.write(prefix+"users/1/profile.json", []byte(`{}`))
+
+	for _, tc := range []struct {
+		name     string
+		options  commonblobgo.ListOptions
+		expected []string
+	}{
+		{name: "suffix", options: commonblobgo.ListOptions{Suffix: ".json"},
+			expected: []string{"a.json", "b.json", "tmp/d.json", "users/1/profile.json"}},
+		{name: "glob", options: commonblobgo.ListOptions{Glob: prefix + "users/*/profile.json"},
+			expected: []string{"users/1/profile.json"}},
+		{name: "regexp", options: commonblobgo.ListOptions{Regexp: regexp.MustCompile(`/[ab]\.`)},
+			expected: []string{"a.json", "b.json"}},
+		{name: "size", options: commonblobgo.ListOptions{MinSize: 3, MaxSize: 10},
+			expected: []string{"c.csv"}},
+		{name: "excluded prefixes", options: commonblobgo.ListOptions{ExcludePrefixes: []string{prefix + "tmp/", prefix + "users/"}},
+			expected: []string{"a.json", "b.json", "c.csv"}},
+		{name: "modified", options: commonblobgo.ListOptions{
+			ModifiedAfter:  time.Now().Add(-time.Hour),
+			ModifiedBefore: time.Now().Add(time.Hour),
+			MaxResults:     2,
+		}, expected: []string{"a.json", "b.json"}},
+		{name: "modified later", options: commonblobgo.ListOptions{ModifiedAfter: time.Now().Add(time.Hour)}},
+		// the "directories" are only filtered by the excluded prefixes
+		{name: "directories", options: commonblobgo.ListOptions{Delimiter: "/", Suffix: ".csv", ExcludePrefixes: []string{prefix + "tmp/"}},
+			expected: []string{"c.csv", "users/"}},
+	} {
+		options := tc.options
+		options.Prefix = prefix
+
+		var listed []string
+		for _, item := range s.listAll(s.storage.ListWithOptions(s.ctx, &options)) {
+			listed = append(listed, strings.TrimPrefix(item.Key, prefix))
+		}
+
+		s.Require().Equal(tc.expected, listed, tc.name)
+	}
+
+	_, err := s.storage.ListWithOptions(s.ctx, &commonblobgo.ListOptions{Prefix: prefix, Glob: "["}).Next(s.ctx)
+	s.Require().True(errors.Is(err, commonblobgo.ErrInvalidArgument), "expected ErrInvalidArgument, got: %v", err)
+}
+
+func (s *Suite) TestListPage() {
+	prefix := s.bucketPrefix + "/page_" + uuid.New().String() + "/"
+
+	for _, key := range []string{"a", "b", "c/d", "c/e", "f"} {
+		s.write(prefix+key, []byte(`{}`))
+	}
+
+	for _, tc := range []struct {
+		delimiter string
+		expected  []string
+	}{
+		{delimiter: "", expected: []string{"a", "b", "c/d", "c/e", "f"}},
+		{delimiter: "/", expected: []string{"a", "b", "c/", "f"}},
+	} {
+		var (
+			listed []string
+			token  string
+		)
+
+		for {
+			page, nextToken, err := s.storage.ListPage(s.ctx, &commonblobgo.ListOptions{
+				Prefix:    prefix,
+				Delimiter: tc.delimiter,
+			}, token, 2)
+			s.Require().NoError(err)
+			s.Require().True(len(page) <= 2)
+
+			for _, item := range page {
+				listed = append(listed, strings.TrimPrefix(item.Key, prefix))
+			}
+
+			if nextToken == "" {
+				break
+			}
+
+			token = nextToken
+		}
+
+		s.Require().Equal(tc.expected, listed, "delimiter: %q", tc.delimiter)
+	}
+}
+
+func (s *Suite) TestListEmptyPrefix() {
+	items := s.listAll(s.storage.List(s.ctx, s.bucketPrefix+"/missing_"+uuid.New().String()))
+	s.Require().Empty(items)
+}
+
+func (s *Suite) TestWriteAndListWithOptions() {
+	// file without directory
+	fileNameWithoutDirectory := s.bucketPrefix + "/" + uuid.New().String()
+	s.write(fileNameWithoutDirectory, []byte(`{"key": "value1"}`))
+
+	// files within directory
+	directory := s.bucketPrefix + "/directory/"
+	s.write(directory+uuid.New().String(), []byte(`{"key": "value2"}`))
+	s.write(directory+uuid.New().String(), []byte(`{"key": "value3"}`))
+
+	items := s.listAll(s.storage.ListWithOptions(s.ctx, &commonblobgo.ListOptions{
+		Prefix:    s.bucketPrefix + "/",
+		Delimiter: "/",
+	}))
+
+	var fileFound, directoryFound int
+
+	for _, item := range items {
+		if item.Key == fileNameWithoutDirectory {
+			s.Require().False(item.IsDir)
+			fileFound++
+		}
+
+		if item.Key == directory {
+			// multiple blobs in a "directory" are returned as a single result
+			s.Require().True(item.IsDir)
+			directoryFound++
+		}
+	}
+
+	s.Require().Equal(1, fileFound)
+	s.Require().Equal(1, directoryFound)
+}
+
+func (s *Suite) TestAttributes() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+	contentType := "application/json"
+
+	err := s.storage.Write(s.ctx, fileName, body, &contentType)
+	s.Require().NoError(err)
+
+	attrs, err := s.storage.Attributes(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(int64(len(body)), attrs.Size)
+	s.Require().Equal(contentType, attrs.ContentType)
+	s.Require().True(attrs.ModTime.Before(time.Now()))
+
+	if attrs.MD5 != nil {
+		sum := md5.Sum(body) // nolint:gosec
+		s.Require().Equal(sum[:], attrs.MD5)
+	}
+}
+
+func (s *Suite) TestWriteWithOptions() {
+	body := []byte(`{"key": "value"}`)
+	sum := md5.Sum(body) // nolint:gosec
+	opts := &commonblobgo.WriteOptions{
+		CacheControl:       "no-cache",
+		ContentDisposition: "attachment",
+		// identity keeps the services from transcoding the content
+		ContentEncoding: "identity",
+		ContentLanguage: "en",
+		ContentType:     "application/json",
+		Metadata:        map[string]string{"state": "written"},
+		ContentMD5:      sum[:],
+	}
+
+	requireAttributes := func(fileName string) {
+		storedBody, err := s.storage.Get(s.ctx, fileName)
+		s.Require().NoError(err)
+		s.Require().Equal(body, storedBody)
+
+		attrs, err := s.storage.Attributes(s.ctx, fileName)
+		s.Require().NoError(err)
+		s.Require().Equal(opts.CacheControl, attrs.CacheControl)
+		s.Require().Equal(opts.ContentDisposition, attrs.ContentDisposition)
+		s.Require().Equal(opts.ContentEncoding, attrs.ContentEncoding)
+		s.Require().Equal(opts.ContentLanguage, attrs.ContentLanguage)
+		s.Require().Equal(opts.ContentType, attrs.ContentType)
+		s.Require().Equal(opts.Metadata, attrs.Metadata)
+	}
+
+	fileName := s.GenerateFileName()
+
+	err := s.storage.WriteWithOptions(s.ctx, fileName, body, opts)
+	s.Require().NoError(err)
+	requireAttributes(fileName)
+
+	fileName = s.GenerateFileName()
+
+	writer, err := s.storage.GetWriterWithOptions(s.ctx, fileName, opts)
+	s.Require().NoError(err)
+
+	_, err = writer.Write(body)
+	s.Require().NoError(err)
+	s.Require().NoError(writer.Close())
+	requireAttributes(fileName)
+}
+
+func (s *Suite) TestWriteWithOptionsContentMD5Mismatch() {
+	body := []byte(`{"key": "value"}`)
+	sum := md5.Sum([]byte("other")) // nolint:gosec
+	opts := &commonblobgo.WriteOptions{ContentMD5: sum[:]}
+
+	requireMismatch := func(fileName string, err error) {
+		s.Require().Error(err)
+		s.Require().True(errors.Is(err, commonblobgo.ErrInvalidArgument), "expected ErrInvalidArgument, got: %v", err)
+
+		exists, err := s.storage.Exists(s.ctx, fileName)
+		s.Require().NoError(err)
+		s.Require().False(exists)
+	}
+
+	fileName := s.GenerateFileName()
+	err := s.storage.WriteWithOptions(s.ctx, fileName, body, opts)
+	requireMismatch(fileName, err)
+
+	fileName = s.GenerateFileName()
+
+	writer, err := s.storage.GetWriterWithOptions(s.ctx, fileName, opts)
+	s.Require().NoError(err)
+
+	_, err = writer.Write(body)
+	s.Require().NoError(err)
+	requireMismatch(fileName, writer.Close())
+}
+
+func (s *Suite) TestWriteWithUploadOptions() {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 3*1024*1024/16)
+
+	var progress []int64
+
+	opts := &commonblobgo.WriteOptions{
+		ContentType: "application/octet-stream",
+		Upload: &commonblobgo.UploadOptions{
+			Concurrency:   2,
+			MaxBufferSize: 16 * 1024 * 1024,
+			Progress: func(written int64) {
+				progress = append(progress, written)
+			},
+		},
+	}
+
+	requireUploaded := func(fileName string) {
+		storedBody, err := s.storage.Get(s.ctx, fileName)
+		s.Require().NoError(err)
+		s.Require().Equal(body, storedBody)
+
+		s.Require().NotEmpty(progress)
+		s.Require().Equal(int64(len(body)), progress[len(progress)-1])
+
+		for i := 1; i < len(progress); i++ {
+			s.Require().Greater(progress[i], progress[i-1])
+		}
+
+		progress = nil
+	}
+
+	fileName := s.GenerateFileName()
+
+	err := s.storage.WriteWithOptions(s.ctx, fileName, body, opts)
+	s.Require().NoError(err)
+	requireUploaded(fileName)
+
+	fileName = s.GenerateFileName()
+
+	writer, err := s.storage.GetWriterWithOptions(s.ctx, fileName, opts)
+	s.Require().NoError(err)
+
+	_, err = io.Copy(writer, bytes.NewReader(body))
+	s.Require().NoError(err)
+	s.Require().NoError(writer.Close())
+	requireUploaded(fileName)
+
+	fileName = s.GenerateFileName()
+
+	err = s.storage.WriteWithOptions(s.ctx, fileName, body, &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: -1},
+	})
+	s.Require().True(errors.Is(err, commonblobgo.ErrInvalidArgument), "expected ErrInvalidArgument, got: %v", err)
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+}
+
+func (s *Suite) TestResumableUpload() {
+	// the smallest S3 part, a multiple of 256 KiB as GCS requires
+	const partSize = 5 * 1024 * 1024
+
+	body := bytes.Repeat([]byte("0123456789abcdef"), (partSize+300*1024)/16)
+	fileName := s.GenerateFileName()
+
+	upload, err := s.storage.StartUpload(s.ctx, fileName, &commonblobgo.WriteOptions{
+		ContentType: "application/octet-stream",
+		Metadata:    map[string]string{"state": "uploaded"},
+		Upload:      &commonblobgo.UploadOptions{PartSize: partSize},
+	})
+	s.Require().NoError(err)
+
+	_, err = upload.Write(body[:partSize+100*1024])
+	s.Require().NoError(err)
+	s.Require().Equal(int64(partSize), upload.Offset())
+
+	// the process is gone, a new one continues from the committed content
+	token := upload.Token()
+
+	upload, err = s.storage.ResumeUpload(s.ctx, token)
+	s.Require().NoError(err)
+	s.Require().Equal(fileName, upload.Key())
+	s.Require().Equal(int64(partSize), upload.Offset())
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+
+	_, err = upload.Write(body[upload.Offset():])
+	s.Require().NoError(err)
+	s.Require().NoError(upload.Close())
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(body, storedBody)
+
+	attrs, err := s.storage.Attributes(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal("application/octet-stream", attrs.ContentType)
+	s.Require().Equal(map[string]string{"state": "uploaded"}, attrs.Metadata)
+
+	// an empty upload creates an empty blob
+	fileName = s.GenerateFileName()
+
+	upload, err = s.storage.StartUpload(s.ctx, fileName, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(upload.Close())
+
+	storedBody, err = s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Empty(storedBody)
+
+	_, err = s.storage.ResumeUpload(s.ctx, "invalid")
+	s.Require().True(errors.Is(err, commonblobgo.ErrInvalidArgument), "expected ErrInvalidArgument, got: %v", err)
+}
+
+func (s *Suite) TestResumableUploadAbort() {
+	const partSize = 5 * 1024 * 1024
+
+	fileName := s.GenerateFileName()
+
+	upload, err := s.storage.StartUpload(s.ctx, fileName, &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: partSize},
+	})
+	s.Require().NoError(err)
+
+	_, err = upload.Write(bytes.Repeat([]byte{1}, partSize))
+	s.Require().NoError(err)
+	s.Require().NoError(upload.Abort())
+
+	_, err = upload.Write([]byte{1})
+	s.Require().Error(err)
+	s.Require().Error(upload.Close())
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+}
+
+func (s *Suite) TestResumableUploadConcurrentWrite() {
+	const partSize = 5 * 1024 * 1024
+
+	body := bytes.Repeat([]byte{1}, partSize+1)
+	fileName := s.GenerateFileName()
+
+	upload, err := s.storage.StartUpload(s.ctx, fileName, &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: partSize},
+	})
+	s.Require().NoError(err)
+
+	_, err = upload.Write(body[:partSize])
+	s.Require().NoError(err)
+
+	// another writer of the key doesn't discard the committed parts
+	s.Require().NoError(s.storage.Write(s.ctx, fileName, []byte("other"), nil))
+
+	upload, err = s.storage.ResumeUpload(s.ctx, upload.Token())
+	s.Require().NoError(err)
+	s.Require().Equal(int64(partSize), upload.Offset())
+
+	_, err = upload.Write(body[partSize:])
+	s.Require().NoError(err)
+	s.Require().NoError(upload.Close())
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(body, storedBody)
+}
+
+func (s *Suite) TestAbortStaleUploads() {
+	const partSize = 5 * 1024 * 1024
+
+	upload, err := s.storage.StartUpload(s.ctx, s.GenerateFileName(), &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: partSize},
+	})
+	s.Require().NoError(err)
+
+	_, err = upload.Write(bytes.Repeat([]byte{1}, partSize))
+	s.Require().NoError(err)
+
+	// the uploads of a week ago are kept
+	_, err = s.storage.AbortStaleUploads(s.ctx, 7*24*time.Hour)
+	s.Require().NoError(err)
+
+	_, err = s.storage.ResumeUpload(s.ctx, upload.Token())
+	s.Require().NoError(err)
+
+	// the start times of the uploads have a precision of a second
+	time.Sleep(time.Second)
+
+	aborted, err := s.storage.AbortStaleUploads(s.ctx, 0)
+	s.Require().NoError(err)
+	s.Require().GreaterOrEqual(aborted, 1)
+
+	_, err = s.storage.ResumeUpload(s.ctx, upload.Token())
+	s.Require().True(errors.Is(err, commonblobgo.ErrNotFound), "expected ErrNotFound, got: %v", err)
+}
+
+func (s *Suite) TestWriteIfNotExists() {
+	fileName := s.GenerateFileName()
+	opts := &commonblobgo.WriteOptions{
+		Preconditions: &commonblobgo.Preconditions{DoesNotExist: true},
+	}
+
+	err := s.storage.WriteWithOptions(s.ctx, fileName, []byte(`{"writer": 1}`), opts)
+	s.Require().NoError(err)
+
+	err = s.storage.WriteWithOptions(s.ctx, fileName, []byte(`{"writer": 2}`), opts)
+	s.requirePreconditionFailed(err)
+
+	writer, err := s.storage.GetWriterWithOptions(s.ctx, fileName, opts)
+	if err == nil {
+		_, err = writer.Write([]byte(`{"writer": 3}`))
+		s.Require().NoError(err)
+
+		err = writer.Close()
+	}
+
+	s.requirePreconditionFailed(err)
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(`{"writer": 1}`, string(storedBody))
+}
+
+func (s *Suite) TestWriteAndDeleteIfMatch() {
+	fileName := s.GenerateFileName()
+
+	s.write(fileName, []byte(`{"version": 1}`))
+
+	attrs, err := s.storage.Attributes(s.ctx, fileName)
+	s.Require().NoError(err)
+
+	stale := s.matchPreconditions(attrs)
+
+	err = s.storage.WriteWithOptions(s.ctx, fileName, []byte(`{"version": 2}`), &commonblobgo.WriteOptions{
+		Preconditions: stale,
+	})
+	s.Require().NoError(err)
+
+	// the object has changed since the attributes were read
+	err = s.storage.WriteWithOptions(s.ctx, fileName, []byte(`{"version": 3}`), &commonblobgo.WriteOptions{
+		Preconditions: stale,
+	})
+	s.requirePreconditionFailed(err)
+
+	err = s.storage.DeleteWithOptions(s.ctx, fileName, &commonblobgo.DeleteOptions{Preconditions: stale})
+	s.requirePreconditionFailed(err)
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(`{"version": 2}`, string(storedBody))
+
+	attrs, err = s.storage.Attributes(s.ctx, fileName)
+	s.Require().NoError(err)
+
+	err = s.storage.DeleteWithOptions(s.ctx, fileName, &commonblobgo.DeleteOptions{
+		Preconditions: s.matchPreconditions(attrs),
+	})
+	s.Require().NoError(err)
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+}
+
+// matchPreconditions returns the preconditions matching the attributes, generations are preferred over ETags.
+func (s *Suite) matchPreconditions(attrs *commonblobgo.Attributes) *commonblobgo.Preconditions {
+	if attrs.Generation != 0 {
+		return &commonblobgo.Preconditions{GenerationMatch: attrs.Generation}
+	}
+
+	s.Require().NotEmpty(attrs.ETag, "either Generation or ETag is expected")
+
+	return &commonblobgo.Preconditions{ETagMatch: attrs.ETag}
+}
+
+// requirePreconditionFailed checks the error is the typed precondition error.
+func (s *Suite) requirePreconditionFailed(err error) {
+	s.Require().Error(err)
+	s.Require().True(errors.Is(err, commonblobgo.ErrPreconditionFailed), "expected ErrPreconditionFailed, got: %v", err)
+
+	var preconditionErr *commonblobgo.PreconditionError
+	s.Require().True(errors.As(err, &preconditionErr), "expected *PreconditionError, got: %T", err)
+}
+
+func (s *Suite) TestExists() {
+	fileName := s.GenerateFileName()
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+
+	s.write(fileName, []byte(`{"key": "value"}`))
+
+	exists, err = s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().True(exists)
+}
+
+func (s *Suite) TestNotFound() {
+	fileName := s.GenerateFileName()
+
+	_, err := s.storage.Get(s.ctx, fileName)
+	s.requireNotFound(err)
+
+	_, err = s.storage.GetReader(s.ctx, fileName)
+	s.requireNotFound(err)
+
+	_, err = s.storage.GetRangeReader(s.ctx, fileName, 0, 1)
+	s.requireNotFound(err)
+
+	_, err = s.storage.Attributes(s.ctx, fileName)
+	s.requireNotFound(err)
+
+	err = s.storage.Delete(s.ctx, fileName)
+	s.requireNotFound(err)
+
+	err = s.storage.Copy(s.ctx, fileName, s.GenerateFileName(), nil)
+	s.requireNotFound(err)
+
+	err = s.storage.Move(s.ctx, fileName, s.GenerateFileName())
+	s.requireNotFound(err)
+
+	_, err = s.storage.UpdateAttributes(s.ctx, fileName, &commonblobgo.AttributesPatch{
+		Metadata: map[string]string{"state": "delivered"},
+	})
+	s.requireNotFound(err)
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+}
+
+// requireNotFound checks the error is mapped onto the provider independent one.
+func (s *Suite) requireNotFound(err error) {
+	s.Require().Error(err)
+	s.Require().True(errors.Is(err, commonblobgo.ErrNotFound), "expected ErrNotFound, got: %v", err)
+	s.Require().Equal(commonblobgo.CodeNotFound, commonblobgo.Code(err))
+}
+
+func (s *Suite) TestDelete() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+
+	s.write(fileName, body)
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(storedBody)
+
+	err = s.storage.Delete(s.ctx, fileName)
+	s.Require().NoError(err)
+
+	_, err = s.storage.Get(s.ctx, fileName)
+	s.Require().Error(err)
+
+	exists, err := s.storage.Exists(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+}
+
+func (s *Suite) TestDeleteMany() {
+	keys := []string{s.GenerateFileName(), s.GenerateFileName(), s.GenerateFileName()}
+
+	for _, key := range keys[:2] {
+		s.write(key, []byte(`{"key": "value"}`))
+	}
+
+	// the last key doesn't exist
+	results, err := s.storage.DeleteMany(s.ctx, keys)
+	s.Require().NoError(err)
+	s.Require().Len(results, len(keys))
+
+	for i, result := range results {
+		s.Require().Equal(keys[i], result.Key)
+		s.Require().NoError(result.Err)
+
+		exists, err := s.storage.Exists(s.ctx, result.Key)
+		s.Require().NoError(err)
+		s.Require().False(exists)
+	}
+}
+
+func (s *Suite) TestDeletePrefix() {
+	folder := fmt.Sprintf("%s/%s", s.bucketPrefix, uuid.New().String())
+	prefix := folder + "/"
+	keys := []string{prefix + "a.json", prefix + "b/c.json", prefix + "b/d.json"}
+	// shares the prefix without the delimiter
+	kept := folder + ".json"
+
+	for _, key := range append(keys, kept) {
+		s.write(key, []byte(`{"key": "value"}`))
+	}
+
+	var listed []string
+
+	result, err := s.storage.DeletePrefix(s.ctx, prefix, &commonblobgo.DeletePrefixOptions{
+		DryRun: true,
+		Progress: func(progress commonblobgo.DeletePrefixProgress) {
+			for _, r := range progress.Batch {
+				listed = append(listed, r.Key)
+			}
+		},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(len(keys), result.Deleted)
+	s.Require().Equal(keys, listed)
+
+	exists, err := s.storage.Exists(s.ctx, keys[0])
+	s.Require().NoError(err)
+	s.Require().True(exists)
+
+	var progressCalls int
+
+	result, err = s.storage.DeletePrefix(s.ctx, prefix, &commonblobgo.DeletePrefixOptions{
+		Concurrency: 4,
+		Progress: func(progress commonblobgo.DeletePrefixProgress) {
+			progressCalls++
+			s.Require().Equal(len(keys), progress.Deleted)
+		},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(len(keys), result.Deleted)
+	s.Require().Empty(result.Failed)
+	s.Require().Equal(1, progressCalls)
+
+	s.Require().Empty(s.listAll(s.storage.List(s.ctx, prefix)))
+
+	exists, err = s.storage.Exists(s.ctx, kept)
+	s.Require().NoError(err)
+	s.Require().True(exists)
+
+	s.Require().NoError(s.storage.Delete(s.ctx, kept))
+
+	_, err = s.storage.DeletePrefix(s.ctx, "", nil)
+	s.Require().True(errors.Is(err, commonblobgo.ErrInvalidArgument), "expected ErrInvalidArgument, got: %v", err)
+}
+
+func (s *Suite) TestCopy() {
+	srcFileName := s.GenerateFileName()
+	dstFileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+	contentType := "application/json"
+
+	err := s.storage.Write(s.ctx, srcFileName, body, &contentType)
+	s.Require().NoError(err)
+
+	err = s.storage.Copy(s.ctx, srcFileName, dstFileName, nil)
+	s.Require().NoError(err)
+
+	storedBody, err := s.storage.Get(s.ctx, dstFileName)
+	s.Require().NoError(err)
+	s.Require().Equal(body, storedBody)
+
+	attrs, err := s.storage.Attributes(s.ctx, dstFileName)
+	s.Require().NoError(err)
+	s.Require().Equal(contentType, attrs.ContentType)
+
+	// the source is kept
+	exists, err := s.storage.Exists(s.ctx, srcFileName)
+	s.Require().NoError(err)
+	s.Require().True(exists)
+
+	err = s.storage.Copy(s.ctx, srcFileName, dstFileName, &commonblobgo.CopyOptions{
+		Metadata:    map[string]string{"state": "copied"},
+		ContentType: "text/plain",
+	})
+	s.Require().NoError(err)
+
+	attrs, err = s.storage.Attributes(s.ctx, dstFileName)
+	s.Require().NoError(err)
+	s.Require().Equal("text/plain", attrs.ContentType)
+	s.Require().Equal(map[string]string{"state": "copied"}, attrs.Metadata)
+}
+
+func (s *Suite) TestMove() {
+	srcFileName := s.GenerateFileName()
+	dstFileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+
+	s.write(srcFileName, body)
+
+	err := s.storage.Move(s.ctx, srcFileName, dstFileName)
+	s.Require().NoError(err)
+
+	storedBody, err := s.storage.Get(s.ctx, dstFileName)
+	s.Require().NoError(err)
+	s.Require().Equal(body, storedBody)
+
+	exists, err := s.storage.Exists(s.ctx, srcFileName)
+	s.Require().NoError(err)
+	s.Require().False(exists)
+
+	// moving onto itself keeps the object
+	err = s.storage.Move(s.ctx, dstFileName, dstFileName)
+	s.Require().NoError(err)
+
+	exists, err = s.storage.Exists(s.ctx, dstFileName)
+	s.Require().NoError(err)
+	s.Require().True(exists)
+}
+
+func (s *Suite) TestUpdateAttributes() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+
+	err := s.storage.WriteWithOptions(s.ctx, fileName, body, &commonblobgo.WriteOptions{
+		CacheControl: "no-cache",
+		ContentType:  "application/json",
+		Metadata:     map[string]string{"owner": "user"},
+	})
+	s.Require().NoError(err)
+
+	cacheControl := "no-store"
+	contentDisposition := "attachment"
+
+	attrs, err := s.storage.UpdateAttributes(s.ctx, fileName, &commonblobgo.AttributesPatch{
+		CacheControl:       &cacheControl,
+		ContentDisposition: &contentDisposition,
+		Metadata:           map[string]string{"state": "delivered"},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(cacheControl, attrs.CacheControl)
+	s.Require().Equal(contentDisposition, attrs.ContentDisposition)
+	s.Require().Equal("application/json", attrs.ContentType)
+	s.Require().Equal(map[string]string{"owner": "user", "state": "delivered"}, attrs.Metadata)
+	s.Require().Equal(int64(len(body)), attrs.Size)
+
+	storedAttrs, err := s.storage.Attributes(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(attrs.CacheControl, storedAttrs.CacheControl)
+	s.Require().Equal(attrs.ContentDisposition, storedAttrs.ContentDisposition)
+	s.Require().Equal(attrs.Metadata, storedAttrs.Metadata)
+
+	storedBody, err := s.storage.Get(s.ctx, fileName)
+	s.Require().NoError(err)
+	s.Require().Equal(body, storedBody)
+}
+
+func (s *Suite) TestGetSignedURL() {
+	fileName := s.GenerateFileName()
+	body := []byte(`{"key": "value"}`)
+
+	s.write(fileName, body)
+
+	options := &commonblobgo.SignedURLOption{
+		Expiry:                   time.Hour,
+		Method:                   "GET",
+		ContentType:              "",
+		EnforceAbsentContentType: false,
+	}
+
+	url, err := s.storage.GetSignedURL(s.ctx, fileName, options)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(url)
+}
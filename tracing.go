@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/AccelByte/common-blob-go"
+
+// WithTracing wraps the storage into a decorator which starts an OpenTelemetry span for every call.
+// The spans are named "CloudStorage.<method>" and carry the blob.key, blob.bucket and blob.provider
+// attributes, plus blob.bytes, blob.offset, blob.length, blob.objects and blob.destination_key where they apply.
+// The spans of GetReader, GetRangeReader, GetWriter and GetWriterWithOptions end when the stream is closed,
+// the spans of List and ListWithOptions when the iterator is exhausted or fails.
+// The spans are created by the tracer provider, the global one if nil.
+func WithTracing(storage CloudStorage, tracerProvider trace.TracerProvider) CloudStorage {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
+	provider, bucket := describe(storage)
+
+	return newObservedCloudStorage(storage, provider, bucket, &tracingObserver{
+		tracer: tracerProvider.Tracer(tracerName),
+	})
+}
+
+type tracingObserver struct {
+	tracer trace.Tracer
+}
+
+func (o *tracingObserver) started(ctx context.Context, obs *observation) context.Context {
+	ctx, _ = o.tracer.Start(ctx, "CloudStorage."+obs.method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("blob.key", obs.key),
+			attribute.String("blob.bucket", obs.bucket),
+			attribute.String("blob.provider", obs.provider),
+		),
+	)
+
+	return ctx
+}
+
+func (o *tracingObserver) finished(ctx context.Context, obs *observation) {
+	span := trace.SpanFromContext(ctx)
+
+	if obs.destination != "" {
+		span.SetAttributes(attribute.String("blob.destination_key", obs.destination))
+	}
+
+	if obs.method == "GetRangeReader" {
+		span.SetAttributes(attribute.Int64("blob.offset", obs.offset), attribute.Int64("blob.length", obs.length))
+	}
+
+	if obs.bytes >= 0 {
+		span.SetAttributes(attribute.Int64("blob.bytes", obs.bytes))
+	}
+
+	if obs.objects >= 0 {
+		span.SetAttributes(attribute.Int("blob.objects", obs.objects))
+	}
+
+	if obs.err != nil {
+		span.SetAttributes(attribute.String("blob.error_code", Code(obs.err).String()))
+		span.RecordError(obs.err)
+		span.SetStatus(codes.Error, obs.err.Error())
+	}
+
+	span.End()
+}
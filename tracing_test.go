@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	storage := commonblobgo.WithTracing(
+		commonblobgo.NewMemoryCloudStorage("bucket"),
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	)
+
+	require.NoError(t, storage.Write(ctx, "user/1.json", []byte(`{"id":1}`), nil))
+
+	reader, err := storage.GetRangeReader(ctx, "user/1.json", 1, 4)
+	require.NoError(t, err)
+
+	// the span of a stream ends on Close
+	require.Len(t, recorder.Ended(), 1)
+
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	_, err = storage.Attributes(ctx, "user/2.json")
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		values := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			values[kv.Key] = kv.Value
+		}
+
+		return values
+	}
+
+	require.Equal(t, "CloudStorage.Write", spans[0].Name())
+	require.Equal(t, int64(8), attributes(spans[0])["blob.bytes"].AsInt64())
+
+	require.Equal(t, "CloudStorage.GetRangeReader", spans[1].Name())
+	rangeAttributes := attributes(spans[1])
+	require.Equal(t, "user/1.json", rangeAttributes["blob.key"].AsString())
+	require.Equal(t, "bucket", rangeAttributes["blob.bucket"].AsString())
+	require.Equal(t, "memory", rangeAttributes["blob.provider"].AsString())
+	require.Equal(t, int64(1), rangeAttributes["blob.offset"].AsInt64())
+	require.Equal(t, int64(4), rangeAttributes["blob.length"].AsInt64())
+	require.Equal(t, int64(4), rangeAttributes["blob.bytes"].AsInt64())
+
+	require.Equal(t, "CloudStorage.Attributes", spans[2].Name())
+	require.Equal(t, codes.Error, spans[2].Status().Code)
+	require.Equal(t, "not_found", attributes(spans[2])["blob.error_code"].AsString())
+	require.Len(t, spans[2].Events(), 1) // the recorded error
+}
+
+func TestTracingAbandonedList(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	storage := commonblobgo.WithTracing(
+		commonblobgo.NewMemoryCloudStorage("bucket"),
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	)
+
+	for _, key := range []string{"user/1.json", "user/2.json", "user/3.json"} {
+		require.NoError(t, storage.Write(ctx, key, []byte("{}"), nil))
+	}
+
+	objects := func(span sdktrace.ReadOnlySpan) int64 {
+		for _, kv := range span.Attributes() {
+			if kv.Key == "blob.objects" {
+				return kv.Value.AsInt64()
+			}
+		}
+
+		return -1
+	}
+
+	// Collect closes the iterator after the limit
+	items, err := storage.List(ctx, "user/").Collect(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	require.Equal(t, "CloudStorage.List", spans[3].Name())
+	require.Equal(t, int64(1), objects(spans[3]))
+	require.Equal(t, codes.Unset, spans[3].Status().Code)
+
+	// an iterator closed by hand ends its span once
+	list := storage.List(ctx, "user/")
+	_, err = list.Next(ctx)
+	require.NoError(t, err)
+
+	list.Close()
+	list.Close()
+
+	_, err = list.Next(ctx)
+	require.NoError(t, err)
+	require.Len(t, recorder.Ended(), 5)
+
+	// an abandoned iterator ends its span with its context
+	listCtx, cancel := context.WithCancel(ctx)
+	list = storage.List(listCtx, "user/")
+	_, err = list.Next(listCtx)
+	require.NoError(t, err)
+
+	cancel()
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 6
+	}, time.Second, time.Millisecond)
+
+	spans = recorder.Ended()
+	require.Equal(t, int64(1), objects(spans[5]))
+	require.Equal(t, codes.Error, spans[5].Status().Code)
+
+	// the helpers close the iterators they stop early
+	usageCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	_, err = commonblobgo.Usage(usageCtx, storage, "", nil)
+	require.NoError(t, err)
+	require.Len(t, recorder.Ended(), 8)
+}
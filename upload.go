@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	// gcsDefaultChunkSize is the chunk size of the GCS client, googleapi.DefaultUploadChunkSize
+	gcsDefaultChunkSize = 16 * 1024 * 1024
+	// uploadWriteSize is the size of the writes WriteWithOptions streams the body with, so the progress is reported
+	uploadWriteSize = 1024 * 1024
+	// s3AbortTimeout bounds the abort of a failed multipart upload, it isn't bound to the cancelled upload context
+	s3AbortTimeout = 30 * time.Second
+)
+
+// UploadOptions tunes the upload of large blobs, e.g. multi-GB exports.
+// The upload buffers up to PartSize × Concurrency bytes of content.
+//
+// S3 uploads the parts of a multipart upload in parallel, GCS uploads the chunks of a resumable upload one by one
+// and Azure uploads the blocks of a block blob in parallel. The file and memory storages only report the progress.
+type UploadOptions struct {
+	// PartSize is the size of the S3 parts, the GCS chunks and the Azure blocks, the provider default if 0.
+	// S3 parts are at least 5 MiB, GCS rounds the chunks up to a multiple of 256 KiB
+	PartSize int64
+	// Concurrency is the number of parts uploaded at the same time by S3 and Azure, the provider default if 0
+	Concurrency int
+	// MaxBufferSize caps the buffered content, PartSize and Concurrency are lowered to fit it, no cap if 0
+	MaxBufferSize int64
+	// Progress is called after every write with the number of bytes written so far.
+	// The writes block while the buffers are full, so the written bytes are at most the buffer size ahead
+	// of the uploaded ones. A retried WriteWithOptions reports the progress from 0 again
+	Progress func(written int64)
+}
+
+// upload returns the upload options, nil if there are none.
+func (o *WriteOptions) upload() *UploadOptions {
+	if o == nil {
+		return nil
+	}
+
+	return o.Upload
+}
+
+// validate checks the options, minPartSize is the smallest part size of the provider.
+func (o *UploadOptions) validate(minPartSize int64) error {
+	if o == nil {
+		return nil
+	}
+
+	if o.PartSize < 0 || o.Concurrency < 0 || o.MaxBufferSize < 0 {
+		return newError(CodeInvalidArgument, fmt.Errorf("upload options can't be negative"))
+	}
+
+	if o.PartSize > 0 && o.PartSize < minPartSize {
+		return newError(CodeInvalidArgument, fmt.Errorf("part size %d is below the minimum of %d", o.PartSize, minPartSize))
+	}
+
+	if o.MaxBufferSize > 0 && o.MaxBufferSize < minPartSize {
+		return newError(CodeInvalidArgument, fmt.Errorf("max buffer size %d is below the minimum part size of %d",
+			o.MaxBufferSize, minPartSize))
+	}
+
+	if o.PartSize > 0 && o.MaxBufferSize > 0 && o.PartSize > o.MaxBufferSize {
+		return newError(CodeInvalidArgument, fmt.Errorf("part size %d exceeds the max buffer size %d",
+			o.PartSize, o.MaxBufferSize))
+	}
+
+	return nil
+}
+
+// partSize returns the part size, the provider default lowered to MaxBufferSize if unset.
+func (o *UploadOptions) partSize(defaultPartSize int64) int64 {
+	if o == nil {
+		return defaultPartSize
+	}
+
+	if o.PartSize > 0 {
+		return o.PartSize
+	}
+
+	if o.MaxBufferSize > 0 && o.MaxBufferSize < defaultPartSize {
+		return o.MaxBufferSize
+	}
+
+	return defaultPartSize
+}
+
+// concurrency returns the number of parts uploaded at the same time, lowered to keep the buffers within MaxBufferSize.
+func (o *UploadOptions) concurrency(defaultPartSize int64, defaultConcurrency int) int {
+	if o == nil {
+		return defaultConcurrency
+	}
+
+	concurrency := defaultConcurrency
+	if o.Concurrency > 0 {
+		concurrency = o.Concurrency
+	}
+
+	if o.MaxBufferSize > 0 {
+		if fit := int(o.MaxBufferSize / o.partSize(defaultPartSize)); fit < concurrency {
+			concurrency = fit
+		}
+	}
+
+	if concurrency < 1 {
+		return 1
+	}
+
+	return concurrency
+}
+
+// writeByUpload streams the body through the writer of the storage, so the upload options apply to WriteWithOptions too.
+func writeByUpload(ctx context.Context, storage CloudStorage, key string, body []byte, opts *WriteOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := storage.GetWriterWithOptions(ctx, key, opts)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(body); start += uploadWriteSize {
+		end := start + uploadWriteSize
+		if end > len(body) {
+			end = len(body)
+		}
+
+		if _, err := writer.Write(body[start:end]); err != nil {
+			// the upload is aborted when its context is done before Close
+			cancel()
+			writer.Close()
+
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// newS3UploadWriter streams the written data into an upload of the S3 upload manager tuned by the upload options.
+// A failed multipart upload is aborted, so its parts aren't left behind, even when the context is done.
+func newS3UploadWriter(ctx context.Context, client *s3.S3, bucketName, key string, opts *WriteOptions) io.WriteCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	writer := &s3UploadWriter{
+		pipeWriter: pipeWriter,
+		done:       make(chan struct{}),
+	}
+
+	upload := opts.upload()
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = upload.partSize(s3manager.DefaultUploadPartSize)
+		u.Concurrency = upload.concurrency(s3manager.DefaultUploadPartSize, s3manager.DefaultUploadConcurrency)
+		// the upload manager aborts with the upload context, which is done already when the upload is cancelled
+		u.LeavePartsOnError = true
+	})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblock the upload when the caller gives up without Close
+			pipeReader.CloseWithError(ctx.Err())
+		case <-writer.done:
+		}
+	}()
+
+	go func() {
+		defer close(writer.done)
+
+		input := newS3UploadInput(bucketName, key, opts)
+
+		// detect the content type like gocloud.dev/blob does
+		head := make([]byte, 512)
+		n, err := io.ReadFull(pipeReader, head)
+
+		if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+			if input.ContentType == nil {
+				input.ContentType = aws.String(http.DetectContentType(head[:n]))
+			}
+
+			input.Body = io.MultiReader(bytes.NewReader(head[:n]), pipeReader)
+
+			_, err = uploader.UploadWithContext(ctx, input)
+			if err != nil {
+				abortS3Upload(client, bucketName, key, err)
+			}
+		}
+
+		writer.err = err
+		// unblock the caller when the upload failed before the whole body was consumed
+		pipeReader.CloseWithError(err)
+	}()
+
+	return writer
+}
+
+// newS3UploadInput converts the options, the metadata is escaped like gocloud.dev/blob does, so it reads it back.
+func newS3UploadInput(bucketName, key string, opts *WriteOptions) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+
+	if opts == nil {
+		return input
+	}
+
+	input.Metadata = escapeS3Metadata(opts.Metadata)
+
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+
+	if opts.ContentLanguage != "" {
+		input.ContentLanguage = aws.String(opts.ContentLanguage)
+	}
+
+	// the MD5 of the whole content isn't sent, S3 checks it only for single part uploads;
+	// it's verified by newOptionsWriter instead
+	return input
+}
+
+// escapeS3Metadata escapes the metadata like gocloud.dev/blob/s3blob does, so its Attributes unescape it back:
+// the keys and the values are URL-escaped, and the '@', ':' and '=' S3 rejects in the keys become "__0x<hex>__".
+func escapeS3Metadata(metadata map[string]string) map[string]*string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	escaped := make(map[string]*string, len(metadata))
+
+	for k, v := range metadata {
+		var key strings.Builder
+
+		for _, r := range url.PathEscape(k) {
+			if r == '@' || r == ':' || r == '=' {
+				fmt.Fprintf(&key, "__%#x__", r)
+				continue
+			}
+
+			key.WriteRune(r)
+		}
+
+		escaped[key.String()] = aws.String(url.PathEscape(v))
+	}
+
+	return escaped
+}
+
+// abortS3Upload aborts the multipart upload of the failed upload, if one was started.
+func abortS3Upload(client *s3.S3, bucketName, key string, err error) {
+	var failure s3manager.MultiUploadFailure
+	if !errors.As(err, &failure) || failure.UploadID() == "" {
+		return
+	}
+
+	abortS3UploadID(client, bucketName, key, failure.UploadID())
+}
+
+// abortS3UploadID aborts the multipart upload, it isn't bound to the context of the failed call.
+func abortS3UploadID(client *s3.S3, bucketName, key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3AbortTimeout)
+	defer cancel()
+
+	// an upload which can't be aborted is removed by the lifecycle rules of the bucket, if any
+	_, _ = client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+}
+
+// s3UploadWriter streams the written data into an upload running in the background.
+// The upload is completed on Close.
+type s3UploadWriter struct {
+	pipeWriter *io.PipeWriter
+	done       chan struct{}
+	err        error
+}
+
+func (w *s3UploadWriter) Write(p []byte) (int, error) {
+	return w.pipeWriter.Write(p)
+}
+
+func (w *s3UploadWriter) Close() error {
+	if err := w.pipeWriter.Close(); err != nil {
+		return err
+	}
+
+	<-w.done
+
+	return w.err
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+const uploadPartSize = 5 * 1024 * 1024
+
+// multipartServer is a fake S3 which accepts multipart uploads and fails the configured part.
+type multipartServer struct {
+	failedPart string
+
+	mu        sync.Mutex
+	inFlight  int
+	maxFlight int
+	partSizes map[string]int
+	completed bool
+	aborted   chan struct{}
+}
+
+func newMultipartServer(failedPart string) *multipartServer {
+	return &multipartServer{
+		failedPart: failedPart,
+		partSizes:  map[string]int{},
+		aborted:    make(chan struct{}),
+	}
+}
+
+func (s *multipartServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+
+	switch {
+	case r.Method == http.MethodPost && uploads:
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Get("partNumber") != "":
+		s.uploadPart(w, r, query.Get("partNumber"))
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		s.mu.Lock()
+		s.completed = true
+		s.mu.Unlock()
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodGet && query.Get("uploadId") == "upload":
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<ListPartsResult><Bucket>bucket</Bucket><Key>key</Key><UploadId>upload</UploadId></ListPartsResult>`)
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "upload":
+		close(s.aborted)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *multipartServer) uploadPart(w http.ResponseWriter, r *http.Request, partNumber string) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxFlight {
+		s.maxFlight = s.inFlight
+	}
+	s.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	// keep the parts in flight long enough to overlap
+	time.Sleep(50 * time.Millisecond)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.partSizes[partNumber] = len(body)
+	s.mu.Unlock()
+
+	if partNumber == s.failedPart {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+
+		return
+	}
+
+	w.Header().Set("ETag", `"part`+partNumber+`"`)
+}
+
+func newMultipartStorage(t *testing.T, server *multipartServer) commonblobgo.CloudStorage {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), false, "aws", "bucket",
+		commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        httpServer.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     "key",
+			AWSS3SecretAccessKey: "secret",
+		})
+	require.NoError(t, err)
+
+	return storage
+}
+
+// metadataServer is a fake S3 which keeps the metadata headers of the PUT object and returns them on HEAD.
+type metadataServer struct {
+	mu     sync.Mutex
+	header http.Header
+}
+
+func (s *metadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+
+		s.header = http.Header{}
+
+		for k, v := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				s.header[k] = v
+			}
+		}
+
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead:
+		for k, v := range s.header {
+			w.Header()[k] = v
+		}
+
+		w.Header().Set("Content-Length", "4")
+		w.Header().Set("ETag", `"etag"`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestUploadS3MetadataRoundTrip(t *testing.T) {
+	httpServer := httptest.NewServer(&metadataServer{})
+	t.Cleanup(httpServer.Close)
+
+	storage, err := commonblobgo.NewCloudStorageWithOption(context.Background(), false, "aws", "bucket",
+		commonblobgo.CloudStorageOption{
+			AWSS3Endpoint:        httpServer.URL,
+			AWSS3Region:          "us-west-2",
+			AWSS3AccessKeyID:     "key",
+			AWSS3SecretAccessKey: "secret",
+		})
+	require.NoError(t, err)
+
+	metadata := map[string]string{"ключ:é": "значение=1", "owner@team": "a b/c"}
+
+	err = storage.WriteWithOptions(context.Background(), "key", []byte("data"), &commonblobgo.WriteOptions{
+		Metadata: metadata,
+		Upload:   &commonblobgo.UploadOptions{PartSize: uploadPartSize},
+	})
+	require.NoError(t, err)
+
+	attributes, err := storage.Attributes(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, metadata, attributes.Metadata)
+}
+
+func TestUploadS3Multipart(t *testing.T) {
+	server := newMultipartServer("")
+	storage := newMultipartStorage(t, server)
+	body := bytes.Repeat([]byte{1}, 2*uploadPartSize+1024)
+
+	var written int64
+
+	err := storage.WriteWithOptions(context.Background(), "key", body, &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{
+			PartSize:    uploadPartSize,
+			Concurrency: 2,
+			Progress: func(n int64) {
+				written = n
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(body)), written)
+	require.True(t, server.completed)
+	require.Equal(t, map[string]int{"1": uploadPartSize, "2": uploadPartSize, "3": 1024}, server.partSizes)
+	require.LessOrEqual(t, server.maxFlight, 2)
+}
+
+func TestUploadS3MultipartMaxBufferSize(t *testing.T) {
+	server := newMultipartServer("")
+	storage := newMultipartStorage(t, server)
+
+	err := storage.WriteWithOptions(context.Background(), "key", bytes.Repeat([]byte{1}, 4*uploadPartSize),
+		&commonblobgo.WriteOptions{
+			Upload: &commonblobgo.UploadOptions{
+				PartSize:      uploadPartSize,
+				Concurrency:   4,
+				MaxBufferSize: uploadPartSize,
+			},
+		})
+	require.NoError(t, err)
+	require.Len(t, server.partSizes, 4)
+	require.Equal(t, 1, server.maxFlight)
+}
+
+func TestUploadS3MultipartAbortsFailedUpload(t *testing.T) {
+	server := newMultipartServer("2")
+	storage := newMultipartStorage(t, server)
+
+	err := storage.WriteWithOptions(context.Background(), "key", bytes.Repeat([]byte{1}, 3*uploadPartSize),
+		&commonblobgo.WriteOptions{
+			Upload: &commonblobgo.UploadOptions{PartSize: uploadPartSize},
+		})
+	require.True(t, errors.Is(err, commonblobgo.ErrPermissionDenied), "got: %v", err)
+	require.False(t, server.completed)
+
+	select {
+	case <-server.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the multipart upload wasn't aborted")
+	}
+}
+
+func TestUploadS3MultipartAbortsCancelledUpload(t *testing.T) {
+	server := newMultipartServer("")
+	storage := newMultipartStorage(t, server)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	writer, err := storage.GetWriterWithOptions(ctx, "key", &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: uploadPartSize},
+	})
+	require.NoError(t, err)
+
+	// the multipart upload is started once more than a part is written
+	_, err = io.Copy(writer, bytes.NewReader(bytes.Repeat([]byte{1}, uploadPartSize+1024)))
+	require.NoError(t, err)
+
+	// the caller gives up without Close
+	cancel()
+
+	select {
+	case <-server.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the multipart upload wasn't aborted")
+	}
+
+	require.Error(t, writer.Close())
+	require.False(t, server.completed)
+}
+
+func TestUploadS3PartSizeTooSmall(t *testing.T) {
+	storage := newMultipartStorage(t, newMultipartServer(""))
+
+	_, err := storage.GetWriterWithOptions(context.Background(), "key", &commonblobgo.WriteOptions{
+		Upload: &commonblobgo.UploadOptions{PartSize: 1024},
+	})
+	require.True(t, errors.Is(err, commonblobgo.ErrInvalidArgument), "got: %v", err)
+}
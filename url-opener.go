@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// URLOpener creates a CloudStorage from a URL of the scheme it is registered for.
+type URLOpener interface {
+	OpenCloudStorageURL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error)
+}
+
+// URLOpenerFunc is a function implementing URLOpener.
+type URLOpenerFunc func(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error)
+
+func (f URLOpenerFunc) OpenCloudStorageURL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error) {
+	return f(ctx, u, opts...)
+}
+
+var (
+	urlOpenersMu sync.RWMutex
+	urlOpeners   = map[string]URLOpener{
+		"s3":     URLOpenerFunc(openS3URL),
+		"gs":     URLOpenerFunc(openGCSURL),
+		"azblob": URLOpenerFunc(openAzureURL),
+		"file":   URLOpenerFunc(openFileURL),
+		"mem":    URLOpenerFunc(openMemoryURL),
+	}
+)
+
+// RegisterURLOpener makes OpenCloudStorage use the opener for URLs of the scheme.
+// It panics if the scheme is already registered, like sql.Register does.
+func RegisterURLOpener(scheme string, opener URLOpener) {
+	urlOpenersMu.Lock()
+	defer urlOpenersMu.Unlock()
+
+	if opener == nil {
+		panic("commonblobgo: RegisterURLOpener opener is nil")
+	}
+
+	if _, ok := urlOpeners[scheme]; ok {
+		panic(fmt.Sprintf("commonblobgo: RegisterURLOpener called twice for scheme %s", scheme))
+	}
+
+	urlOpeners[scheme] = opener
+}
+
+// OpenCloudStorage creates a storage from a URL, so a single config string selects the provider,
+// the bucket and the options:
+//
+//	s3://bucket?region=us-west-2&endpoint=http://localhost:4572&accelerate=true&access_key_id=...&secret_access_key=...
+//	gs://bucket?credentials_file=/path/to/credentials.json
+//	gs://bucket?emulator_host=localhost:4443
+//	azblob://container?account=name&key=...&endpoint=...
+//	file:///path/to/root/bucket?signing_key=...&base_url=https://example.com/blobs
+//	mem://bucket
+//
+// The built-in schemes accept testing=true to switch into the same test mode as NewCloudStorage's isTesting,
+// the GCS emulator host implies it. Unknown parameters are rejected.
+func OpenCloudStorage(ctx context.Context, urlstr string, opts ...Option) (CloudStorage, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		// the URL may contain secrets, so it isn't part of the error
+		return nil, fmt.Errorf("invalid storage URL")
+	}
+
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("invalid storage URL: scheme is required")
+	}
+
+	urlOpenersMu.RLock()
+	opener, ok := urlOpeners[u.Scheme]
+	urlOpenersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage URL scheme: %s", u.Scheme)
+	}
+
+	return opener.OpenCloudStorageURL(ctx, u, opts...)
+}
+
+func openS3URL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error) {
+	query := newURLQuery(u)
+	isTesting := query.bool("testing")
+	storageOpts := CloudStorageOption{
+		AWSS3Region:           query.string("region"),
+		AWSS3Endpoint:         query.string("endpoint"),
+		AWSEnableS3Accelerate: query.bool("accelerate"),
+		AWSS3AccessKeyID:      query.string("access_key_id"),
+		AWSS3SecretAccessKey:  query.string("secret_access_key"),
+	}
+
+	bucketName, err := query.bucketName()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCloudStorageWithOption(ctx, isTesting, "aws", bucketName, storageOpts, opts...)
+}
+
+func openGCSURL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error) {
+	query := newURLQuery(u)
+	isTesting := query.bool("testing")
+	credentialsFile := query.string("credentials_file")
+	storageOpts := CloudStorageOption{
+		GCPStorageEmulatorHost: query.string("emulator_host"),
+	}
+
+	bucketName, err := query.bucketName()
+	if err != nil {
+		return nil, err
+	}
+
+	if credentialsFile != "" {
+		credentialsJSON, err := ioutil.ReadFile(credentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read GCP credentials file: %v", err)
+		}
+
+		storageOpts.GCPCredentialsJSON = string(credentialsJSON)
+	}
+
+	if storageOpts.GCPStorageEmulatorHost != "" {
+		isTesting = true
+	}
+
+	return NewCloudStorageWithOption(ctx, isTesting, "gcp", bucketName, storageOpts, opts...)
+}
+
+func openAzureURL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error) {
+	query := newURLQuery(u)
+	isTesting := query.bool("testing")
+	storageOpts := CloudStorageOption{
+		AzureStorageAccountName: query.string("account"),
+		AzureStorageAccountKey:  query.string("key"),
+		AzureStorageEndpoint:    query.string("endpoint"),
+	}
+
+	bucketName, err := query.bucketName()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCloudStorageWithOption(ctx, isTesting, "azure", bucketName, storageOpts, opts...)
+}
+
+// openFileURL opens the bucket directory in the path, its parent is the root directory.
+func openFileURL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error) {
+	query := newURLQuery(u)
+	isTesting := query.bool("testing")
+	storageOpts := CloudStorageOption{
+		FileStorageSigningKey: query.string("signing_key"),
+		FileStorageBaseURL:    query.string("base_url"),
+	}
+
+	if err := query.err(); err != nil {
+		return nil, err
+	}
+
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("invalid file storage URL: file URLs can't have a host")
+	}
+
+	dir := filepath.Clean(filepath.FromSlash(u.Path))
+	storageOpts.FileStorageRootDir = filepath.Dir(dir)
+
+	return NewCloudStorageWithOption(ctx, isTesting, "file", filepath.Base(dir), storageOpts, opts...)
+}
+
+func openMemoryURL(ctx context.Context, u *url.URL, opts ...Option) (CloudStorage, error) {
+	query := newURLQuery(u)
+	isTesting := query.bool("testing")
+
+	bucketName, err := query.bucketName()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCloudStorageWithOption(ctx, isTesting, "memory", bucketName, CloudStorageOption{}, opts...)
+}
+
+// urlQuery consumes the URL parameters, so the unknown ones can be rejected.
+type urlQuery struct {
+	u        *url.URL
+	values   url.Values
+	parseErr error
+}
+
+func newURLQuery(u *url.URL) *urlQuery {
+	return &urlQuery{
+		u:      u,
+		values: u.Query(),
+	}
+}
+
+func (q *urlQuery) string(name string) string {
+	value := q.values.Get(name)
+	q.values.Del(name)
+
+	return value
+}
+
+func (q *urlQuery) bool(name string) bool {
+	value := q.string(name)
+	if value == "" {
+		return false
+	}
+
+	result, err := strconv.ParseBool(value)
+	if err != nil && q.parseErr == nil {
+		q.parseErr = fmt.Errorf("invalid %s storage URL: parameter %s must be a boolean", q.u.Scheme, name)
+	}
+
+	return result
+}
+
+// err reports the invalid and the unknown parameters.
+func (q *urlQuery) err() error {
+	if q.parseErr != nil {
+		return q.parseErr
+	}
+
+	if len(q.values) > 0 {
+		unknown := make([]string, 0, len(q.values))
+		for name := range q.values {
+			unknown = append(unknown, name)
+		}
+
+		sort.Strings(unknown)
+
+		return fmt.Errorf("invalid %s storage URL: unknown parameters %s", q.u.Scheme, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// bucketName returns the host of the URL, which holds the bucket name in all the cloud schemes.
+func (q *urlQuery) bucketName() (string, error) {
+	if err := q.err(); err != nil {
+		return "", err
+	}
+
+	if q.u.Host == "" {
+		return "", fmt.Errorf("invalid %s storage URL: bucket name is required", q.u.Scheme)
+	}
+
+	if q.u.Path != "" && q.u.Path != "/" {
+		return "", fmt.Errorf("invalid %s storage URL: the bucket name can't contain a path", q.u.Scheme)
+	}
+
+	return q.u.Host, nil
+}
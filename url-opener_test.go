@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	commonblobgo "github.com/AccelByte/common-blob-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenCloudStorage(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := commonblobgo.OpenCloudStorage(ctx, "mem://bucket")
+	require.NoError(t, err)
+	require.IsType(t, &commonblobgo.MemoryCloudStorage{}, storage)
+
+	rootDir, err := ioutil.TempDir("", "common-blob-go")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(rootDir)
+
+	storage, err = commonblobgo.OpenCloudStorage(ctx, "file://"+filepath.ToSlash(rootDir)+"/exports?signing_key=key&base_url=http://localhost/blobs")
+	require.NoError(t, err)
+	require.NoError(t, storage.Write(ctx, "user/1.json", []byte("{}"), nil))
+
+	_, err = os.Stat(filepath.Join(rootDir, "exports", "user", "1.json"))
+	require.NoError(t, err)
+
+	storage, err = commonblobgo.OpenCloudStorage(ctx, "s3://bucket?region=us-west-2&endpoint=http://localhost:4572&accelerate=false")
+	require.NoError(t, err)
+	require.IsType(t, &commonblobgo.AWSCloudStorage{}, storage)
+
+	storage, err = commonblobgo.OpenCloudStorage(ctx, "gs://bucket?emulator_host=localhost:4443")
+	require.NoError(t, err)
+	require.IsType(t, &commonblobgo.GCPTestCloudStorage{}, storage)
+
+	storage, err = commonblobgo.OpenCloudStorage(ctx, "azblob://container?account=devstoreaccount1")
+	require.NoError(t, err)
+	require.IsType(t, &commonblobgo.AzureCloudStorage{}, storage)
+
+	for _, invalid := range []string{
+		"bucket",
+		"ftp://bucket",
+		"mem://",
+		"mem://bucket/path",
+		"s3://bucket?region=us-west-2&acelerate=true",
+		"s3://bucket?region=us-west-2&accelerate=maybe",
+		"gs://bucket?credentials_file=/does/not/exist.json",
+	} {
+		_, err := commonblobgo.OpenCloudStorage(ctx, invalid)
+		require.Error(t, err, invalid)
+	}
+
+	// secrets in the URL don't leak into errors
+	_, err = commonblobgo.OpenCloudStorage(ctx, "s3://bucket?secret_access_key=very-secret&unknown=1")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "very-secret")
+}
+
+func TestRegisterURLOpener(t *testing.T) {
+	var opened *url.URL
+
+	commonblobgo.RegisterURLOpener("custom", commonblobgo.URLOpenerFunc(
+		func(ctx context.Context, u *url.URL, opts ...commonblobgo.Option) (commonblobgo.CloudStorage, error) {
+			opened = u
+			return commonblobgo.NewMemoryCloudStorage(u.Host), nil
+		}))
+
+	_, err := commonblobgo.OpenCloudStorage(context.Background(), "custom://bucket?option=value")
+	require.NoError(t, err)
+	require.Equal(t, "bucket", opened.Host)
+	require.Equal(t, "value", opened.Query().Get("option"))
+
+	require.Panics(t, func() {
+		commonblobgo.RegisterURLOpener("s3", commonblobgo.URLOpenerFunc(nil))
+	})
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UsageOptions controls Usage.
+type UsageOptions struct {
+	// Concurrency is the number of sub-prefixes listed at the same time, 1 if not positive
+	Concurrency int
+	// Delimiter separates the sub-prefixes, "/" if empty
+	Delimiter string
+}
+
+func (o *UsageOptions) concurrency() int {
+	if o == nil || o.Concurrency < 1 {
+		return 1
+	}
+
+	return o.Concurrency
+}
+
+func (o *UsageOptions) delimiter() string {
+	if o == nil || o.Delimiter == "" {
+		return "/"
+	}
+
+	return o.Delimiter
+}
+
+// PrefixUsage sums up the objects under a prefix.
+type PrefixUsage struct {
+	Prefix  string `json:"prefix"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+	// Oldest and Newest are the earliest and the latest modification time of the objects, zero without objects
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+}
+
+func (u *PrefixUsage) add(item *ListObject) {
+	u.Objects++
+	u.Bytes += item.Size
+
+	if u.Oldest.IsZero() || item.ModTime.Before(u.Oldest) {
+		u.Oldest = item.ModTime
+	}
+
+	if item.ModTime.After(u.Newest) {
+		u.Newest = item.ModTime
+	}
+}
+
+func (u *PrefixUsage) merge(other *PrefixUsage) {
+	u.Objects += other.Objects
+	u.Bytes += other.Bytes
+
+	if !other.Oldest.IsZero() && (u.Oldest.IsZero() || other.Oldest.Before(u.Oldest)) {
+		u.Oldest = other.Oldest
+	}
+
+	if other.Newest.After(u.Newest) {
+		u.Newest = other.Newest
+	}
+}
+
+// UsageReport is the storage usage of a prefix, see Usage.
+type UsageReport struct {
+	// Total sums up every object under the prefix
+	Total PrefixUsage `json:"total"`
+	// SubPrefixes sums up the objects of every sub-prefix, e.g. "tenants/a/" of "tenants/", sorted by prefix.
+	// The objects right under the prefix only count in Total
+	SubPrefixes []PrefixUsage `json:"subPrefixes"`
+}
+
+// Usage sums up the count, the size and the age of the objects under the prefix and of every one of its
+// sub-prefixes, the whole bucket if the prefix is empty. The sub-prefixes are listed up to the concurrency
+// of the options at the same time. Every object is listed, it takes a listing call per 1000 objects.
+// nolint:funlen
+func Usage(ctx context.Context, storage CloudStorage, prefix string, opts *UsageOptions) (*UsageReport, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	report := &UsageReport{
+		Total: PrefixUsage{Prefix: prefix},
+	}
+
+	subPrefixes := make(chan int)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		usageErr error
+	)
+
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range subPrefixes {
+				mu.Lock()
+				usage := PrefixUsage{Prefix: report.SubPrefixes[i].Prefix}
+				mu.Unlock()
+
+				err := storage.ListWithOptions(ctx, &ListOptions{Prefix: usage.Prefix}).ForEach(ctx, func(item *ListObject) error {
+					usage.add(item)
+					return nil
+				})
+
+				mu.Lock()
+
+				if err != nil && usageErr == nil {
+					usageErr = err
+					cancel()
+				}
+
+				report.SubPrefixes[i] = usage
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var direct PrefixUsage
+
+	listErr := storage.ListWithOptions(ctx, &ListOptions{
+		Prefix:    prefix,
+		Delimiter: opts.delimiter(),
+	}).ForEach(ctx, func(item *ListObject) error {
+		if !item.IsDir {
+			direct.add(item)
+			return nil
+		}
+
+		mu.Lock()
+		report.SubPrefixes = append(report.SubPrefixes, PrefixUsage{Prefix: item.Key})
+		i := len(report.SubPrefixes) - 1
+		mu.Unlock()
+
+		select {
+		case subPrefixes <- i:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	close(subPrefixes)
+	wg.Wait()
+
+	if usageErr != nil {
+		return nil, usageErr
+	}
+
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	report.Total.merge(&direct)
+
+	for i := range report.SubPrefixes {
+		report.Total.merge(&report.SubPrefixes[i])
+	}
+
+	// the listing is sorted already, except for the providers merging "directories" page by page
+	sort.Slice(report.SubPrefixes, func(i, j int) bool {
+		return report.SubPrefixes[i].Prefix < report.SubPrefixes[j].Prefix
+	})
+
+	return report, nil
+}
+
+// WriteCSV writes the report as CSV, a header, a row per sub-prefix and the total as the last row.
+// The times are RFC 3339 in UTC, empty without objects.
+func (r *UsageReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"prefix", "objects", "bytes", "oldest", "newest"}); err != nil {
+		return err
+	}
+
+	for _, usage := range append(append([]PrefixUsage(nil), r.SubPrefixes...), r.Total) {
+		err := writer.Write([]string{
+			usage.Prefix,
+			strconv.FormatInt(usage.Objects, 10),
+			strconv.FormatInt(usage.Bytes, 10),
+			formatUsageTime(usage.Oldest),
+			formatUsageTime(usage.Newest),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// WriteJSON writes the report as an indented JSON document.
+func (r *UsageReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+func formatUsageTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
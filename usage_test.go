@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUsageStorage(t *testing.T) (*MemoryCloudStorage, time.Time) {
+	storage := NewMemoryCloudStorage("bucket")
+	base := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, key := range []string{
+		"tenants/a/1",
+		"tenants/a/2/x",
+		"tenants/b/1",
+		"tenants/index",
+		"other",
+	} {
+		require.NoError(t, storage.Write(context.Background(), key, bytes.Repeat([]byte("x"), i+1), nil))
+
+		storage.objects[key].attrs.ModTime = base.Add(time.Duration(i) * time.Hour)
+	}
+
+	return storage, base
+}
+
+func TestUsage(t *testing.T) {
+	storage, base := newTestUsageStorage(t)
+
+	for _, concurrency := range []int{0, 1, 4} {
+		report, err := Usage(context.Background(), storage, "tenants/", &UsageOptions{Concurrency: concurrency})
+		require.NoError(t, err)
+
+		require.Equal(t, PrefixUsage{
+			Prefix:  "tenants/",
+			Objects: 4,
+			Bytes:   1 + 2 + 3 + 4,
+			Oldest:  base,
+			Newest:  base.Add(3 * time.Hour),
+		}, report.Total)
+
+		require.Equal(t, []PrefixUsage{
+			{Prefix: "tenants/a/", Objects: 2, Bytes: 3, Oldest: base, Newest: base.Add(time.Hour)},
+			{Prefix: "tenants/b/", Objects: 1, Bytes: 3, Oldest: base.Add(2 * time.Hour), Newest: base.Add(2 * time.Hour)},
+		}, report.SubPrefixes)
+	}
+
+	report, err := Usage(context.Background(), storage, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), report.Total.Objects)
+	require.Len(t, report.SubPrefixes, 1)
+
+	report, err = Usage(context.Background(), storage, "missing/", nil)
+	require.NoError(t, err)
+	require.Equal(t, PrefixUsage{Prefix: "missing/"}, report.Total)
+	require.Empty(t, report.SubPrefixes)
+}
+
+func TestUsageErrors(t *testing.T) {
+	storage, _ := newTestUsageStorage(t)
+
+	_, err := Usage(context.Background(), &failingListCloudStorage{MemoryCloudStorage: storage, prefix: "tenants/b/"},
+		"tenants/", &UsageOptions{Concurrency: 2})
+	require.True(t, errors.Is(err, ErrUnavailable), "got: %v", err)
+
+	_, err = Usage(context.Background(), &failingListCloudStorage{MemoryCloudStorage: storage, prefix: "tenants/"},
+		"tenants/", nil)
+	require.True(t, errors.Is(err, ErrUnavailable), "got: %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = Usage(ctx, storage, "tenants/", nil)
+	require.Error(t, err)
+}
+
+func TestUsageReportWrite(t *testing.T) {
+	storage, _ := newTestUsageStorage(t)
+
+	report, err := Usage(context.Background(), storage, "tenants/", nil)
+	require.NoError(t, err)
+
+	var csv bytes.Buffer
+	require.NoError(t, report.WriteCSV(&csv))
+	require.Equal(t, "prefix,objects,bytes,oldest,newest\n"+
+		"tenants/a/,2,3,2020-06-01T00:00:00Z,2020-06-01T01:00:00Z\n"+
+		"tenants/b/,1,3,2020-06-01T02:00:00Z,2020-06-01T02:00:00Z\n"+
+		"tenants/,4,10,2020-06-01T00:00:00Z,2020-06-01T03:00:00Z\n", csv.String())
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteJSON(&out))
+
+	var decoded UsageReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Equal(t, report.Total.Bytes, decoded.Total.Bytes)
+	require.True(t, report.Total.Newest.Equal(decoded.Total.Newest))
+	require.Len(t, decoded.SubPrefixes, 2)
+
+	csv.Reset()
+	require.NoError(t, (&UsageReport{Total: PrefixUsage{Prefix: "empty/"}}).WriteCSV(&csv))
+	require.Equal(t, "prefix,objects,bytes,oldest,newest\nempty/,0,0,,\n", csv.String())
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// SkipDir is returned by a WalkFunc to skip a "directory". Returned for an object,
+// it skips the rest of the directory holding the object, the subdirectories reported before the object included.
+var SkipDir = errors.New("commonblobgo: skip this directory") // nolint:golint,stylecheck
+
+// WalkFunc is called by Walk for every object and "directory" under the root, depth is 1 for the items
+// right under the root. When a directory fails to be listed, it's called again with the directory and the error,
+// the root directory has the root key and depth 0; returning nil or SkipDir then goes on with the other directories.
+// Any other error stops the walk and is returned by Walk.
+type WalkFunc func(item *ListObject, depth int, err error) error
+
+// WalkOptions controls Walk.
+type WalkOptions struct {
+	// Concurrency is the number of directories listed at the same time, 1 if not positive.
+	// The WalkFunc is called concurrently for the items of different directories when it's above 1
+	Concurrency int
+	// Delimiter separates the directories, "/" if empty
+	Delimiter string
+	// MaxDepth stops the walk at the directories of this depth, they are reported but not listed, no limit if 0
+	MaxDepth int
+}
+
+func (o *WalkOptions) concurrency() int {
+	if o == nil || o.Concurrency < 1 {
+		return 1
+	}
+
+	return o.Concurrency
+}
+
+func (o *WalkOptions) delimiter() string {
+	if o == nil || o.Delimiter == "" {
+		return "/"
+	}
+
+	return o.Delimiter
+}
+
+func (o *WalkOptions) maxDepth() int {
+	if o == nil {
+		return 0
+	}
+
+	return o.MaxDepth
+}
+
+// Walk lists the root prefix with a delimiter and descends into every listed "directory", the way
+// filepath.WalkDir walks a file tree. The root is a prefix, e.g. "users/", the whole bucket if empty.
+// The items of a directory are reported in lexicographical order, the directories are listed up to
+// the concurrency of the options at the same time, so their items interleave.
+func Walk(ctx context.Context, storage CloudStorage, root string, fn WalkFunc, opts *WalkOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walker{
+		storage: storage,
+		fn:      fn,
+		opts:    opts,
+		cancel:  cancel,
+		queue:   []walkDir{{key: root}},
+		pending: 1,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			w.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	return ctx.Err()
+}
+
+// walkDir is a directory waiting to be listed.
+type walkDir struct {
+	key   string
+	depth int
+}
+
+// walker shares the directories to list between the workers. The queue is a stack,
+// so the walk goes deep first and the queue stays short.
+type walker struct {
+	storage CloudStorage
+	fn      WalkFunc
+	opts    *WalkOptions
+	cancel  context.CancelFunc
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds the directories to list, pending counts them with the ones being listed
+	queue   []walkDir
+	pending int
+	err     error
+}
+
+func (w *walker) work(ctx context.Context) {
+	for {
+		w.mu.Lock()
+
+		for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+			w.cond.Wait()
+		}
+
+		if w.pending == 0 || w.err != nil {
+			w.mu.Unlock()
+			return
+		}
+
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+
+		w.mu.Unlock()
+
+		err := w.list(ctx, dir)
+
+		w.mu.Lock()
+
+		w.pending--
+
+		if err != nil && w.err == nil {
+			w.err = err
+			w.cancel()
+		}
+
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// list reports the items of the directory and queues its subdirectories once the directory is listed,
+// so SkipDir returned for an object can still drop them.
+func (w *walker) list(ctx context.Context, dir walkDir) error {
+	var (
+		fnErr error
+		dirs  []walkDir
+	)
+
+	err := w.storage.ListWithOptions(ctx, &ListOptions{
+		Prefix:    dir.key,
+		Delimiter: w.opts.delimiter(),
+	}).ForEach(ctx, func(item *ListObject) error {
+		depth := dir.depth + 1
+
+		fnErr = w.fn(item, depth, nil)
+		if fnErr == SkipDir && item.IsDir {
+			fnErr = nil
+			return nil
+		}
+
+		if fnErr != nil {
+			return fnErr
+		}
+
+		if item.IsDir && (w.opts.maxDepth() == 0 || depth < w.opts.maxDepth()) {
+			dirs = append(dirs, walkDir{key: item.Key, depth: depth})
+		}
+
+		return nil
+	})
+
+	if fnErr != nil {
+		if fnErr == SkipDir {
+			return nil
+		}
+
+		return fnErr
+	}
+
+	// the subdirectories listed before a failure are walked
+	w.push(dirs...)
+
+	// a done context stops the walk, it isn't a failure of the directory
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	if err := w.fn(&ListObject{Key: dir.key, IsDir: true}, dir.depth, err); err != nil && err != SkipDir {
+		return err
+	}
+
+	return nil
+}
+
+func (w *walker) push(dirs ...walkDir) {
+	if len(dirs) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.queue = append(w.queue, dirs...)
+	w.pending += len(dirs)
+	w.cond.Broadcast()
+}
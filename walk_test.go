@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWalkStorage(t *testing.T) *MemoryCloudStorage {
+	storage := NewMemoryCloudStorage("bucket")
+
+	for _, key := range []string{
+		"users/1/profile.json",
+		"users/1/exports/a.zip",
+		"users/1/exports/b.zip",
+		"users/2/profile.json",
+		"users/2/tmp/x",
+		"users/index.json",
+		"other.json",
+	} {
+		require.NoError(t, storage.Write(context.Background(), key, []byte("body"), nil))
+	}
+
+	return storage
+}
+
+// walkAll walks the root and returns the items as "depth:key", sorted as they interleave.
+func walkAll(t *testing.T, storage CloudStorage, root string, opts *WalkOptions, skip string) []string {
+	var (
+		mu    sync.Mutex
+		items []string
+	)
+
+	err := Walk(context.Background(), storage, root, func(item *ListObject, depth int, err error) error {
+		require.NoError(t, err)
+
+		mu.Lock()
+		items = append(items, fmt.Sprintf("%d:%s", depth, item.Key))
+		mu.Unlock()
+
+		if item.Key == skip {
+			return SkipDir
+		}
+
+		return nil
+	}, opts)
+	require.NoError(t, err)
+
+	sort.Strings(items)
+
+	return items
+}
+
+func TestWalk(t *testing.T) {
+	storage := newTestWalkStorage(t)
+
+	require.Equal(t, []string{
+		"1:users/1/",
+		"1:users/2/",
+		"1:users/index.json",
+		"2:users/1/exports/",
+		"2:users/1/profile.json",
+		"2:users/2/profile.json",
+		"2:users/2/tmp/",
+		"3:users/1/exports/a.zip",
+		"3:users/1/exports/b.zip",
+		"3:users/2/tmp/x",
+	}, walkAll(t, storage, "users/", &WalkOptions{Concurrency: 4}, ""))
+
+	// the skipped directory isn't listed
+	require.Equal(t, []string{
+		"1:users/1/",
+		"1:users/2/",
+		"1:users/index.json",
+		"2:users/1/exports/",
+		"2:users/1/profile.json",
+		"3:users/1/exports/a.zip",
+		"3:users/1/exports/b.zip",
+	}, walkAll(t, storage, "users/", nil, "users/2/"))
+
+	// skipping an object skips the rest of its directory
+	require.Equal(t, []string{
+		"1:users/1/",
+		"1:users/2/",
+		"1:users/index.json",
+		"2:users/1/exports/",
+		"2:users/1/profile.json",
+		"2:users/2/profile.json",
+		"3:users/1/exports/a.zip",
+		"3:users/1/exports/b.zip",
+	}, walkAll(t, storage, "users/", nil, "users/2/profile.json"))
+
+	// including the subdirectories reported before the object
+	require.Equal(t, []string{
+		"1:users/1/",
+		"1:users/2/",
+		"1:users/index.json",
+		"2:users/1/exports/",
+		"2:users/1/profile.json",
+		"2:users/2/profile.json",
+		"2:users/2/tmp/",
+		"3:users/2/tmp/x",
+	}, walkAll(t, storage, "users/", &WalkOptions{Concurrency: 4}, "users/1/profile.json"))
+
+	require.Equal(t, []string{
+		"1:users/1/",
+		"1:users/2/",
+		"1:users/index.json",
+		"2:users/1/exports/",
+		"2:users/1/profile.json",
+		"2:users/2/profile.json",
+		"2:users/2/tmp/",
+	}, walkAll(t, storage, "users/", &WalkOptions{MaxDepth: 2}, ""))
+
+	require.Len(t, walkAll(t, storage, "", &WalkOptions{Concurrency: 2}, ""), 12)
+}
+
+// failingListCloudStorage fails the listing of a prefix.
+type failingListCloudStorage struct {
+	*MemoryCloudStorage
+	prefix string
+}
+
+func (ts *failingListCloudStorage) ListWithOptions(ctx context.Context, listOptions *ListOptions) *ListIterator {
+	if listOptions.Prefix == ts.prefix {
+		return newListIterator(func(context.Context) (*ListObject, error) {
+			return nil, ErrUnavailable
+		})
+	}
+
+	return ts.MemoryCloudStorage.ListWithOptions(ctx, listOptions)
+}
+
+func TestWalkErrors(t *testing.T) {
+	storage := &failingListCloudStorage{MemoryCloudStorage: newTestWalkStorage(t), prefix: "users/2/"}
+
+	var failed []string
+
+	// the failed directory is reported and skipped
+	err := Walk(context.Background(), storage, "users/", func(item *ListObject, depth int, err error) error {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%d:%s", depth, item.Key))
+		}
+
+		return nil
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1:users/2/"}, failed)
+
+	// the error of the WalkFunc stops the walk
+	err = Walk(context.Background(), storage, "users/", func(item *ListObject, depth int, err error) error {
+		return err
+	}, &WalkOptions{Concurrency: 3})
+	require.True(t, errors.Is(err, ErrUnavailable), "got: %v", err)
+
+	stop := errors.New("stop")
+	calls := 0
+
+	err = Walk(context.Background(), storage, "users/", func(item *ListObject, depth int, err error) error {
+		calls++
+
+		if strings.HasSuffix(item.Key, ".zip") {
+			return stop
+		}
+
+		return nil
+	}, nil)
+	require.Equal(t, stop, err)
+	require.True(t, calls < 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = Walk(ctx, storage, "users/", func(item *ListObject, depth int, err error) error {
+		return io.ErrUnexpectedEOF
+	}, nil)
+	require.True(t, errors.Is(err, context.Canceled), "got: %v", err)
+}
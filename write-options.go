@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2020 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ *
+ */
+
+package commonblobgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // nolint:gosec
+	"fmt"
+	"hash"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"gocloud.dev/blob"
+)
+
+// WriteOptions sets the attributes of a written blob, see Attributes.
+type WriteOptions struct {
+	// CacheControl specifies caching attributes that services may use
+	// when serving the blob.
+	CacheControl string
+	// ContentDisposition specifies whether the blob content is expected to be
+	// displayed inline or as an attachment.
+	ContentDisposition string
+	// ContentEncoding specifies the encoding used for the blob's content, if any.
+	ContentEncoding string
+	// ContentLanguage specifies the language used in the blob's content, if any.
+	ContentLanguage string
+	// ContentType is the MIME type of the blob, it's detected from the content when empty.
+	ContentType string
+	// Metadata holds key/value pairs associated with the blob, the keys are lowercased by some providers.
+	Metadata map[string]string
+	// ContentMD5 is the expected MD5 hash of the content. When set, a blob with a different content
+	// isn't written and the write fails with ErrInvalidArgument.
+	ContentMD5 []byte
+	// Preconditions make the write fail with a *PreconditionError when the stored object doesn't match them
+	Preconditions *Preconditions
+	// Upload tunes the upload of large blobs and reports its progress
+	Upload *UploadOptions
+}
+
+// preconditions returns the preconditions of the options, nil if there are none.
+func (o *WriteOptions) preconditions() *Preconditions {
+	if o == nil {
+		return nil
+	}
+
+	return o.Preconditions
+}
+
+// newWriteOptions returns the options of the methods which take the content type only.
+func newWriteOptions(contentType *string) *WriteOptions {
+	opts := &WriteOptions{}
+	if contentType != nil {
+		opts.ContentType = *contentType
+	}
+
+	return opts
+}
+
+// writeOptionsOf returns the options which write a blob with the attributes.
+func writeOptionsOf(attrs *Attributes) *WriteOptions {
+	return &WriteOptions{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+	}
+}
+
+// attributes returns the attributes set by the options, the content properties are left to the storage.
+func (o *WriteOptions) attributes() Attributes {
+	if o == nil {
+		return Attributes{}
+	}
+
+	return Attributes{
+		CacheControl:       o.CacheControl,
+		ContentDisposition: o.ContentDisposition,
+		ContentEncoding:    o.ContentEncoding,
+		ContentLanguage:    o.ContentLanguage,
+		ContentType:        o.ContentType,
+		Metadata:           o.Metadata,
+	}
+}
+
+// blobWriterOptions converts the options for the providers based on gocloud.dev/blob.
+func (o *WriteOptions) blobWriterOptions() *blob.WriterOptions {
+	if o == nil {
+		return nil
+	}
+
+	opts := &blob.WriterOptions{
+		CacheControl:       o.CacheControl,
+		ContentDisposition: o.ContentDisposition,
+		ContentEncoding:    o.ContentEncoding,
+		ContentLanguage:    o.ContentLanguage,
+		ContentType:        o.ContentType,
+		Metadata:           o.Metadata,
+		ContentMD5:         o.ContentMD5,
+	}
+
+	// the GCS chunk size, S3 uploads with upload options are made by newS3UploadWriter
+	if o.Upload != nil {
+		opts.BufferSize = int(o.Upload.partSize(gcsDefaultChunkSize))
+	}
+
+	// S3 preconditions are sent by addS3ConditionalHeaders
+	if conditions := o.Preconditions.gcsConditions(); conditions != nil {
+		opts.BeforeWrite = func(asFunc func(interface{}) bool) error {
+			var object **storage.ObjectHandle
+			if asFunc(&object) {
+				*object = (*object).If(*conditions)
+			}
+
+			return nil
+		}
+	}
+
+	return opts
+}
+
+// verifyContentMD5 checks the body matches the expected MD5 hash.
+func (o *WriteOptions) verifyContentMD5(body []byte) error {
+	if o == nil || len(o.ContentMD5) == 0 {
+		return nil
+	}
+
+	sum := md5.Sum(body) // nolint:gosec
+
+	return checkContentMD5(o.ContentMD5, sum[:])
+}
+
+func checkContentMD5(expected, actual []byte) error {
+	if bytes.Equal(expected, actual) {
+		return nil
+	}
+
+	return newError(CodeInvalidArgument, fmt.Errorf("content MD5 %x doesn't match the expected %x", actual, expected))
+}
+
+// newOptionsWriter opens the writer with open, verifies the written content matches ContentMD5 of the options,
+// reports the upload progress and reports failed preconditions as a *PreconditionError on Close.
+// The context passed to open is cancelled on a mismatch, so the write is aborted, every writer of this package
+// discards the blob when its context is done before Close.
+func newOptionsWriter(
+	ctx context.Context,
+	key string,
+	opts *WriteOptions,
+	open func(ctx context.Context) (io.WriteCloser, error),
+) (io.WriteCloser, error) {
+	if err := opts.upload().validate(0); err != nil {
+		return nil, err
+	}
+
+	if opts == nil || (len(opts.ContentMD5) == 0 && opts.Preconditions == nil && opts.Upload == nil) {
+		return open(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	writer, err := open(ctx)
+	if err != nil {
+		cancel()
+		return nil, opts.Preconditions.wrapError(key, err)
+	}
+
+	optionsWriter := &optionsWriter{
+		writer:        writer,
+		cancel:        cancel,
+		key:           key,
+		preconditions: opts.Preconditions,
+	}
+
+	if len(opts.ContentMD5) > 0 {
+		optionsWriter.expectedMD5 = opts.ContentMD5
+		optionsWriter.hash = md5.New() // nolint:gosec
+	}
+
+	if opts.Upload != nil {
+		optionsWriter.progress = opts.Upload.Progress
+	}
+
+	return optionsWriter, nil
+}
+
+type optionsWriter struct {
+	writer        io.WriteCloser
+	cancel        context.CancelFunc
+	key           string
+	preconditions *Preconditions
+	// hash is nil when there is no expected MD5
+	expectedMD5 []byte
+	hash        hash.Hash
+	progress    func(written int64)
+	written     int64
+}
+
+func (w *optionsWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+
+	if w.hash != nil {
+		w.hash.Write(p[:n])
+	}
+
+	if w.progress != nil && n > 0 {
+		w.written += int64(n)
+		w.progress(w.written)
+	}
+
+	return n, err
+}
+
+func (w *optionsWriter) Close() error {
+	defer w.cancel()
+
+	if w.hash != nil {
+		if err := checkContentMD5(w.expectedMD5, w.hash.Sum(nil)); err != nil {
+			w.cancel()
+			w.writer.Close()
+
+			return err
+		}
+	}
+
+	return w.preconditions.wrapError(w.key, w.writer.Close())
+}